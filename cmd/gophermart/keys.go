@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andreevym/gophermart/internal/config"
+	"github.com/andreevym/gophermart/pkg/keyring"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// loadKeyRing loads the keys tokens are signed and verified with.
+//
+// The signing key is taken from JWTSigningKeyFile, then from JWTSecretKey. Without both a random key
+// is generated: tokens are not accepted after restart and by other replicas.
+func loadKeyRing(cfg *config.Config) (*keyring.KeyRing, error) {
+	verificationKeyFiles := make([]string, 0)
+	for _, file := range strings.Split(cfg.JWTVerificationKeyFiles, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			verificationKeyFiles = append(verificationKeyFiles, file)
+		}
+	}
+
+	var keyRing *keyring.KeyRing
+	var err error
+	switch {
+	case cfg.JWTSigningKeyFile != "":
+		keyRing, err = keyring.LoadFiles(cfg.JWTSigningKeyFile, verificationKeyFiles...)
+	case cfg.JWTSecretKey != "":
+		if len(verificationKeyFiles) > 0 {
+			return nil, fmt.Errorf("verification key files require the signing key file")
+		}
+		signingKey, parseErr := keyring.ParsePrivateKey([]byte(cfg.JWTSecretKey))
+		if parseErr != nil {
+			return nil, fmt.Errorf("parse JWT secret key: %w", parseErr)
+		}
+		keyRing, err = keyring.New(signingKey)
+	default:
+		logger.Logger().Warn("JWT signing key is not configured, a random key is generated, " +
+			"tokens are not accepted after restart and by other instances")
+		keyRing, err = keyring.Generate()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Logger().Info("JWT keys are loaded", zap.String("signingKeyID", keyRing.SigningKeyID()),
+		zap.Int("keys", len(keyRing.Keys())))
+	return keyRing, nil
+}
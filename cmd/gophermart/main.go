@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/andreevym/gophermart/internal/accrual"
 	"github.com/andreevym/gophermart/internal/config"
@@ -12,10 +15,28 @@ import (
 	"github.com/andreevym/gophermart/internal/scheduler"
 	"github.com/andreevym/gophermart/internal/server"
 	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	serveCommand     = "serve"
+	migrateCommand   = "migrate"
+	reconcileCommand = "reconcile"
+	unlockCommand    = "unlock"
+	roleCommand      = "role"
 )
 
 func main() {
+	// the first argument may be a command: gophermart [command] [flags] [args]
+	command := serveCommand
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	// Create a new configuration instance
 	cfg := config.NewConfig()
 
@@ -29,39 +50,126 @@ func main() {
 
 	ctx := context.Background()
 
-	db, err := pgxpool.Connect(ctx, cfg.DatabaseURI)
+	dbConfig, err := pgxpool.ParseConfig(cfg.DatabaseURI)
+	if err != nil {
+		log.Fatalf("Failed to parse database URI: %v", err)
+	}
+	// migrations report data the operator has to look at with RAISE NOTICE
+	dbConfig.ConnConfig.OnNotice = func(_ *pgconn.PgConn, notice *pgconn.Notice) {
+		logger.Logger().Warn("database notice", zap.String("message", notice.Message))
+	}
+	db, err := pgxpool.ConnectConfig(ctx, dbConfig)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer db.Close()
 
-	// Apply database migrations
-	err = postgres.Migration(ctx, db)
+	if command != migrateCommand {
+		// Apply database migrations
+		err = migrate(ctx, db, nil)
+		if err != nil {
+			log.Fatalf("Failed to apply database migrations: %v", err)
+		}
+	}
+
+	switch command {
+	case serveCommand:
+		serve(cfg, db)
+	case migrateCommand:
+		err = migrate(ctx, db, flag.Args())
+	case reconcileCommand:
+		err = reconcile(ctx, db, flag.Args())
+	case unlockCommand:
+		err = unlock(ctx, db, flag.Args())
+	case roleCommand:
+		err = role(ctx, db, flag.Args())
+	default:
+		log.Fatalf("Unknown command %q, expected one of: %s, %s, %s, %s, %s",
+			command, serveCommand, migrateCommand, reconcileCommand, unlockCommand, roleCommand)
+	}
 	if err != nil {
-		log.Fatalf("Failed to apply database migrations: %v", err)
+		log.Fatalf("Command %s failed: %v", command, err)
 	}
+}
 
+func serve(cfg *config.Config, db *pgxpool.Pool) {
 	// Create repositories
 	transactionRepository := postgres.NewTransactionRepository(db)
 	userRepository := postgres.NewUserRepository(db)
 	orderRepository := postgres.NewOrderRepository(db)
+	tokenRepository := postgres.NewTokenRepository(db)
+	sessionRepository := postgres.NewSessionRepository(db)
+	loginFailureRepository := postgres.NewLoginFailureRepository(db)
+	totpRepository := postgres.NewTOTPRepository(db)
 
 	// Create services
 	accrualService := accrual.NewAccrualService(cfg.AccrualSystemAddress)
 	userService := services.NewUserService(userRepository)
+	userService.PasswordPolicy = services.PasswordPolicy{
+		MinLength:     cfg.PasswordMinLength,
+		MaxLength:     cfg.PasswordMaxLength,
+		RequireLetter: cfg.PasswordRequireLetter,
+		RequireDigit:  cfg.PasswordRequireDigit,
+	}
 	transactionService := services.NewTransactionService(transactionRepository)
+	transactionService.HoldTTL = cfg.HoldTTL
 	orderService := services.NewOrderService(transactionService, orderRepository, accrualService)
+	orderService.RetryPolicy.BaseDelay = cfg.OrderRetryBaseDelay
+	orderService.RetryPolicy.MaxDelay = cfg.OrderRetryMaxDelay
+	orderService.PollInterval = cfg.OrderPollInterval
+	orderService.RegistrationTimeout = cfg.OrderRegistrationTimeout
+	orderService.LeaseDuration = cfg.OrderLeaseDuration
+	if cfg.InstanceID != "" {
+		orderService.InstanceID = cfg.InstanceID
+	}
 
-	jwtSecretKey := ""
-	authService := services.NewAuthService(userService, jwtSecretKey)
+	keyRing, err := loadKeyRing(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	loginThrottle := services.NewLoginThrottle(loginFailureRepository)
+	loginThrottle.LoginPolicy = services.LoginLockoutPolicy{
+		MaxFailures: cfg.LoginMaxFailures,
+		BaseDelay:   cfg.LoginLockoutBaseDelay,
+		MaxDelay:    cfg.LoginLockoutMaxDelay,
+	}
+	loginThrottle.IPPolicy = services.LoginLockoutPolicy{
+		MaxFailures: cfg.LoginIPMaxFailures,
+		BaseDelay:   cfg.LoginLockoutBaseDelay,
+		MaxDelay:    cfg.LoginLockoutMaxDelay,
+	}
+	loginThrottle.FailureWindow = cfg.LoginFailureWindow
+	authService := services.NewAuthService(userService, tokenRepository, sessionRepository, keyRing)
+	authService.TOTPRepository = totpRepository
+	authService.LoginThrottle = loginThrottle
+	authService.AccessTokenTTL = cfg.AccessTokenTTL
+	authService.RefreshTokenTTL = cfg.RefreshTokenTTL
+	authService.TOTPIssuer = cfg.TOTPIssuer
+	authService.WithdrawTOTPThreshold = cfg.WithdrawTOTPThreshold
 
 	// запуск отдельного процесса для процессинга заявок, только если при запуске сервиса был передан адрес accrualService
 	if accrualService != nil {
-		accrualScheduler := scheduler.NewAccrualScheduler(accrualService, orderService, cfg.PollOrdersDelay, cfg.MaxOrderAttempts)
+		// new orders wake the scheduler up immediately, PollOrdersDelay is only a fallback sweep
+		listenCtx, stopListening := context.WithCancel(context.Background())
+		defer stopListening()
+		newOrders := make(chan struct{}, 1)
+		go postgres.NewOrderListener(db).Listen(listenCtx, newOrders)
+
+		accrualScheduler := scheduler.NewAccrualScheduler(accrualService, orderService, cfg.PollOrdersDelay, cfg.AccrualWorkers, newOrders)
 		defer accrualScheduler.Shutdown()
 		accrualScheduler.Run()
 	}
 
+	// устаревшие резервы баллов помечаются истёкшими в фоне на каждом экземпляре сервиса
+	holdExpiryScheduler := scheduler.NewHoldExpiryScheduler(transactionService, cfg.HoldExpiryInterval)
+	defer holdExpiryScheduler.Shutdown()
+	holdExpiryScheduler.Run()
+
+	// истёкшие refresh токены и давно неактивные сессии удаляются в фоне на каждом экземпляре сервиса
+	sessionCleanupScheduler := scheduler.NewSessionCleanupScheduler(authService, cfg.SessionCleanupInterval)
+	defer sessionCleanupScheduler.Shutdown()
+	sessionCleanupScheduler.Run()
+
 	// объявляем все сервисы в одной структуре т.к так удобнее изменять кол-во сервисов
 	// которые мы будем использовать в обработчике
 	serviceHandlers := handlers.NewServiceHandlers(
@@ -81,7 +189,7 @@ func main() {
 
 	server := server.NewServer(router)
 	if server == nil {
-		log.Fatalf("Server can't be nil: %v", err)
+		log.Fatalf("Server can't be nil")
 	}
 	defer server.Shutdown()
 	server.Run(cfg.Address)
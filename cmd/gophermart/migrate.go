@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/migrations"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+const migrateUsage = "usage: gophermart migrate [flags] [up | down [steps] | status]"
+
+// migrate manages the database schema.
+//
+// Usage: gophermart migrate [flags] [up | down [steps] | status]
+//
+// "up" is the default and applies all pending migrations, "down" rolls back
+// the last steps migrations (one by default), "status" prints applied migrations.
+func migrate(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	migrator, err := postgres.NewMigrator(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps %q, %s", args[1], migrateUsage)
+			}
+		}
+		return migrator.Down(ctx, steps)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.String()
+			}
+			logger.Logger().Info(
+				"migration",
+				zap.Int64("version", status.Version),
+				zap.String("name", status.Name),
+				zap.String("appliedAt", appliedAt),
+			)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action %q, %s", action, migrateUsage)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+// reconcile recomputes account balances from the ledger and reports drifted accounts.
+//
+// Usage: gophermart reconcile [flags] [fix]
+//
+// With the "fix" argument drifted accounts are overwritten with the recomputed balances.
+func reconcile(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	fix := false
+	if len(args) > 0 {
+		if args[0] != "fix" {
+			return fmt.Errorf("unexpected argument %q, usage: gophermart reconcile [flags] [fix]", args[0])
+		}
+		fix = true
+	}
+
+	transactionService := services.NewTransactionService(postgres.NewTransactionRepository(db))
+	drifts, err := transactionService.ReconcileAccounts(ctx, fix)
+	if err != nil {
+		return err
+	}
+
+	for _, drift := range drifts {
+		logger.Logger().Warn(
+			"account drift",
+			zap.Int64("userID", drift.UserID),
+			zap.Stringer("current", drift.Current),
+			zap.Stringer("expectedCurrent", drift.ExpectedCurrent),
+			zap.Stringer("withdrawn", drift.Withdrawn),
+			zap.Stringer("expectedWithdrawn", drift.ExpectedWithdrawn),
+		)
+	}
+	logger.Logger().Info("accounts reconciled", zap.Int("drifted", len(drifts)), zap.Bool("fixed", fix))
+
+	return nil
+}
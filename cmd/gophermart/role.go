@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+// role grants a role to a user or revokes it, the change applies to access tokens issued
+// after the next login or token refresh of the user.
+//
+// Usage: gophermart role [flags] grant|revoke <login> <role>
+func role(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	if len(args) != 3 || (args[0] != "grant" && args[0] != "revoke") {
+		return fmt.Errorf("unexpected arguments %q, usage: gophermart role [flags] grant|revoke <login> <role>", args)
+	}
+	action, login, roleName := args[0], args[1], args[2]
+
+	userService := services.NewUserService(postgres.NewUserRepository(db))
+	var changed bool
+	var err error
+	if action == "grant" {
+		changed, err = userService.GrantRole(ctx, login, roleName)
+	} else {
+		changed, err = userService.RevokeRole(ctx, login, roleName)
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Logger().Info("role "+action,
+		zap.String("login", login), zap.String("role", roleName), zap.Bool("changed", changed))
+	return nil
+}
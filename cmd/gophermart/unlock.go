@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+// unlock forgets the failed login attempts and the lockout of a login or a client address.
+//
+// Usage: gophermart unlock [flags] login|ip <key>
+func unlock(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("unexpected arguments %q, usage: gophermart unlock [flags] login|ip <key>", args)
+	}
+	scope, key := args[0], args[1]
+
+	loginThrottle := services.NewLoginThrottle(postgres.NewLoginFailureRepository(db))
+	unlocked, err := loginThrottle.Unlock(ctx, scope, key)
+	if err != nil {
+		return err
+	}
+
+	logger.Logger().Info("login unlocked", zap.String("scope", scope), zap.String("key", key), zap.Bool("hadFailures", unlocked))
+	return nil
+}
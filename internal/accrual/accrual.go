@@ -1,21 +1,98 @@
 package accrual
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
+	"go.uber.org/zap"
 )
 
 var ErrAccrualServiceDisabled = errors.New("accrual service is disabled because url is not set")
 
+// statuses of the accrual calculation, INVALID and PROCESSED are final
+const (
+	// StatusRegistered заказ зарегистрирован, но начисление не рассчитано;
+	StatusRegistered = "REGISTERED"
+	// StatusInvalid заказ не принят к расчёту, и вознаграждение не будет начислено;
+	StatusInvalid = "INVALID"
+	// StatusProcessing расчёт начисления в процессе;
+	StatusProcessing = "PROCESSING"
+	// StatusProcessed расчёт начисления окончен;
+	StatusProcessed = "PROCESSED"
+)
+
+// requestTimeout bounds a request to the accrual system, a hung connection must not block the workers
+const requestTimeout = 10 * time.Second
+
+// defaultRetryAfter is used when a 429 response has no valid Retry-After header
+const defaultRetryAfter = 60 * time.Second
+
+// rateLimitPattern matches the body of a 429 response: "No more than N requests per minute allowed"
+var rateLimitPattern = regexp.MustCompile(`(\d+) requests per minute`)
+
+// ResultStatus is the outcome of a request to the accrual system
+type ResultStatus int
+
+const (
+	// ResultOK the accrual system knows the order, Result.Accrual is set
+	ResultOK ResultStatus = iota
+	// ResultNotRegistered the order is not registered in the accrual system yet (204 No Content)
+	ResultNotRegistered
+	// ResultRateLimited the accrual system has asked to wait for Result.RetryAfter (429 Too Many Requests)
+	ResultRateLimited
+	// ResultServerError the accrual system has failed to answer (5xx)
+	ResultServerError
+)
+
+func (s ResultStatus) String() string {
+	switch s {
+	case ResultOK:
+		return "ok"
+	case ResultNotRegistered:
+		return "not registered"
+	case ResultRateLimited:
+		return "rate limited"
+	case ResultServerError:
+		return "server error"
+	default:
+		return fmt.Sprintf("ResultStatus(%d)", int(s))
+	}
+}
+
+// Result is a response of the accrual system.
+type Result struct {
+	Status ResultStatus
+	// Accrual is set only for ResultOK
+	Accrual *OrderAccrual
+	// RetryAfter is set only for ResultRateLimited
+	RetryAfter time.Duration
+	// RequestsPerMinute is the limit advertised with ResultRateLimited, zero if the response has not mentioned it
+	RequestsPerMinute int
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+}
+
 type AccrualService struct {
-	url string
+	url     string
+	client  *http.Client
+	limiter *RateLimiter
 }
 
 func NewAccrualService(url string) *AccrualService {
-	return &AccrualService{url: url}
+	return &AccrualService{
+		url:     url,
+		client:  &http.Client{Timeout: requestTimeout},
+		limiter: NewRateLimiter(),
+	}
 }
 
 type OrderAccrual struct {
@@ -24,18 +101,36 @@ type OrderAccrual struct {
 	// Status статус расчёта начисления
 	Status string `json:"status"`
 	// Accrual рассчитанные баллы к начислению, при отсутствии начисления — поле отсутствует в ответе.
-	Accrual float32 `json:"accrual"`
+	Accrual money.Amount `json:"accrual"`
+}
+
+// PausedUntil returns the time until which requests are paused after a 429 response,
+// it is in the past when requests are allowed.
+func (as AccrualService) PausedUntil() time.Time {
+	return as.limiter.PausedUntil()
 }
 
 // RequestAccrualByOrderNumber получение информации о расчёте начислений баллов лояльности.
-func (as AccrualService) RequestAccrualByOrderNumber(orderNumber string) (*OrderAccrual, error) {
+// The error is returned only when the accrual system has not given a meaningful response,
+// all outcomes of the protocol are reported by Result.Status.
+func (as AccrualService) RequestAccrualByOrderNumber(ctx context.Context, orderNumber string) (*Result, error) {
 	if as.url == "" {
 		return nil, ErrAccrualServiceDisabled
 	}
+
+	err := as.limiter.Wait(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("wait for rate limiter: %w", err)
+	}
+
 	url := fmt.Sprintf("%s/api/orders/%s", as.url, orderNumber)
-	response, err := http.Get(url)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	response, err := as.client.Do(request)
 	if err != nil {
-		return nil, fmt.Errorf("http.Post: %w", err)
+		return nil, fmt.Errorf("http.Get: %w", err)
 	}
 
 	defer response.Body.Close()
@@ -45,8 +140,16 @@ func (as AccrualService) RequestAccrualByOrderNumber(orderNumber string) (*Order
 		return nil, fmt.Errorf("read response io.ReadAll: %w", err)
 	}
 
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to request: %s", url)
+	switch {
+	case response.StatusCode == http.StatusOK:
+	case response.StatusCode == http.StatusNoContent:
+		return &Result{Status: ResultNotRegistered, StatusCode: response.StatusCode}, nil
+	case response.StatusCode == http.StatusTooManyRequests:
+		return as.handleTooManyRequests(response, readAll), nil
+	case response.StatusCode >= http.StatusInternalServerError:
+		return &Result{Status: ResultServerError, StatusCode: response.StatusCode}, nil
+	default:
+		return nil, fmt.Errorf("failed to request: %s, unexpected status code %d", url, response.StatusCode)
 	}
 
 	orderAccrual := OrderAccrual{}
@@ -63,5 +166,44 @@ func (as AccrualService) RequestAccrualByOrderNumber(orderNumber string) (*Order
 		)
 	}
 
-	return &orderAccrual, nil
+	return &Result{Status: ResultOK, Accrual: &orderAccrual, StatusCode: response.StatusCode}, nil
+}
+
+// handleTooManyRequests pauses all requests until Retry-After and adopts the advertised rate
+func (as AccrualService) handleTooManyRequests(response *http.Response, body []byte) *Result {
+	result := &Result{
+		Status:     ResultRateLimited,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), time.Now()),
+		StatusCode: response.StatusCode,
+	}
+	if match := rateLimitPattern.FindSubmatch(body); match != nil {
+		result.RequestsPerMinute, _ = strconv.Atoi(string(match[1]))
+	}
+
+	as.limiter.Pause(time.Now().Add(result.RetryAfter))
+	if result.RequestsPerMinute > 0 {
+		as.limiter.SetRate(result.RequestsPerMinute)
+	}
+
+	logger.Logger().Warn(
+		"accrual service rate limit exceeded, requests are paused",
+		zap.Duration("retryAfter", result.RetryAfter),
+		zap.Int("requestsPerMinute", result.RequestsPerMinute),
+	)
+
+	return result
+}
+
+// parseRetryAfter parses Retry-After in delay-seconds or HTTP-date form
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := at.Sub(now); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+	return defaultRetryAfter
 }
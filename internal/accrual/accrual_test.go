@@ -0,0 +1,115 @@
+package accrual
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestAccrualByOrderNumberTooManyRequests(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte("No more than 120 requests per minute allowed"))
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"order":"12345678903","status":"PROCESSED","accrual":500.5}`)
+	}))
+	defer server.Close()
+
+	service := NewAccrualService(server.URL)
+
+	result, err := service.RequestAccrualByOrderNumber(context.Background(), "12345678903")
+	require.NoError(t, err)
+	assert.Equal(t, ResultRateLimited, result.Status)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 120, result.RequestsPerMinute)
+	assert.True(t, service.PausedUntil().After(time.Now()))
+
+	// a request with a shorter deadline than the pause is not sent
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = service.RequestAccrualByOrderNumber(ctx, "12345678903")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	started := time.Now()
+	result, err = service.RequestAccrualByOrderNumber(context.Background(), "12345678903")
+	require.NoError(t, err)
+	require.Equal(t, ResultOK, result.Status)
+	assert.Equal(t, StatusProcessed, result.Accrual.Status)
+	assert.Equal(t, "500.5", result.Accrual.Accrual.String())
+	assert.False(t, started.Add(500*time.Millisecond).After(time.Now()), "request must wait for the pause")
+}
+
+func TestRequestAccrualByOrderNumberResultStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		want       ResultStatus
+		wantErr    bool
+	}{
+		{name: "not registered", statusCode: http.StatusNoContent, want: ResultNotRegistered},
+		{name: "server error", statusCode: http.StatusInternalServerError, want: ResultServerError},
+		{name: "bad gateway", statusCode: http.StatusBadGateway, want: ResultServerError},
+		{name: "unexpected status", statusCode: http.StatusBadRequest, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			result, err := NewAccrualService(server.URL).RequestAccrualByOrderNumber(context.Background(), "12345678903")
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, result.Status)
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			assert.Nil(t, result.Accrual)
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "seconds", value: "60", want: time.Minute},
+		{name: "http date", value: now.Add(30 * time.Second).Format(http.TimeFormat), want: 30 * time.Second},
+		{name: "http date in the past", value: now.Add(-time.Second).Format(http.TimeFormat), want: 0},
+		{name: "missing", value: "", want: defaultRetryAfter},
+		{name: "invalid", value: "soon", want: defaultRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRetryAfter(tt.value, now))
+		})
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	limiter := NewRateLimiter()
+	limiter.SetRate(600)
+
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, limiter.Wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(started), 200*time.Millisecond)
+}
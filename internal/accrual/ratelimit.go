@@ -0,0 +1,84 @@
+package accrual
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter paces all outbound requests of the process to the accrual system.
+// It follows the limits advertised by 429 responses: it pauses every request
+// until Retry-After and then keeps the advertised "N requests per minute" rate.
+type RateLimiter struct {
+	mu          sync.Mutex
+	pausedUntil time.Time
+	// interval is the minimal interval between requests, zero means unlimited
+	interval time.Duration
+	// next is the earliest time of the next request
+	next time.Time
+}
+
+// NewRateLimiter creates a rate limiter without any limit.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{}
+}
+
+// Wait blocks until a request is allowed or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		at := now
+		if l.pausedUntil.After(at) {
+			at = l.pausedUntil
+		}
+		if l.next.After(at) {
+			at = l.next
+		}
+		// reserve the slot only when it has come, a pause may be extended meanwhile
+		if !at.After(now) {
+			l.next = now.Add(l.interval)
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(at.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Pause holds all requests until the given time.
+func (l *RateLimiter) Pause(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// SetRate limits requests to requestsPerMinute, zero or negative value removes the limit.
+func (l *RateLimiter) SetRate(requestsPerMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if requestsPerMinute <= 0 {
+		l.interval = 0
+		return
+	}
+	l.interval = time.Minute / time.Duration(requestsPerMinute)
+}
+
+// PausedUntil returns the end of the current pause, it is in the past when requests are not paused.
+func (l *RateLimiter) PausedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pausedUntil
+}
@@ -9,19 +9,44 @@ import (
 	"time"
 
 	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
 	"github.com/caarlos0/env"
 	"go.uber.org/zap"
 )
 
 // Config represents the application configuration.
 type Config struct {
-	Address              string        `json:"address" env:"RUN_ADDRESS"`
-	DatabaseURI          string        `json:"databaseURI" env:"DATABASE_URI"`
-	AccrualSystemAddress string        `json:"accrualSystemAddress" env:"ACCRUAL_SYSTEM_ADDRESS"`
-	LogLevel             string        `json:"logLevel" env:"LOG_LEVEL"`
-	PollOrdersDelay      time.Duration `json:"pollDuration" env:"POLL_ORDERS_DURATION"`
-	MaxOrderAttempts     int           `json:"maxOrderAttempts" env:"MAX_ORDER_ATTEMPTS"`
-	JWTSecretKey         string        `json:"secretKey" env:"JWT_SECRET_KEY"`
+	Address                  string        `json:"address" env:"RUN_ADDRESS"`
+	DatabaseURI              string        `json:"databaseURI" env:"DATABASE_URI"`
+	AccrualSystemAddress     string        `json:"accrualSystemAddress" env:"ACCRUAL_SYSTEM_ADDRESS"`
+	LogLevel                 string        `json:"logLevel" env:"LOG_LEVEL"`
+	PollOrdersDelay          time.Duration `json:"pollDuration" env:"POLL_ORDERS_DURATION"`
+	OrderPollInterval        time.Duration `json:"orderPollInterval" env:"ORDER_POLL_INTERVAL"`
+	OrderRetryBaseDelay      time.Duration `json:"orderRetryBaseDelay" env:"ORDER_RETRY_BASE_DELAY"`
+	OrderRetryMaxDelay       time.Duration `json:"orderRetryMaxDelay" env:"ORDER_RETRY_MAX_DELAY"`
+	OrderRegistrationTimeout time.Duration `json:"orderRegistrationTimeout" env:"ORDER_REGISTRATION_TIMEOUT"`
+	AccrualWorkers           int           `json:"accrualWorkers" env:"ACCRUAL_WORKERS"`
+	OrderLeaseDuration       time.Duration `json:"orderLeaseDuration" env:"ORDER_LEASE_DURATION"`
+	InstanceID               string        `json:"instanceID" env:"INSTANCE_ID"`
+	JWTSecretKey             string        `json:"secretKey" env:"JWT_SECRET_KEY"`
+	JWTSigningKeyFile        string        `json:"jwtSigningKeyFile" env:"JWT_SIGNING_KEY_FILE"`
+	JWTVerificationKeyFiles  string        `json:"jwtVerificationKeyFiles" env:"JWT_VERIFICATION_KEY_FILES"`
+	AccessTokenTTL           time.Duration `json:"accessTokenTTL" env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL          time.Duration `json:"refreshTokenTTL" env:"REFRESH_TOKEN_TTL"`
+	LoginMaxFailures         int           `json:"loginMaxFailures" env:"LOGIN_MAX_FAILURES"`
+	LoginIPMaxFailures       int           `json:"loginIPMaxFailures" env:"LOGIN_IP_MAX_FAILURES"`
+	LoginLockoutBaseDelay    time.Duration `json:"loginLockoutBaseDelay" env:"LOGIN_LOCKOUT_BASE_DELAY"`
+	LoginLockoutMaxDelay     time.Duration `json:"loginLockoutMaxDelay" env:"LOGIN_LOCKOUT_MAX_DELAY"`
+	LoginFailureWindow       time.Duration `json:"loginFailureWindow" env:"LOGIN_FAILURE_WINDOW"`
+	TOTPIssuer               string        `json:"totpIssuer" env:"TOTP_ISSUER"`
+	WithdrawTOTPThreshold    money.Amount  `json:"withdrawTOTPThreshold" env:"WITHDRAW_TOTP_THRESHOLD"`
+	PasswordMinLength        int           `json:"passwordMinLength" env:"PASSWORD_MIN_LENGTH"`
+	PasswordMaxLength        int           `json:"passwordMaxLength" env:"PASSWORD_MAX_LENGTH"`
+	PasswordRequireLetter    bool          `json:"passwordRequireLetter" env:"PASSWORD_REQUIRE_LETTER"`
+	PasswordRequireDigit     bool          `json:"passwordRequireDigit" env:"PASSWORD_REQUIRE_DIGIT"`
+	HoldTTL                  time.Duration `json:"holdTTL" env:"HOLD_TTL"`
+	HoldExpiryInterval       time.Duration `json:"holdExpiryInterval" env:"HOLD_EXPIRY_INTERVAL"`
+	SessionCleanupInterval   time.Duration `json:"sessionCleanupInterval" env:"SESSION_CLEANUP_INTERVAL"`
 }
 
 // NewConfig creates a new Config instance with default values.
@@ -43,12 +68,44 @@ func (c *Config) Parse() error {
 	flag.StringVar(&c.DatabaseURI, "d", "", "Database URI (overrides environment variable)")
 	flag.StringVar(&c.AccrualSystemAddress, "r", "", "Accrual System Address (overrides environment variable)")
 	flag.StringVar(&c.LogLevel, "l", "info", "Logging level [INFO, DEBUG, ERROR]")
-	flag.IntVar(&c.MaxOrderAttempts, "maxOrderAttempts", 3, "Logging level [INFO, DEBUG, ERROR]")
-	flag.DurationVar(&c.PollOrdersDelay, "pollOrdersDuration", 10*time.Millisecond, "duration for handle orders")
-	flag.StringVar(&c.JWTSecretKey, "j", "", "JWTConfig SecretKey")
+	flag.DurationVar(&c.OrderRetryBaseDelay, "orderRetryBaseDelay", time.Second, "delay before the first retry of a failed order, doubles with every attempt")
+	flag.DurationVar(&c.OrderRetryMaxDelay, "orderRetryMaxDelay", 5*time.Minute, "max delay between retries of a failed order")
+	flag.DurationVar(&c.OrderRegistrationTimeout, "orderRegistrationTimeout", 24*time.Hour, "how long an order unknown to the accrual system is retried before it is marked INVALID, 0 retries forever")
+	flag.IntVar(&c.AccrualWorkers, "accrualWorkers", 4, "number of orders processed concurrently")
+	flag.DurationVar(&c.OrderLeaseDuration, "orderLeaseDuration", time.Minute, "how long an order claimed by this instance is kept from other instances")
+	flag.StringVar(&c.InstanceID, "instanceID", "", "unique name of this instance among replicas, generated if empty")
+	flag.DurationVar(&c.PollOrdersDelay, "pollOrdersDuration", time.Second, "delay between sweeps for due orders, new orders are processed immediately")
+	flag.DurationVar(&c.OrderPollInterval, "orderPollInterval", time.Second, "delay between polls of an order the accrual system is still calculating")
+	flag.StringVar(&c.JWTSecretKey, "j", "", "JWTConfig SecretKey, PEM or DER encoded ECDSA P-256 private key, jwtSigningKeyFile is preferred")
+	flag.StringVar(&c.JWTSigningKeyFile, "jwtSigningKeyFile", "", "PEM file of the ECDSA P-256 private key tokens are signed with")
+	flag.StringVar(&c.JWTVerificationKeyFiles, "jwtVerificationKeyFiles", "", "comma separated PEM files of previous keys tokens are still verified with")
+	flag.DurationVar(&c.AccessTokenTTL, "accessTokenTTL", 15*time.Minute, "lifetime of an access token")
+	flag.DurationVar(&c.RefreshTokenTTL, "refreshTokenTTL", 30*24*time.Hour, "lifetime of a refresh token")
+	flag.IntVar(&c.LoginMaxFailures, "loginMaxFailures", 5, "failed login attempts in a row for one login allowed before a lockout")
+	flag.IntVar(&c.LoginIPMaxFailures, "loginIPMaxFailures", 50, "failed login attempts in a row from one client address allowed before a lockout")
+	flag.DurationVar(&c.LoginLockoutBaseDelay, "loginLockoutBaseDelay", time.Second, "first login lockout, doubles with every next failed attempt")
+	flag.DurationVar(&c.LoginLockoutMaxDelay, "loginLockoutMaxDelay", 15*time.Minute, "max login lockout")
+	flag.DurationVar(&c.LoginFailureWindow, "loginFailureWindow", time.Hour, "time after which failed login attempts are forgotten")
+	flag.StringVar(&c.TOTPIssuer, "totpIssuer", "Gophermart", "name of the service shown by authenticator apps")
+	flag.IntVar(&c.PasswordMinLength, "passwordMinLength", 8, "min length of a new password in characters")
+	flag.IntVar(&c.PasswordMaxLength, "passwordMaxLength", 128, "max length of a new password in characters, 0 means no limit")
+	flag.BoolVar(&c.PasswordRequireLetter, "passwordRequireLetter", true, "a new password must contain a letter")
+	flag.BoolVar(&c.PasswordRequireDigit, "passwordRequireDigit", true, "a new password must contain a digit")
+	flag.DurationVar(&c.HoldTTL, "holdTTL", 15*time.Minute, "how long a hold reserves points unless it's captured or released")
+	flag.DurationVar(&c.HoldExpiryInterval, "holdExpiryInterval", time.Minute, "delay between sweeps for stale holds")
+	flag.DurationVar(&c.SessionCleanupInterval, "sessionCleanupInterval", time.Hour, "delay between sweeps for expired refresh tokens and stale sessions")
+	// maxOrderAttempts is accepted only to keep existing command lines working
+	var maxOrderAttempts int
+	flag.IntVar(&maxOrderAttempts, "maxOrderAttempts", 0, "deprecated and ignored, failed orders are retried until orderRegistrationTimeout")
+	flag.TextVar(&c.WithdrawTOTPThreshold, "withdrawTOTPThreshold", money.FromPoints(1000), "withdrawal sum above which users with 2FA present a TOTP code")
 
 	// Parse flags
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "maxOrderAttempts" {
+			logger.Logger().Warn("flag -maxOrderAttempts is deprecated and ignored, use -orderRegistrationTimeout")
+		}
+	})
 
 	// Parse environment variables
 	if err := env.Parse(c); err != nil {
@@ -66,8 +123,32 @@ func (c *Config) Print() {
 		zap.String("Database URI", c.DatabaseURI),
 		zap.String("Accrual System Address", c.AccrualSystemAddress),
 		zap.String("PollOrdersDelay", c.PollOrdersDelay.String()),
-		zap.Int("MaxOrderAttempts", c.MaxOrderAttempts),
+		zap.String("OrderPollInterval", c.OrderPollInterval.String()),
+		zap.String("OrderRetryBaseDelay", c.OrderRetryBaseDelay.String()),
+		zap.String("OrderRetryMaxDelay", c.OrderRetryMaxDelay.String()),
+		zap.String("OrderRegistrationTimeout", c.OrderRegistrationTimeout.String()),
+		zap.Int("AccrualWorkers", c.AccrualWorkers),
+		zap.String("OrderLeaseDuration", c.OrderLeaseDuration.String()),
+		zap.String("InstanceID", c.InstanceID),
 		zap.String("LogLevel", c.LogLevel),
-		zap.String("JWT Secret Key", c.JWTSecretKey),
+		zap.Bool("JWT Secret Key is set", c.JWTSecretKey != ""),
+		zap.String("JWTSigningKeyFile", c.JWTSigningKeyFile),
+		zap.String("JWTVerificationKeyFiles", c.JWTVerificationKeyFiles),
+		zap.String("AccessTokenTTL", c.AccessTokenTTL.String()),
+		zap.String("RefreshTokenTTL", c.RefreshTokenTTL.String()),
+		zap.Int("LoginMaxFailures", c.LoginMaxFailures),
+		zap.Int("LoginIPMaxFailures", c.LoginIPMaxFailures),
+		zap.String("LoginLockoutBaseDelay", c.LoginLockoutBaseDelay.String()),
+		zap.String("LoginLockoutMaxDelay", c.LoginLockoutMaxDelay.String()),
+		zap.String("LoginFailureWindow", c.LoginFailureWindow.String()),
+		zap.String("TOTPIssuer", c.TOTPIssuer),
+		zap.Stringer("WithdrawTOTPThreshold", c.WithdrawTOTPThreshold),
+		zap.Int("PasswordMinLength", c.PasswordMinLength),
+		zap.Int("PasswordMaxLength", c.PasswordMaxLength),
+		zap.Bool("PasswordRequireLetter", c.PasswordRequireLetter),
+		zap.Bool("PasswordRequireDigit", c.PasswordRequireDigit),
+		zap.String("HoldTTL", c.HoldTTL.String()),
+		zap.String("HoldExpiryInterval", c.HoldExpiryInterval.String()),
+		zap.String("SessionCleanupInterval", c.SessionCleanupInterval.String()),
 	)
 }
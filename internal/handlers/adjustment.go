@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
+	"go.uber.org/zap"
+)
+
+type AdjustmentRequestDTO struct {
+	Amount money.Amount `json:"amount"` // положительная сумма начисляется пользователю, отрицательная списывается
+	Reason string       `json:"reason"` // причина корректировки
+	Ticket string       `json:"ticket"` // номер обращения в поддержку
+}
+
+type AdjustmentDTO struct {
+	Amount      money.Amount `json:"amount"`
+	Reason      string       `json:"reason"`
+	Ticket      string       `json:"ticket"`
+	ProcessedAt time.Time    `json:"processed_at"`
+}
+
+type AdminAdjustmentDTO struct {
+	TransactionID int64        `json:"transaction_id"`
+	Amount        money.Amount `json:"amount"`
+	Reason        string       `json:"reason"`
+	Ticket        string       `json:"ticket"`
+	AdminUserID   int64        `json:"admin_user_id"`
+	ProcessedAt   time.Time    `json:"processed_at"`
+}
+
+func newAdminAdjustmentDTO(adjustment repository.Adjustment) AdminAdjustmentDTO {
+	return AdminAdjustmentDTO{
+		TransactionID: adjustment.TransactionID,
+		Amount:        adjustment.Amount,
+		Reason:        adjustment.Reason,
+		Ticket:        adjustment.Ticket,
+		AdminUserID:   adjustment.AdminUserID,
+		ProcessedAt:   adjustment.Created,
+	}
+}
+
+// PostAdminAdjustmentHandler корректировка баланса пользователя
+// #### **Корректировка баланса пользователя**
+//
+// Хендлер: `POST /api/admin/users/{id}/adjustments`.
+//
+// Хендлер доступен пользователям с ролью `admin`. Начисляет пользователю баллы или списывает их,
+// например в качестве компенсации за потерянный заказ. Баллы переводятся с системного счёта
+// `AdjustmentUserID` или на него операцией `adjustment`, причина, номер обращения и идентификатор
+// администратора сохраняются вместе с операцией. Списание не может сделать баланс отрицательным.
+//
+// Формат запроса:
+//
+// POST /api/admin/users/42/adjustments HTTP/1.1
+// Content-Type: application/json
+//
+// {
+// "amount": -12.5,
+// "reason": "двойное начисление за заказ 9278923470",
+// "ticket": "SUP-1234"
+// }
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "transaction_id": 7,
+// "amount": -12.5,
+// "reason": "двойное начисление за заказ 9278923470",
+// "ticket": "SUP-1234",
+// "admin_user_id": 3,
+// "processed_at": "2020-12-10T15:15:45+03:00"
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — баланс скорректирован;
+// *   `400` — неверный формат запроса, нулевая сумма, не указаны причина или номер обращения, причина в теле ответа;
+// *   `401` — пользователь не авторизован;
+// *   `402` — на счету пользователя недостаточно средств для списания;
+// *   `403` — у пользователя нет роли `admin`;
+// *   `404` — пользователь не найден;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostAdminAdjustmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	adminID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var adjustmentRequestDTO AdjustmentRequestDTO
+	err = json.Unmarshal(bytes, &adjustmentRequestDTO)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// the ledger references the user, an unknown one is answered with 404 instead of a foreign key error
+	_, err = h.userService.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.Logger().Error("get user", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	adjustment, err := h.transactionService.Adjust(ctx, adminID, userID,
+		adjustmentRequestDTO.Amount, adjustmentRequestDTO.Reason, adjustmentRequestDTO.Ticket)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAdjustmentInvalid):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrAdjustmentInsufficientFunds):
+			w.WriteHeader(http.StatusPaymentRequired)
+		default:
+			logger.Logger().Error("transactionService.Adjust", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, newAdminAdjustmentDTO(*adjustment))
+}
+
+// GetAdminAdjustmentsHandler получение корректировок баланса пользователя
+// #### **Получение корректировок баланса пользователя**
+//
+// Хендлер: `GET /api/admin/users/{id}/adjustments`.
+//
+// Хендлер доступен пользователям с ролью `support` или `admin`. Возвращает корректировки баланса
+// пользователя в формате ответа `POST /api/admin/users/{id}/adjustments`, сначала самые старые.
+//
+// Возможные коды ответа:
+//
+// *   `200` — успешная обработка запроса;
+// *   `400` — неверный идентификатор пользователя;
+// *   `401` — пользователь не авторизован;
+// *   `403` — у пользователя нет нужной роли;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) GetAdminAdjustmentsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	adjustments, err := h.transactionService.GetAdjustments(r.Context(), userID)
+	if err != nil {
+		logger.Logger().Error("transactionService.GetAdjustments", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	adjustmentDTOs := make([]AdminAdjustmentDTO, 0, len(adjustments))
+	for _, adjustment := range adjustments {
+		adjustmentDTOs = append(adjustmentDTOs, newAdminAdjustmentDTO(adjustment))
+	}
+	writeJSON(w, adjustmentDTOs)
+}
+
+// GetAdjustmentsHandler получение информации о корректировках баланса
+// #### **Получение информации о корректировках баланса**
+//
+// Хендлер: `GET /api/user/adjustments`.
+//
+// Хендлер доступен только авторизованному пользователю. Возвращает начисления и списания, сделанные
+// поддержкой, сначала самые старые. Положительная сумма начислена пользователю, отрицательная списана.
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// [
+// {
+// "amount": 100,
+// "reason": "компенсация за потерянный заказ",
+// "ticket": "SUP-1234",
+// "processed_at": "2020-12-09T16:09:57+03:00"
+// }
+// ]
+//
+// Возможные коды ответа:
+//
+// *   `200` — успешная обработка запроса;
+// *   `204` — нет ни одной корректировки;
+// *   `401` — пользователь не авторизован;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) GetAdjustmentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	adjustments, err := h.transactionService.GetAdjustments(ctx, userID)
+	if err != nil {
+		logger.Logger().Error("transactionService.GetAdjustments", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(adjustments) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	adjustmentDTOs := make([]AdjustmentDTO, 0, len(adjustments))
+	for _, adjustment := range adjustments {
+		adjustmentDTOs = append(adjustmentDTOs, AdjustmentDTO{
+			Amount:      adjustment.Amount,
+			Reason:      adjustment.Reason,
+			Ticket:      adjustment.Ticket,
+			ProcessedAt: adjustment.Created,
+		})
+	}
+	writeJSON(w, adjustmentDTOs)
+}
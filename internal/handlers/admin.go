@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+type AdminUserDTO struct {
+	ID        int64                 `json:"id"`
+	Login     string                `json:"login"`
+	Roles     []string              `json:"roles"`
+	CreatedAt *time.Time            `json:"created_at,omitempty"`
+	DeletedAt *time.Time            `json:"deleted_at,omitempty"`
+	Balance   GetBalanceResponseDTO `json:"balance"`
+}
+
+type AdminOrderDTO struct {
+	Number     string       `json:"number"`
+	Status     string       `json:"status"`
+	Accrual    money.Amount `json:"accrual,omitempty"`
+	UploadedAt time.Time    `json:"uploaded_at"`
+	// Attempts, NextAttemptAt и LastError описывают повторные попытки расчёта начисления
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+	// RequeuedAt время последней отправки заказа на повторную обработку
+	RequeuedAt *time.Time `json:"requeued_at,omitempty"`
+}
+
+func newAdminOrderDTO(order repository.Order) AdminOrderDTO {
+	return AdminOrderDTO{
+		Number:        order.Number,
+		Status:        order.Status,
+		Accrual:       order.Accrual,
+		UploadedAt:    order.UploadedAt,
+		Attempts:      order.Attempts,
+		NextAttemptAt: order.NextAttemptAt,
+		LastError:     order.LastError,
+		RequeuedAt:    order.RequeuedAt,
+	}
+}
+
+type AdminTransactionDTO struct {
+	ID         int64        `json:"id"`
+	Operation  string       `json:"operation"`
+	FromUserID int64        `json:"from_user_id"`
+	ToUserID   int64        `json:"to_user_id"`
+	Amount     money.Amount `json:"amount"`
+	Order      string       `json:"order,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// GetAdminUserHandler поиск пользователя по логину
+// #### **Поиск пользователя**
+//
+// Хендлер: `GET /api/admin/users?login=<login>`.
+//
+// Хендлер доступен пользователям с ролью `support` или `admin`. Логин ищется без учёта регистра.
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "id": 42,
+// "login": "user",
+// "roles": [],
+// "created_at": "2020-12-09T16:09:57+03:00",
+// "balance": {"current": 500.5, "held": 0, "withdrawn": 42}
+// }
+//
+// Поле `deleted_at` есть только у удалённых пользователей.
+//
+// Возможные коды ответа:
+//
+// *   `200` — пользователь найден;
+// *   `400` — не передан логин;
+// *   `401` — пользователь не авторизован;
+// *   `403` — у пользователя нет нужной роли;
+// *   `404` — пользователь не найден;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) GetAdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	login := r.URL.Query().Get("login")
+	if login == "" {
+		http.Error(w, "login is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userService.UserRepository.GetUserByUsername(r.Context(), login)
+	h.writeAdminUser(w, r, user, err)
+}
+
+// GetAdminUserByIDHandler получение пользователя
+// #### **Получение пользователя**
+//
+// Хендлер: `GET /api/admin/users/{id}`.
+//
+// Хендлер доступен пользователям с ролью `support` или `admin`. Формат ответа и коды ответа
+// такие же, как у `GET /api/admin/users`, `400` возвращается для неверного идентификатора.
+func (h *ServiceHandlers) GetAdminUserByIDHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.UserRepository.GetUserByID(r.Context(), userID)
+	h.writeAdminUser(w, r, user, err)
+}
+
+func (h *ServiceHandlers) writeAdminUser(w http.ResponseWriter, r *http.Request, user *repository.User, err error) {
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.Logger().Error("get user", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	account, err := h.transactionService.GetBalance(r.Context(), user.ID)
+	if err != nil {
+		logger.Logger().Error("GetBalance", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	roles := user.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	writeJSON(w, AdminUserDTO{
+		ID:        user.ID,
+		Login:     user.Username,
+		Roles:     roles,
+		CreatedAt: user.Created,
+		DeletedAt: user.Deleted,
+		Balance:   newGetBalanceResponseDTO(*account),
+	})
+}
+
+// GetAdminUserOrdersHandler получение заказов пользователя
+// #### **Получение заказов пользователя**
+//
+// Хендлер: `GET /api/admin/users/{id}/orders`.
+//
+// Хендлер доступен пользователям с ролью `support` или `admin`. В отличие от `GET /api/user/orders`
+// в ответе есть состояние повторных попыток расчёта начисления, а пустой список возвращается с кодом `200`.
+// Поле `requeued_at` есть только у заказов, отправленных на повторную обработку.
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// [
+// {
+// "number": "9278923470",
+// "status": "INVALID",
+// "uploaded_at": "2020-12-10T15:15:45+03:00",
+// "attempts": 5,
+// "next_attempt_at": "2020-12-10T15:25:45+03:00",
+// "last_error": "accrual system is unavailable",
+// "requeued_at": "2020-12-11T10:00:00+03:00"
+// }
+// ]
+//
+// Возможные коды ответа:
+//
+// *   `200` — успешная обработка запроса;
+// *   `400` — неверный идентификатор пользователя;
+// *   `401` — пользователь не авторизован;
+// *   `403` — у пользователя нет нужной роли;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) GetAdminUserOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	orders, err := h.orderService.OrderRepository.GetOrdersByUserID(r.Context(), userID)
+	if err != nil {
+		logger.Logger().Error("get orders by user id", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	orderDTOs := make([]AdminOrderDTO, 0, len(orders))
+	for _, order := range orders {
+		orderDTOs = append(orderDTOs, newAdminOrderDTO(order))
+	}
+	writeJSON(w, orderDTOs)
+}
+
+// GetAdminUserTransactionsHandler получение истории операций пользователя
+// #### **Получение истории операций пользователя**
+//
+// Хендлер: `GET /api/admin/users/{id}/transactions`.
+//
+// Хендлер доступен пользователям с ролью `support` или `admin`. Возвращает все проводки, в которых
+// пользователь списывает или получает баллы, сначала самые старые. Начисления приходят от системного
+// пользователя `AccrualUserID`, списания уходят системному пользователю `WithdrawUserID`.
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// [
+// {
+// "id": 7,
+// "operation": "accrual",
+// "from_user_id": 2,
+// "to_user_id": 42,
+// "amount": 500,
+// "order": "9278923470",
+// "created_at": "2020-12-10T15:15:45+03:00"
+// }
+// ]
+//
+// Возможные коды ответа:
+//
+// *   `200` — успешная обработка запроса;
+// *   `400` — неверный идентификатор пользователя;
+// *   `401` — пользователь не авторизован;
+// *   `403` — у пользователя нет нужной роли;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) GetAdminUserTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	transactions, err := h.transactionService.GetTransactions(r.Context(), userID)
+	if err != nil {
+		logger.Logger().Error("transactionService.GetTransactions", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	transactionDTOs := make([]AdminTransactionDTO, 0, len(transactions))
+	for _, transaction := range transactions {
+		transactionDTOs = append(transactionDTOs, AdminTransactionDTO{
+			ID:         transaction.TransactionID,
+			Operation:  transaction.OperationType,
+			FromUserID: transaction.FromUserID,
+			ToUserID:   transaction.ToUserID,
+			Amount:     transaction.Amount,
+			Order:      transaction.OrderNumber,
+			CreatedAt:  transaction.Created,
+		})
+	}
+	writeJSON(w, transactionDTOs)
+}
+
+// adminUserID parses the id of the user the admin request is about, a bad id is answered with 400
+func adminUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || userID <= 0 {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		logger.Logger().Error("marshal response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bytes)
+	if err != nil {
+		logger.Logger().Error("write response", zap.Error(err))
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+type AdminRequeueRequestDTO struct {
+	Status string     `json:"status"` // статус заказов, отправляемых на повторную обработку
+	From   *time.Time `json:"from"`   // начало интервала времени загрузки заказов, включительно
+	To     *time.Time `json:"to"`     // конец интервала времени загрузки заказов, не включительно
+}
+
+type AdminRequeueResponseDTO struct {
+	Count  int      `json:"count"`
+	Orders []string `json:"orders"`
+}
+
+// PostAdminOrderRequeueHandler повторная обработка заказа
+// #### **Повторная обработка заказа**
+//
+// Хендлер: `POST /api/admin/orders/{number}/requeue`.
+//
+// Хендлер доступен пользователям с ролью `admin`. Отправляет заказ в статусе `INVALID`, например
+// признанный недействительным во время недоступности системы расчёта, на повторную обработку
+// в статусе `NEW`. Заказ в статусе `NEW` или `PROCESSING` остаётся в своём статусе. Счётчик
+// неудачных попыток сбрасывается, заказ обрабатывается сразу, а срок ожидания регистрации заказа
+// в системе расчёта отсчитывается заново. Действие записывается в журнал действий администраторов.
+//
+// Формат ответа — заказ в формате `GET /api/admin/users/{id}/orders`:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "number": "9278923470",
+// "status": "NEW",
+// "uploaded_at": "2020-12-10T15:15:45+03:00",
+// "attempts": 0,
+// "next_attempt_at": "2020-12-11T10:00:00+03:00",
+// "requeued_at": "2020-12-11T10:00:00+03:00"
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — заказ отправлен на повторную обработку;
+// *   `401` — пользователь не авторизован;
+// *   `403` — у пользователя нет роли `admin`;
+// *   `404` — заказ не найден;
+// *   `409` — заказ в статусе `PROCESSED` или его статус изменился во время запроса;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostAdminOrderRequeueHandler(w http.ResponseWriter, r *http.Request) {
+	h.requeueAdminOrder(w, r, h.orderService.RequeueOrder)
+}
+
+// PostAdminOrderRecheckHandler повторный запрос начисления за заказ
+// #### **Повторный запрос начисления за заказ**
+//
+// Хендлер: `POST /api/admin/orders/{number}/recheck`.
+//
+// Хендлер доступен пользователям с ролью `admin`. Отправляет заказ в статусе `PROCESSED` на повторный
+// запрос в систему расчёта в статусе `PROCESSING`, например если при расчёте не было начисления.
+// Уже начисленные за заказ баллы повторно не начисляются: заказ возвращается в статус `PROCESSED`
+// с прежним начислением, а отличающийся ответ системы расчёта сохраняется в `last_error`
+// для корректировки баланса вручную. Действие записывается в журнал действий администраторов.
+//
+// Формат ответа такой же, как у `POST /api/admin/orders/{number}/requeue`.
+//
+// Возможные коды ответа:
+//
+// *   `200` — заказ отправлен на повторный запрос;
+// *   `401` — пользователь не авторизован;
+// *   `403` — у пользователя нет роли `admin`;
+// *   `404` — заказ не найден;
+// *   `409` — заказ не в статусе `PROCESSED` или его статус изменился во время запроса;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostAdminOrderRecheckHandler(w http.ResponseWriter, r *http.Request) {
+	h.requeueAdminOrder(w, r, h.orderService.RecheckOrder)
+}
+
+func (h *ServiceHandlers) requeueAdminOrder(
+	w http.ResponseWriter,
+	r *http.Request,
+	requeue func(ctx context.Context, adminUserID int64, number string) (*repository.Order, error),
+) {
+	ctx := r.Context()
+	adminID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	order, err := requeue(ctx, adminID, chi.URLParam(r, "number"))
+	if err != nil {
+		switch {
+		case errors.Is(err, postgres.ErrOrderNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, services.ErrOrderRequeueNotAllowed):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			logger.Logger().Error("requeue order", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, newAdminOrderDTO(*order))
+}
+
+// PostAdminOrdersRequeueHandler массовая повторная обработка заказов
+// #### **Массовая повторная обработка заказов**
+//
+// Хендлер: `POST /api/admin/orders/requeue`.
+//
+// Хендлер доступен пользователям с ролью `admin`. Отправляет на повторную обработку все заказы
+// в статусе `status`, загруженные в интервале `[from, to)`, например все заказы, признанные
+// недействительными во время недоступности системы расчёта. Границы интервала необязательны.
+// Заказы в статусе `INVALID` обрабатываются в статусе `NEW`, в статусе `PROCESSED` — запрашиваются
+// повторно как в `POST /api/admin/orders/{number}/recheck`, остальные остаются в своём статусе.
+// Действие со списком заказов записывается в журнал действий администраторов.
+//
+// Формат запроса:
+//
+// POST /api/admin/orders/requeue HTTP/1.1
+// Content-Type: application/json
+//
+// {
+// "status": "INVALID",
+// "from": "2020-12-10T00:00:00+03:00",
+// "to": "2020-12-11T00:00:00+03:00"
+// }
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "count": 2,
+// "orders": ["9278923470", "12345678903"]
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — заказы отправлены на повторную обработку, в том числе если подходящих заказов нет;
+// *   `400` — неверный формат запроса, не указан или неизвестен статус, `from` не раньше `to`, причина в теле ответа;
+// *   `401` — пользователь не авторизован;
+// *   `403` — у пользователя нет роли `admin`;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostAdminOrdersRequeueHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adminID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var requeueRequestDTO AdminRequeueRequestDTO
+	err = json.Unmarshal(bytes, &requeueRequestDTO)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var from, to time.Time
+	if requeueRequestDTO.From != nil {
+		from = *requeueRequestDTO.From
+	}
+	if requeueRequestDTO.To != nil {
+		to = *requeueRequestDTO.To
+	}
+	numbers, err := h.orderService.RequeueOrders(ctx, adminID, requeueRequestDTO.Status, from, to)
+	if err != nil {
+		if errors.Is(err, services.ErrOrderRequeueInvalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Logger().Error("orderService.RequeueOrders", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, AdminRequeueResponseDTO{Count: len(numbers), Orders: numbers})
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandlers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	const customerID = int64(42)
+	mockUserRepository := mock.NewMockUserRepository(ctrl)
+	userService := services.NewUserService(mockUserRepository)
+	mockOrderRepository := mock.NewMockOrderRepository(ctrl)
+	mockTransactionRepository := mock.NewMockTransactionRepository(ctrl)
+	transactionService := services.NewTransactionService(mockTransactionRepository)
+	orderService := services.NewOrderService(transactionService, mockOrderRepository, nil)
+
+	mockTokenRepository := mock.NewMockTokenRepository(ctrl)
+	mockTokenRepository.EXPECT().IsAccessTokenRevoked(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+	authService := newTestAuthService(t, userService, mockTokenRepository, nil)
+	ts := newTestServer(t, authService, userService, orderService, transactionService)
+
+	// a regular user has no access to the admin API
+	userToken, err := authService.GenerateToken(testUser, "session", nil)
+	require.NoError(t, err)
+	resp, _ := authRequest(t, ts, http.MethodGet, "/api/admin/users?login=customer", userToken, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = authRequest(t, ts, http.MethodGet, "/api/admin/users?login=customer", "", nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	supportToken, err := authService.GenerateToken(testUser, "session", []string{repository.RoleSupport})
+	require.NoError(t, err)
+
+	// support staff look up a user by the login
+	created := time.Now().UTC().Truncate(time.Second)
+	mockUserRepository.EXPECT().
+		GetUserByUsername(gomock.Any(), "customer").
+		Return(&repository.User{ID: customerID, Username: "customer", Created: &created}, nil)
+	mockTransactionRepository.EXPECT().
+		GetAccount(gomock.Any(), customerID).
+		Return(&repository.Account{UserID: customerID, Current: money.FromPoints(500), Withdrawn: money.FromPoints(42)}, nil)
+	resp, body := authRequest(t, ts, http.MethodGet, "/api/admin/users?login=customer", supportToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	user := AdminUserDTO{}
+	require.NoError(t, json.Unmarshal(body, &user))
+	require.Equal(t, AdminUserDTO{
+		ID:        customerID,
+		Login:     "customer",
+		Roles:     []string{},
+		CreatedAt: &created,
+		Balance:   GetBalanceResponseDTO{Current: money.FromPoints(500), Withdrawn: money.FromPoints(42)},
+	}, user)
+
+	mockUserRepository.EXPECT().GetUserByID(gomock.Any(), int64(7)).Return(nil, postgres.ErrUserNotFound)
+	resp, _ = authRequest(t, ts, http.MethodGet, "/api/admin/users/7", supportToken, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = authRequest(t, ts, http.MethodGet, "/api/admin/users/abc", supportToken, nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// orders show the retry state
+	mockOrderRepository.EXPECT().
+		GetOrdersByUserID(gomock.Any(), customerID).
+		Return([]repository.Order{
+			{Number: "9278923470", UserID: customerID, Status: "INVALID", UploadedAt: created, Attempts: 5, NextAttemptAt: created, LastError: "accrual is down"},
+		}, nil)
+	resp, body = authRequest(t, ts, http.MethodGet, "/api/admin/users/42/orders", supportToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	orders := make([]AdminOrderDTO, 0)
+	require.NoError(t, json.Unmarshal(body, &orders))
+	require.Equal(t, []AdminOrderDTO{
+		{Number: "9278923470", Status: "INVALID", UploadedAt: created, Attempts: 5, NextAttemptAt: created, LastError: "accrual is down"},
+	}, orders)
+
+	// the ledger lists credits and debits
+	mockTransactionRepository.EXPECT().
+		GetTransactionsByUserID(gomock.Any(), customerID).
+		Return([]repository.Transaction{
+			{TransactionID: 1, FromUserID: services.AccrualUserID, ToUserID: customerID, Amount: money.FromPoints(500),
+				OrderNumber: "9278923470", OperationType: repository.AccrualOperationType, Created: created},
+		}, nil)
+	resp, body = authRequest(t, ts, http.MethodGet, "/api/admin/users/42/transactions", supportToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	transactions := make([]AdminTransactionDTO, 0)
+	require.NoError(t, json.Unmarshal(body, &transactions))
+	require.Equal(t, []AdminTransactionDTO{
+		{ID: 1, Operation: repository.AccrualOperationType, FromUserID: services.AccrualUserID, ToUserID: customerID,
+			Amount: money.FromPoints(500), Order: "9278923470", CreatedAt: created},
+	}, transactions)
+}
+
+func TestAdminAdjustments(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	const (
+		adminID    = int64(3)
+		customerID = int64(42)
+	)
+	mockUserRepository := mock.NewMockUserRepository(ctrl)
+	mockUserRepository.EXPECT().GetUserByID(gomock.Any(), customerID).Return(&repository.User{ID: customerID}, nil).AnyTimes()
+	userService := services.NewUserService(mockUserRepository)
+	mockTransactionRepository := mock.NewMockTransactionRepository(ctrl)
+	transactionService := services.NewTransactionService(mockTransactionRepository)
+
+	mockTokenRepository := mock.NewMockTokenRepository(ctrl)
+	mockTokenRepository.EXPECT().IsAccessTokenRevoked(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+	authService := newTestAuthService(t, userService, mockTokenRepository, nil)
+	ts := newTestServer(t, authService, userService, nil, transactionService)
+
+	body := `{"amount":-12.5,"reason":"double credit","ticket":"SUP-1234"}`
+
+	// support staff see adjustments but can't make them
+	supportToken, err := authService.GenerateToken(adminID, "session", []string{repository.RoleSupport})
+	require.NoError(t, err)
+	resp, _ := authRequest(t, ts, http.MethodPost, "/api/admin/users/42/adjustments", supportToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	adminToken, err := authService.GenerateToken(adminID, "session", []string{repository.RoleAdmin})
+	require.NoError(t, err)
+
+	// the reason and the ticket are required
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/users/42/adjustments", adminToken,
+		bytes.NewBufferString(`{"amount":10,"reason":" ","ticket":"SUP-1234"}`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	mockTransactionRepository.EXPECT().
+		Adjust(gomock.Any(), repository.Adjustment{
+			UserID: customerID, Amount: -money.FromMinor(1250), AdminUserID: adminID, Reason: "double credit", Ticket: "SUP-1234",
+		}).
+		Return(nil, repository.ErrInsufficientFunds)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/users/42/adjustments", adminToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+
+	processedAt := time.Now().UTC().Truncate(time.Second)
+	adjustment := repository.Adjustment{
+		TransactionID: 7, UserID: customerID, Amount: -money.FromMinor(1250), AdminUserID: adminID,
+		Reason: "double credit", Ticket: "SUP-1234", Created: processedAt,
+	}
+	mockTransactionRepository.EXPECT().Adjust(gomock.Any(), gomock.Any()).Return(&adjustment, nil)
+	resp, respBody := authRequest(t, ts, http.MethodPost, "/api/admin/users/42/adjustments", adminToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	created := AdminAdjustmentDTO{}
+	require.NoError(t, json.Unmarshal(respBody, &created))
+	require.Equal(t, AdminAdjustmentDTO{
+		TransactionID: 7, Amount: -money.FromMinor(1250), Reason: "double credit", Ticket: "SUP-1234",
+		AdminUserID: adminID, ProcessedAt: processedAt,
+	}, created)
+
+	// the user sees the adjustment in the history without the operator
+	customerToken, err := authService.GenerateToken(customerID, "session", nil)
+	require.NoError(t, err)
+	mockTransactionRepository.EXPECT().GetAdjustmentsByUserID(gomock.Any(), customerID).Return([]repository.Adjustment{adjustment}, nil)
+	resp, respBody = authRequest(t, ts, http.MethodGet, "/api/user/adjustments", customerToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	adjustments := make([]AdjustmentDTO, 0)
+	require.NoError(t, json.Unmarshal(respBody, &adjustments))
+	require.Equal(t, []AdjustmentDTO{
+		{Amount: -money.FromMinor(1250), Reason: "double credit", Ticket: "SUP-1234", ProcessedAt: processedAt},
+	}, adjustments)
+}
+
+func TestAdminOrderRequeue(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	const adminID = int64(3)
+	userService := services.NewUserService(mock.NewMockUserRepository(ctrl))
+	mockOrderRepository := mock.NewMockOrderRepository(ctrl)
+	transactionService := services.NewTransactionService(mock.NewMockTransactionRepository(ctrl))
+	orderService := services.NewOrderService(transactionService, mockOrderRepository, nil)
+
+	mockTokenRepository := mock.NewMockTokenRepository(ctrl)
+	mockTokenRepository.EXPECT().IsAccessTokenRevoked(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+	authService := newTestAuthService(t, userService, mockTokenRepository, nil)
+	ts := newTestServer(t, authService, userService, orderService, transactionService)
+
+	// support staff can't requeue orders
+	supportToken, err := authService.GenerateToken(adminID, "session", []string{repository.RoleSupport})
+	require.NoError(t, err)
+	resp, _ := authRequest(t, ts, http.MethodPost, "/api/admin/orders/9278923470/requeue", supportToken, nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	adminToken, err := authService.GenerateToken(adminID, "session", []string{repository.RoleAdmin})
+	require.NoError(t, err)
+
+	// an INVALID order starts over as NEW
+	uploadedAt := time.Now().UTC().Truncate(time.Second)
+	invalidOrder := repository.Order{Number: "9278923470", UserID: 42, Status: services.InvalidOrderStatus, UploadedAt: uploadedAt, Attempts: 5}
+	requeuedOrder := repository.Order{Number: "9278923470", UserID: 42, Status: services.NewOrderStatus, UploadedAt: uploadedAt,
+		NextAttemptAt: uploadedAt, RequeuedAt: &uploadedAt}
+	gomock.InOrder(
+		mockOrderRepository.EXPECT().GetOrderByNumber(gomock.Any(), "9278923470").Return(&invalidOrder, nil),
+		mockOrderRepository.EXPECT().
+			RequeueOrders(gomock.Any(),
+				repository.OrderFilter{Number: "9278923470", Status: services.InvalidOrderStatus},
+				services.NewOrderStatus,
+				repository.AdminAction{AdminUserID: adminID, Action: repository.AdminActionOrderRequeue, Target: "9278923470"}).
+			Return([]string{"9278923470"}, nil),
+		mockOrderRepository.EXPECT().GetOrderByNumber(gomock.Any(), "9278923470").Return(&requeuedOrder, nil),
+	)
+	resp, body := authRequest(t, ts, http.MethodPost, "/api/admin/orders/9278923470/requeue", adminToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	order := AdminOrderDTO{}
+	require.NoError(t, json.Unmarshal(body, &order))
+	require.Equal(t, AdminOrderDTO{Number: "9278923470", Status: services.NewOrderStatus, UploadedAt: uploadedAt,
+		NextAttemptAt: uploadedAt, RequeuedAt: &uploadedAt}, order)
+
+	// a PROCESSED order is rechecked, not requeued
+	processedOrder := repository.Order{Number: "12345678903", UserID: 42, Status: services.ProcessedOrderStatus}
+	mockOrderRepository.EXPECT().GetOrderByNumber(gomock.Any(), "12345678903").Return(&processedOrder, nil)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/orders/12345678903/requeue", adminToken, nil)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// the order processed meanwhile is not rechecked
+	mockOrderRepository.EXPECT().GetOrderByNumber(gomock.Any(), "12345678903").Return(&processedOrder, nil)
+	mockOrderRepository.EXPECT().
+		RequeueOrders(gomock.Any(), repository.OrderFilter{Number: "12345678903", Status: services.ProcessedOrderStatus},
+			services.ProcessingOrderStatus, gomock.Any()).
+		Return([]string{}, nil)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/orders/12345678903/recheck", adminToken, nil)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	mockOrderRepository.EXPECT().GetOrderByNumber(gomock.Any(), "346436439").Return(nil, postgres.ErrOrderNotFound)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/orders/346436439/recheck", adminToken, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// bulk requeue by status and upload time
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/orders/requeue", adminToken, bytes.NewBufferString(`{"status":"LOST"}`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/orders/requeue", adminToken,
+		bytes.NewBufferString(`{"status":"INVALID","from":"2020-12-11T00:00:00Z","to":"2020-12-10T00:00:00Z"}`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	from := time.Date(2020, 12, 10, 0, 0, 0, 0, time.UTC)
+	mockOrderRepository.EXPECT().
+		RequeueOrders(gomock.Any(),
+			repository.OrderFilter{Status: services.InvalidOrderStatus, UploadedFrom: from, UploadedTo: from.Add(24 * time.Hour)},
+			services.NewOrderStatus,
+			repository.AdminAction{AdminUserID: adminID, Action: repository.AdminActionOrdersRequeue,
+				Target: "status=INVALID from=2020-12-10T00:00:00Z to=2020-12-11T00:00:00Z"}).
+		Return([]string{"9278923470", "346436439"}, nil)
+	resp, body = authRequest(t, ts, http.MethodPost, "/api/admin/orders/requeue", adminToken,
+		bytes.NewBufferString(`{"status":"INVALID","from":"2020-12-10T00:00:00Z","to":"2020-12-11T00:00:00Z"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	requeued := AdminRequeueResponseDTO{}
+	require.NoError(t, json.Unmarshal(body, &requeued))
+	require.Equal(t, AdminRequeueResponseDTO{Count: 2, Orders: []string{"9278923470", "346436439"}}, requeued)
+}
@@ -1,26 +1,39 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"time"
 
 	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/services"
 	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
 	"go.uber.org/zap"
 )
 
+const (
+	// WithdrawalStatusProcessed баллы списаны;
+	WithdrawalStatusProcessed = "PROCESSED"
+	// WithdrawalStatusReversed списанные баллы возвращены пользователю, например после отмены покупки магазином.
+	WithdrawalStatusReversed = "REVERSED"
+)
+
 type userWithdrawal struct {
-	OrderWithdrawNumber string    `json:"order"` // номер заказа к которому привязан вывод средств
-	Sum                 float32   `json:"sum"`   // сумма баллов к списанию в счёт оплаты
-	ProcessedAt         time.Time `json:"processed_at"`
+	OrderWithdrawNumber string       `json:"order"` // номер заказа к которому привязан вывод средств
+	Sum                 money.Amount `json:"sum"`   // сумма баллов к списанию в счёт оплаты
+	ProcessedAt         time.Time    `json:"processed_at"`
+	Status              string       `json:"status"`                // PROCESSED или REVERSED
+	ReversedAt          *time.Time   `json:"reversed_at,omitempty"` // время возврата баллов
 }
 
 type WithdrawRequestDTO struct {
-	OrderWithdrawNumber string  `json:"order"` // номер заказа к которому привязан вывод средств
-	Sum                 float32 `json:"sum"`   // сумма баллов к списанию в счёт оплаты
+	OrderWithdrawNumber string       `json:"order"`               // номер заказа к которому привязан вывод средств
+	Sum                 money.Amount `json:"sum"`                 // сумма баллов к списанию в счёт оплаты
+	TOTPCode            string       `json:"totp_code,omitempty"` // код из приложения-аутентификатора для крупных списаний
 }
 
 // GetWithdrawalsHandler получение информации о выводе средств
@@ -49,10 +62,15 @@ type WithdrawRequestDTO struct {
 // {
 // "order": "2377225624",
 // "sum": 500,
-// "processed_at": "2020-12-09T16:09:57+03:00"
+// "processed_at": "2020-12-09T16:09:57+03:00",
+// "status": "REVERSED",
+// "reversed_at": "2020-12-10T11:00:00+03:00"
 // }
 // ]
 //
+// Поле `status` — `PROCESSED` для списания и `REVERSED`, если списанные баллы возвращены пользователю,
+// например после отмены покупки магазином, время возврата в поле `reversed_at`.
+//
 // *   `204` — нет ни одного списания.
 // *   `401` — пользователь не авторизован.
 // *   `500` — внутренняя ошибка сервера.
@@ -66,22 +84,28 @@ func (h *ServiceHandlers) GetWithdrawalsHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	transactions, err := h.transactionService.GetWithdrawTransaction(ctx, userID)
+	withdrawals, err := h.transactionService.GetWithdrawals(ctx, userID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if len(transactions) == 0 {
+	if len(withdrawals) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 	userWithdrawals := make([]*userWithdrawal, 0)
-	for _, transaction := range transactions {
-		userWithdrawals = append(userWithdrawals, &userWithdrawal{
-			OrderWithdrawNumber: transaction.OrderNumber,
-			Sum:                 transaction.Amount,
-			ProcessedAt:         transaction.Created,
-		})
+	for _, withdrawal := range withdrawals {
+		userWithdrawal := &userWithdrawal{
+			OrderWithdrawNumber: withdrawal.OrderNumber,
+			Sum:                 withdrawal.Amount,
+			ProcessedAt:         withdrawal.Created,
+			Status:              WithdrawalStatusProcessed,
+		}
+		if withdrawal.Reversal != nil {
+			userWithdrawal.Status = WithdrawalStatusReversed
+			userWithdrawal.ReversedAt = &withdrawal.Reversal.Created
+		}
+		userWithdrawals = append(userWithdrawals, userWithdrawal)
 	}
 	bytes, err := json.Marshal(userWithdrawals)
 	if err != nil {
@@ -118,11 +142,17 @@ func (h *ServiceHandlers) GetWithdrawalsHandler(w http.ResponseWriter, r *http.R
 //
 // Здесь `order` — номер заказа, а `sum` — сумма баллов к списанию в счёт оплаты.
 //
+// Если у пользователя включена двухфакторная аутентификация, для списания больше порога
+// (`WITHDRAW_TOTP_THRESHOLD`) нужно передать свежий код из приложения-аутентификатора в поле `totp_code`,
+// коды восстановления не принимаются.
+//
 // Возможные коды ответа:
 //
 // *   `200` — успешная обработка запроса;
 // *   `401` — пользователь не авторизован;
 // *   `402` — на счету недостаточно средств;
+// *   `403` — нужен код из приложения-аутентификатора или код неверен;
+// *   `429` — слишком много неверных кодов, заголовок `Retry-After` содержит число секунд до следующей попытки;
 // *   `422` — неверный номер заказа;
 // *   `500` — внутренняя ошибка сервера.
 func (h *ServiceHandlers) PostWithdrawHandler(w http.ResponseWriter, r *http.Request) {
@@ -148,32 +178,80 @@ func (h *ServiceHandlers) PostWithdrawHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if !h.verifyWithdrawal(w, r, userID, withdrawRequestDTO) {
+		return
+	}
+
 	err = h.transactionService.Withdraw(
-		context.Background(),
+		ctx,
 		userID,
 		withdrawRequestDTO.Sum,
 		withdrawRequestDTO.OrderWithdrawNumber,
 	)
 	if err != nil {
 		logger.Logger().Debug("transactionService.Withdraw", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
+		writeWithdrawError(w, err)
 		return
 	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifyWithdrawal asks a user with 2FA for a TOTP code before a large withdrawal or hold,
+// a failed check is answered and false is returned
+func (h *ServiceHandlers) verifyWithdrawal(w http.ResponseWriter, r *http.Request, userID int64, withdrawRequestDTO WithdrawRequestDTO) bool {
+	err := h.authService.VerifyWithdrawal(r.Context(), userID, withdrawRequestDTO.Sum, withdrawRequestDTO.TOTPCode)
+	if err != nil {
+		logger.Logger().Debug("authService.VerifyWithdrawal", zap.Error(err))
+		var lockedErr *services.LoginLockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			writeTooManyRequests(w, lockedErr)
+		case errors.Is(err, services.ErrTOTPCodeRequired), errors.Is(err, services.ErrTOTPCodeInvalid):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+func writeWithdrawError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrWithdrawInsufficientFunds):
+		w.WriteHeader(http.StatusPaymentRequired)
+	case errors.Is(err, services.ErrWithdrawInvalidOrderNumber):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	case errors.Is(err, services.ErrWithdrawInvalidAmount):
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
 type BalanceDTO struct {
-	Current   float32 `json:"current"`
-	Withdrawn int     `json:"withdrawn"`
+	Current   money.Amount `json:"current"`
+	Withdrawn money.Amount `json:"withdrawn"`
 }
 
 type GetBalanceResponseDTO struct {
-	Current   float32 `json:"current"`
-	Withdrawn float32 `json:"withdrawn"`
+	Current   money.Amount `json:"current"`   // баллы, доступные для списания и резервирования
+	Held      money.Amount `json:"held"`      // баллы, зарезервированные до завершения оплаты
+	Withdrawn money.Amount `json:"withdrawn"` // сумма списанных баллов
+}
+
+func newGetBalanceResponseDTO(account repository.Account) GetBalanceResponseDTO {
+	return GetBalanceResponseDTO{
+		Current:   account.Available(),
+		Held:      account.Held,
+		Withdrawn: account.Withdrawn,
+	}
 }
 
 type GetWithdrawResponseDTO struct {
-	Order string `json:"order"`
-	Sum   int    `json:"sum"`
+	Order string       `json:"order"`
+	Sum   money.Amount `json:"sum"`
 }
 
 // GetBalanceHandler получение текущего баланса пользователя
@@ -200,9 +278,13 @@ type GetWithdrawResponseDTO struct {
 //
 // {
 // "current": 500.5,
+// "held": 100,
 // "withdrawn": 42
 // }
 //
+// Поле `current` — баллы, доступные для списания, `held` — баллы, зарезервированные
+// `POST /api/user/balance/holds` до завершения оплаты, они не входят в `current`.
+//
 // *   `401` — пользователь не авторизован.
 // *   `500` — внутренняя ошибка сервера.
 func (h *ServiceHandlers) GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
@@ -216,24 +298,14 @@ func (h *ServiceHandlers) GetBalanceHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	currentBalance, err := h.transactionService.GetCurrentBalance(ctx, userID)
+	account, err := h.transactionService.GetBalance(ctx, userID)
 	if err != nil {
-		logger.Logger().Warn("GetCurrentBalance", zap.Error(err))
+		logger.Logger().Warn("GetBalance", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	withdrawBalance, err := h.transactionService.GetWithdrawBalance(ctx, userID)
-	if err != nil {
-		logger.Logger().Warn("GetWithdrawAmount", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	responseDTO := GetBalanceResponseDTO{
-		Current:   currentBalance,
-		Withdrawn: withdrawBalance,
-	}
+	responseDTO := newGetBalanceResponseDTO(*account)
 
 	bytes, err := json.Marshal(responseDTO)
 	if err != nil {
@@ -1,7 +1,181 @@
 package handlers
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
 
 func TestGetBalanceHandler(t *testing.T) {
+	type want struct {
+		statusCode int
+		body       string
+	}
+	tests := []struct {
+		name       string
+		want       want
+		account    *repository.Account
+		accountErr error
+	}{
+		{
+			name: "user with balance",
+			want: want{
+				statusCode: http.StatusOK,
+				body:       `{"current":500.5,"held":0,"withdrawn":42}`,
+			},
+			account: &repository.Account{
+				UserID:    testUser,
+				Current:   money.FromMinor(50050),
+				Withdrawn: money.FromPoints(42),
+			},
+		},
+		{
+			name: "user without ledger history",
+			want: want{
+				statusCode: http.StatusOK,
+				body:       `{"current":0,"held":0,"withdrawn":0}`,
+			},
+			account: &repository.Account{UserID: testUser},
+		},
+		{
+			name: "user with held points",
+			want: want{
+				statusCode: http.StatusOK,
+				body:       `{"current":400.5,"held":100,"withdrawn":42}`,
+			},
+			account: &repository.Account{
+				UserID:    testUser,
+				Current:   money.FromMinor(50050),
+				Held:      money.FromPoints(100),
+				Withdrawn: money.FromPoints(42),
+			},
+		},
+		{
+			name: "repository error",
+			want: want{
+				statusCode: http.StatusInternalServerError,
+			},
+			accountErr: errors.New("connection refused"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockTransactionCtrl := gomock.NewController(t)
+			defer mockTransactionCtrl.Finish()
+			mockTransactionRepository := mock.NewMockTransactionRepository(mockTransactionCtrl)
+			mockTransactionRepository.EXPECT().GetAccount(gomock.Any(), testUser).Return(test.account, test.accountErr).MinTimes(1)
+			transactionService := services.NewTransactionService(mockTransactionRepository)
+
+			serviceHandlers := NewServiceHandlers(services.NewAuthService(nil, nil, nil, nil), nil, nil, transactionService, nil)
+
+			mw := func(h http.Handler) http.Handler {
+				fn := func(w http.ResponseWriter, r *http.Request) {
+					ctx := context.WithValue(r.Context(), middleware.UserIDContextKey, testUser)
+					h.ServeHTTP(w, r.WithContext(ctx))
+				}
+
+				return http.HandlerFunc(fn)
+			}
+
+			router := NewRouter(serviceHandlers, mw)
+
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			statusCode, _, got := testRequest(t, ts, http.MethodGet, "/api/user/balance", nil)
+			assert.Equal(t, test.want.statusCode, statusCode)
+			assert.Equal(t, test.want.body, got)
+		})
+	}
+}
+
+func TestPostWithdrawHandler(t *testing.T) {
+	type want struct {
+		statusCode int
+	}
+	tests := []struct {
+		name          string
+		want          want
+		body          string
+		withdrawCalls int
+		withdrawErr   error
+	}{
+		{
+			name:          "successful withdraw",
+			want:          want{statusCode: http.StatusOK},
+			body:          `{"order":"2377225624","sum":751}`,
+			withdrawCalls: 1,
+		},
+		{
+			name:          "insufficient funds",
+			want:          want{statusCode: http.StatusPaymentRequired},
+			body:          `{"order":"2377225624","sum":751}`,
+			withdrawCalls: 1,
+			withdrawErr:   repository.ErrInsufficientFunds,
+		},
+		{
+			name:          "repository error",
+			want:          want{statusCode: http.StatusInternalServerError},
+			body:          `{"order":"2377225624","sum":751}`,
+			withdrawCalls: 1,
+			withdrawErr:   errors.New("connection refused"),
+		},
+		{
+			name: "invalid order number",
+			want: want{statusCode: http.StatusUnprocessableEntity},
+			body: `{"order":"2377225625","sum":751}`,
+		},
+		{
+			name: "negative sum",
+			want: want{statusCode: http.StatusBadRequest},
+			body: `{"order":"2377225624","sum":-1}`,
+		},
+		{
+			name: "broken json",
+			want: want{statusCode: http.StatusBadRequest},
+			body: `{"order":`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockTransactionCtrl := gomock.NewController(t)
+			defer mockTransactionCtrl.Finish()
+			mockTransactionRepository := mock.NewMockTransactionRepository(mockTransactionCtrl)
+			mockTransactionRepository.EXPECT().
+				Withdraw(gomock.Any(), testUser, "2377225624", money.FromPoints(751)).
+				Return(&repository.Transaction{}, test.withdrawErr).
+				Times(test.withdrawCalls)
+			transactionService := services.NewTransactionService(mockTransactionRepository)
+
+			serviceHandlers := NewServiceHandlers(services.NewAuthService(nil, nil, nil, nil), nil, nil, transactionService, nil)
+
+			mw := func(h http.Handler) http.Handler {
+				fn := func(w http.ResponseWriter, r *http.Request) {
+					ctx := context.WithValue(r.Context(), middleware.UserIDContextKey, testUser)
+					h.ServeHTTP(w, r.WithContext(ctx))
+				}
+
+				return http.HandlerFunc(fn)
+			}
+
+			router := NewRouter(serviceHandlers, mw)
+
+			ts := httptest.NewServer(router)
+			defer ts.Close()
 
+			statusCode, _, _ := testRequest(t, ts, http.MethodPost, "/api/user/balance/withdraw", bytes.NewBufferString(test.body))
+			assert.Equal(t, test.want.statusCode, statusCode)
+		})
+	}
 }
@@ -6,6 +6,10 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/keyring"
 	"github.com/stretchr/testify/require"
 )
 
@@ -29,3 +33,31 @@ func testRequest(t *testing.T, ts *httptest.Server, method, path string, reqBody
 	contentType := resp.Header.Get("Content-Type")
 	return resp.StatusCode, contentType, string(respBody)
 }
+
+// newTestAuthService returns the auth service with a generated key ring,
+// the optional dependencies are set by the test
+func newTestAuthService(
+	t *testing.T,
+	userService *services.UserService,
+	tokenRepository repository.TokenRepository,
+	sessionRepository repository.SessionRepository,
+) *services.AuthService {
+	keyRing, err := keyring.Generate()
+	require.NoError(t, err)
+	return services.NewAuthService(userService, tokenRepository, sessionRepository, keyRing)
+}
+
+// newTestServer serves the handlers behind the authentication middleware of authService,
+// the server is closed when the test ends
+func newTestServer(
+	t *testing.T,
+	authService *services.AuthService,
+	userService *services.UserService,
+	orderService *services.OrderService,
+	transactionService *services.TransactionService,
+) *httptest.Server {
+	serviceHandlers := NewServiceHandlers(authService, userService, orderService, transactionService, nil)
+	ts := httptest.NewServer(NewRouter(serviceHandlers, middleware.NewAuthMiddleware(authService).WithAuthentication))
+	t.Cleanup(ts.Close)
+	return ts
+}
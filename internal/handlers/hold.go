@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+type HoldDTO struct {
+	ID            int64        `json:"id"`
+	Order         string       `json:"order"` // номер заказа, в счёт оплаты которого зарезервированы баллы
+	Sum           money.Amount `json:"sum"`   // сумма зарезервированных баллов
+	Status        string       `json:"status"`
+	TransactionID int64        `json:"transaction_id,omitempty"` // проводка списания для статуса CAPTURED
+	CreatedAt     time.Time    `json:"created_at"`
+	ExpiresAt     time.Time    `json:"expires_at"`
+}
+
+func newHoldDTO(hold *repository.Hold) HoldDTO {
+	return HoldDTO{
+		ID:            hold.ID,
+		Order:         hold.OrderNumber,
+		Sum:           hold.Amount,
+		Status:        hold.Status,
+		TransactionID: hold.TransactionID,
+		CreatedAt:     hold.Created,
+		ExpiresAt:     hold.Expires,
+	}
+}
+
+// PostHoldHandler резервирование баллов
+// #### **Резервирование баллов**
+//
+// Хендлер: `POST /api/user/balance/holds`.
+//
+// Хендлер доступен только авторизованному пользователю. Первый шаг двухфазного списания: баллы резервируются
+// в счёт оплаты заказа и больше не входят в `current` баланса, но ещё не списаны. Резерв подтверждается
+// `POST /api/user/balance/holds/{id}/capture` или отменяется `POST /api/user/balance/holds/{id}/release`.
+// Резерв, который не подтверждён и не отменён за время `HOLD_TTL`, истекает, и баллы снова доступны.
+//
+// Формат запроса такой же, как у `POST /api/user/balance/withdraw`, для крупной суммы у пользователя
+// с двухфакторной аутентификацией нужен код в поле `totp_code`:
+//
+// POST /api/user/balance/holds HTTP/1.1
+// Content-Type: application/json
+//
+// {
+// "order": "2377225624",
+// "sum": 751
+// }
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "id": 5,
+// "order": "2377225624",
+// "sum": 751,
+// "status": "HELD",
+// "created_at": "2020-12-10T15:15:45+03:00",
+// "expires_at": "2020-12-10T15:30:45+03:00"
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — баллы зарезервированы;
+// *   `400` — неверный формат запроса или сумма;
+// *   `401` — пользователь не авторизован;
+// *   `402` — на счету недостаточно доступных средств;
+// *   `403` — нужен код из приложения-аутентификатора или код неверен;
+// *   `429` — слишком много неверных кодов, заголовок `Retry-After` содержит число секунд до следующей попытки;
+// *   `422` — неверный номер заказа;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostHoldHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		logger.Logger().Debug("middleware.GetUserID", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Logger().Debug("io.ReadAll", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var withdrawRequestDTO WithdrawRequestDTO
+	err = json.Unmarshal(bytes, &withdrawRequestDTO)
+	if err != nil {
+		logger.Logger().Debug("json.Unmarshal", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !h.verifyWithdrawal(w, r, userID, withdrawRequestDTO) {
+		return
+	}
+
+	hold, err := h.transactionService.PlaceHold(ctx, userID, withdrawRequestDTO.Sum, withdrawRequestDTO.OrderWithdrawNumber)
+	if err != nil {
+		logger.Logger().Debug("transactionService.PlaceHold", zap.Error(err))
+		writeWithdrawError(w, err)
+		return
+	}
+
+	writeJSON(w, newHoldDTO(hold))
+}
+
+// PostHoldCaptureHandler подтверждение резерва баллов
+// #### **Подтверждение резерва баллов**
+//
+// Хендлер: `POST /api/user/balance/holds/{id}/capture`.
+//
+// Хендлер доступен только авторизованному пользователю. Зарезервированные баллы списываются так же,
+// как `POST /api/user/balance/withdraw`: списание показывается в `GET /api/user/withdrawals`
+// и входит в `withdrawn` баланса. В ответе резерв со статусом `CAPTURED` и проводкой списания в поле `transaction_id`.
+//
+// Формат запроса:
+//
+// POST /api/user/balance/holds/5/capture HTTP/1.1
+// Content-Length: 0
+//
+// Возможные коды ответа:
+//
+// *   `200` — баллы списаны;
+// *   `400` — неверный идентификатор резерва;
+// *   `401` — пользователь не авторизован;
+// *   `404` — резерв не найден;
+// *   `409` — резерв уже подтверждён, отменён или истёк;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostHoldCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	h.finishHold(w, r, h.transactionService.CaptureHold)
+}
+
+// PostHoldReleaseHandler отмена резерва баллов
+// #### **Отмена резерва баллов**
+//
+// Хендлер: `POST /api/user/balance/holds/{id}/release`.
+//
+// Хендлер доступен только авторизованному пользователю. Резерв отменяется, баллы снова доступны для списания.
+// В ответе резерв со статусом `RELEASED`.
+//
+// Формат запроса:
+//
+// POST /api/user/balance/holds/5/release HTTP/1.1
+// Content-Length: 0
+//
+// Возможные коды ответа:
+//
+// *   `200` — резерв отменён;
+// *   `400` — неверный идентификатор резерва;
+// *   `401` — пользователь не авторизован;
+// *   `404` — резерв не найден;
+// *   `409` — резерв уже подтверждён, отменён или истёк;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostHoldReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	h.finishHold(w, r, h.transactionService.ReleaseHold)
+}
+
+func (h *ServiceHandlers) finishHold(
+	w http.ResponseWriter,
+	r *http.Request,
+	finish func(ctx context.Context, userID int64, holdID int64) (*repository.Hold, error),
+) {
+	ctx := r.Context()
+	userID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	holdID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || holdID <= 0 {
+		http.Error(w, "invalid hold id", http.StatusBadRequest)
+		return
+	}
+
+	hold, err := finish(ctx, userID, holdID)
+	if err != nil {
+		logger.Logger().Debug("finish hold", zap.Int64("holdID", holdID), zap.Error(err))
+		switch {
+		case errors.Is(err, services.ErrHoldNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, services.ErrHoldNotActive):
+			w.WriteHeader(http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, newHoldDTO(hold))
+}
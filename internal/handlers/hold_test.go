@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHolds(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockTransactionRepository := mock.NewMockTransactionRepository(ctrl)
+	transactionService := services.NewTransactionService(mockTransactionRepository)
+	serviceHandlers := NewServiceHandlers(services.NewAuthService(nil, nil, nil, nil), nil, nil, transactionService, nil)
+
+	mw := func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), middleware.UserIDContextKey, testUser)
+			h.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+	ts := httptest.NewServer(NewRouter(serviceHandlers, mw))
+	defer ts.Close()
+
+	created := time.Now().UTC().Truncate(time.Second)
+	hold := repository.Hold{ID: 5, UserID: testUser, Amount: money.FromPoints(751), OrderNumber: "2377225624",
+		Status: repository.HoldStatusHeld, Created: created, Expires: created.Add(services.DefaultHoldTTL)}
+
+	// the hold reserves the points for the default TTL
+	mockTransactionRepository.EXPECT().
+		PlaceHold(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ interface{}, h repository.Hold) (*repository.Hold, error) {
+			require.Equal(t, testUser, h.UserID)
+			require.Equal(t, money.FromPoints(751), h.Amount)
+			require.Equal(t, "2377225624", h.OrderNumber)
+			require.WithinDuration(t, time.Now().Add(services.DefaultHoldTTL), h.Expires, time.Minute)
+			return &hold, nil
+		})
+	statusCode, _, body := testRequest(t, ts, http.MethodPost, "/api/user/balance/holds",
+		bytes.NewBufferString(`{"order":"2377225624","sum":751}`))
+	require.Equal(t, http.StatusOK, statusCode)
+	holdDTO := HoldDTO{}
+	require.NoError(t, json.Unmarshal([]byte(body), &holdDTO))
+	require.Equal(t, HoldDTO{ID: 5, Order: "2377225624", Sum: money.FromPoints(751), Status: repository.HoldStatusHeld,
+		CreatedAt: created, ExpiresAt: created.Add(services.DefaultHoldTTL)}, holdDTO)
+
+	// the held points are not available for another hold
+	mockTransactionRepository.EXPECT().PlaceHold(gomock.Any(), gomock.Any()).Return(nil, repository.ErrInsufficientFunds)
+	statusCode, _, _ = testRequest(t, ts, http.MethodPost, "/api/user/balance/holds",
+		bytes.NewBufferString(`{"order":"2377225624","sum":751}`))
+	require.Equal(t, http.StatusPaymentRequired, statusCode)
+
+	statusCode, _, _ = testRequest(t, ts, http.MethodPost, "/api/user/balance/holds",
+		bytes.NewBufferString(`{"order":"2377225625","sum":751}`))
+	require.Equal(t, http.StatusUnprocessableEntity, statusCode)
+
+	// capture withdraws the held points
+	captured := hold
+	captured.Status = repository.HoldStatusCaptured
+	captured.TransactionID = 11
+	mockTransactionRepository.EXPECT().CaptureHold(gomock.Any(), testUser, int64(5)).Return(&captured, nil)
+	statusCode, _, body = testRequest(t, ts, http.MethodPost, "/api/user/balance/holds/5/capture", nil)
+	require.Equal(t, http.StatusOK, statusCode)
+	holdDTO = HoldDTO{}
+	require.NoError(t, json.Unmarshal([]byte(body), &holdDTO))
+	require.Equal(t, repository.HoldStatusCaptured, holdDTO.Status)
+	require.Equal(t, int64(11), holdDTO.TransactionID)
+
+	// a finished hold can't be released
+	mockTransactionRepository.EXPECT().ReleaseHold(gomock.Any(), testUser, int64(5)).Return(nil, repository.ErrHoldNotActive)
+	statusCode, _, _ = testRequest(t, ts, http.MethodPost, "/api/user/balance/holds/5/release", nil)
+	require.Equal(t, http.StatusConflict, statusCode)
+
+	released := hold
+	released.ID = 6
+	released.Status = repository.HoldStatusReleased
+	mockTransactionRepository.EXPECT().ReleaseHold(gomock.Any(), testUser, int64(6)).Return(&released, nil)
+	statusCode, _, body = testRequest(t, ts, http.MethodPost, "/api/user/balance/holds/6/release", nil)
+	require.Equal(t, http.StatusOK, statusCode)
+	holdDTO = HoldDTO{}
+	require.NoError(t, json.Unmarshal([]byte(body), &holdDTO))
+	require.Equal(t, repository.HoldStatusReleased, holdDTO.Status)
+
+	// holds of other users are not found
+	mockTransactionRepository.EXPECT().CaptureHold(gomock.Any(), testUser, int64(7)).Return(nil, repository.ErrHoldNotFound)
+	statusCode, _, _ = testRequest(t, ts, http.MethodPost, "/api/user/balance/holds/7/capture", nil)
+	require.Equal(t, http.StatusNotFound, statusCode)
+
+	statusCode, _, _ = testRequest(t, ts, http.MethodPost, "/api/user/balance/holds/abc/capture", nil)
+	require.Equal(t, http.StatusBadRequest, statusCode)
+}
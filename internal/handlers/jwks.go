@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/andreevym/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// GetJWKSHandler публичные ключи проверки токенов
+// #### **Публичные ключи проверки токенов**
+//
+// Хендлер: `GET /.well-known/jwks.json`.
+//
+// Возвращает JSON Web Key Set (RFC 7517) с ключами, которыми проверяются access-токены.
+// Заголовок `kid` токена указывает ключ, которым токен подписан.
+//
+// Пример ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "keys": [
+// {"kty": "EC", "crv": "P-256", "x": "<x>", "y": "<y>", "kid": "<kid>", "use": "sig", "alg": "ES256"}
+// ]
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — успешная обработка запроса;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) GetJWKSHandler(w http.ResponseWriter, r *http.Request) {
+	bytes, err := json.Marshal(h.authService.JWKS())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// keys are rotated rarely, a rotated key stays in the set until the tokens signed with it expire
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bytes)
+	if err != nil {
+		logger.Logger().Error("write jwks response", zap.Error(err))
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/andreevym/gophermart/internal/middleware"
 	"github.com/andreevym/gophermart/internal/repository/postgres"
 	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
 	"go.uber.org/zap"
 )
 
@@ -22,8 +23,8 @@ type GetOrdersResponseDTO struct {
 	// Status статус расчёта начисления
 	Status string `json:"status"`
 	// Accrual рассчитанные баллы к начислению, при отсутствии начисления — поле отсутствует в ответе.
-	Accrual    float32 `json:"accrual,omitempty"`
-	UploadedAt string  `json:"uploaded_at,omitempty"`
+	Accrual    money.Amount `json:"accrual,omitempty"`
+	UploadedAt string       `json:"uploaded_at,omitempty"`
 }
 
 // GetOrdersHandler ### Взаимодействие с системой расчёта начислений баллов лояльности
@@ -12,6 +12,7 @@ import (
 	"github.com/andreevym/gophermart/internal/repository"
 	"github.com/andreevym/gophermart/internal/repository/mock"
 	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/money"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -86,8 +87,7 @@ func TestPostOrdersHandler(t *testing.T) {
 			}
 			orderService := services.NewOrderService(nil, mockOrderRepository, nil)
 
-			jwtSecretKey := ""
-			authService := services.NewAuthService(userService, jwtSecretKey)
+			authService := services.NewAuthService(userService, nil, nil, nil)
 			serviceHandlers := NewServiceHandlers(authService, userService, orderService, nil, nil)
 
 			mw := func(h http.Handler) http.Handler {
@@ -180,7 +180,7 @@ func TestGetOrdersHandler(t *testing.T) {
 					Number:     "12345678903",
 					UserID:     testUser,
 					Status:     services.ProcessedOrderStatus,
-					Accrual:    1,
+					Accrual:    money.FromPoints(1),
 					UploadedAt: uploadedAtTime,
 				},
 			},
@@ -199,7 +199,7 @@ func TestGetOrdersHandler(t *testing.T) {
 					Number:  "12345678903",
 					UserID:  testUser,
 					Status:  services.ProcessingOrderStatus,
-					Accrual: 1,
+					Accrual: money.FromPoints(1),
 				},
 			},
 		},
@@ -220,8 +220,7 @@ func TestGetOrdersHandler(t *testing.T) {
 			}
 			orderService := services.NewOrderService(nil, mockOrderRepository, nil)
 
-			jwtSecretKey := ""
-			authService := services.NewAuthService(userService, jwtSecretKey)
+			authService := services.NewAuthService(userService, nil, nil, nil)
 			serviceHandlers := NewServiceHandlers(authService, userService, orderService, nil, nil)
 
 			mw := func(h http.Handler) http.Handler {
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+type ReversalRequestDTO struct {
+	Reason string `json:"reason"` // причина возврата баллов, например отмена покупки
+}
+
+type AdminReversalDTO struct {
+	TransactionID           int64        `json:"transaction_id"`
+	WithdrawalTransactionID int64        `json:"withdrawal_transaction_id"`
+	UserID                  int64        `json:"user_id"`
+	Order                   string       `json:"order"`
+	Sum                     money.Amount `json:"sum"`
+	Reason                  string       `json:"reason"`
+	ActorUserID             int64        `json:"actor_user_id"`
+	ReversedAt              time.Time    `json:"reversed_at"`
+}
+
+type StoreReversalDTO struct {
+	Order      string       `json:"order"`
+	Sum        money.Amount `json:"sum"`
+	ReversedAt time.Time    `json:"reversed_at"`
+}
+
+// PostAdminWithdrawalReversalHandler возврат списанных баллов
+// #### **Возврат списанных баллов**
+//
+// Хендлер: `POST /api/admin/withdrawals/{id}/reversal`.
+//
+// Хендлер доступен пользователям с ролью `admin`. Возвращает пользователю баллы списания с идентификатором
+// проводки `id` (см. `GET /api/admin/users/{id}/transactions`) операцией `reversal` с системного счёта
+// `WithdrawUserID`. Возвращённые баллы вычитаются из суммы `withdrawn` пользователя, списание
+// показывается в `GET /api/user/withdrawals` со статусом `REVERSED`. Списание возвращается только один раз.
+//
+// Формат запроса:
+//
+// POST /api/admin/withdrawals/7/reversal HTTP/1.1
+// Content-Type: application/json
+//
+// {
+// "reason": "покупка отменена, обращение SUP-1234"
+// }
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "transaction_id": 9,
+// "withdrawal_transaction_id": 7,
+// "user_id": 42,
+// "order": "2377225624",
+// "sum": 751,
+// "reason": "покупка отменена, обращение SUP-1234",
+// "actor_user_id": 3,
+// "reversed_at": "2020-12-10T15:15:45+03:00"
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — баллы возвращены;
+// *   `400` — неверный формат запроса или идентификатор, не указана причина, причина ошибки в теле ответа;
+// *   `401` — пользователь не авторизован;
+// *   `403` — у пользователя нет роли `admin`;
+// *   `404` — списание не найдено;
+// *   `409` — списание уже возвращено;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostAdminWithdrawalReversalHandler(w http.ResponseWriter, r *http.Request) {
+	withdrawalID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || withdrawalID <= 0 {
+		http.Error(w, "invalid withdrawal id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	adminID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	reversalRequestDTO, ok := readReversalRequest(w, r)
+	if !ok {
+		return
+	}
+
+	reversal, err := h.transactionService.ReverseWithdrawal(ctx, adminID, withdrawalID, reversalRequestDTO.Reason)
+	if err != nil {
+		writeReversalError(w, err)
+		return
+	}
+
+	writeJSON(w, AdminReversalDTO{
+		TransactionID:           reversal.TransactionID,
+		WithdrawalTransactionID: reversal.WithdrawalTransactionID,
+		UserID:                  reversal.UserID,
+		Order:                   reversal.OrderNumber,
+		Sum:                     reversal.Amount,
+		Reason:                  reversal.Reason,
+		ActorUserID:             reversal.ActorUserID,
+		ReversedAt:              reversal.Created,
+	})
+}
+
+// PostStoreOrderReversalHandler возврат баллов за отменённую покупку
+// #### **Возврат баллов за отменённую покупку**
+//
+// Хендлер: `POST /api/store/orders/{number}/reversal`.
+//
+// Хендлер доступен учётной записи магазина с ролью `store`. Магазин отменил покупку `number`,
+// оплаченную баллами, и все ещё не возвращённые списания в счёт этого заказа возвращаются пользователю
+// так же, как в `POST /api/admin/withdrawals/{id}/reversal`. Повторный запрос не возвращает баллы второй раз.
+//
+// Формат запроса:
+//
+// POST /api/store/orders/2377225624/reversal HTTP/1.1
+// Content-Type: application/json
+//
+// {
+// "reason": "покупка отменена покупателем"
+// }
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// [
+// {
+// "order": "2377225624",
+// "sum": 751,
+// "reversed_at": "2020-12-10T15:15:45+03:00"
+// }
+// ]
+//
+// Возможные коды ответа:
+//
+// *   `200` — баллы возвращены;
+// *   `400` — неверный формат запроса, не указана причина, причина ошибки в теле ответа;
+// *   `401` — пользователь не авторизован;
+// *   `403` — у пользователя нет роли `store`;
+// *   `404` — в счёт заказа не было списаний;
+// *   `409` — все списания в счёт заказа уже возвращены;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostStoreOrderReversalHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	storeUserID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	reversalRequestDTO, ok := readReversalRequest(w, r)
+	if !ok {
+		return
+	}
+
+	reversals, err := h.transactionService.ReverseOrderWithdrawals(ctx, storeUserID, chi.URLParam(r, "number"), reversalRequestDTO.Reason)
+	if err != nil {
+		writeReversalError(w, err)
+		return
+	}
+
+	reversalDTOs := make([]StoreReversalDTO, 0, len(reversals))
+	for _, reversal := range reversals {
+		reversalDTOs = append(reversalDTOs, newStoreReversalDTO(reversal))
+	}
+	writeJSON(w, reversalDTOs)
+}
+
+func newStoreReversalDTO(reversal repository.Reversal) StoreReversalDTO {
+	return StoreReversalDTO{
+		Order:      reversal.OrderNumber,
+		Sum:        reversal.Amount,
+		ReversedAt: reversal.Created,
+	}
+}
+
+func readReversalRequest(w http.ResponseWriter, r *http.Request) (*ReversalRequestDTO, bool) {
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	var reversalRequestDTO ReversalRequestDTO
+	err = json.Unmarshal(bytes, &reversalRequestDTO)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	return &reversalRequestDTO, true
+}
+
+func writeReversalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrReversalInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrWithdrawalNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, services.ErrWithdrawalAlreadyReversed):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		logger.Logger().Error("reverse withdrawal", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithdrawalReversal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	const (
+		adminID    = int64(3)
+		storeID    = int64(5)
+		customerID = int64(42)
+	)
+	userService := services.NewUserService(mock.NewMockUserRepository(ctrl))
+	mockTransactionRepository := mock.NewMockTransactionRepository(ctrl)
+	transactionService := services.NewTransactionService(mockTransactionRepository)
+
+	mockTokenRepository := mock.NewMockTokenRepository(ctrl)
+	mockTokenRepository.EXPECT().IsAccessTokenRevoked(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+	authService := newTestAuthService(t, userService, mockTokenRepository, nil)
+	ts := newTestServer(t, authService, userService, nil, transactionService)
+
+	body := `{"reason":"purchase cancelled"}`
+	reversedAt := time.Now().UTC().Truncate(time.Second)
+	withdrawal := repository.Transaction{TransactionID: 7, FromUserID: customerID, ToUserID: services.WithdrawUserID,
+		Amount: money.FromPoints(751), OrderNumber: "2377225624", OperationType: repository.WithdrawOperationType, Created: reversedAt}
+	reversal := repository.Reversal{TransactionID: 9, WithdrawalTransactionID: 7, UserID: customerID, Amount: money.FromPoints(751),
+		OrderNumber: "2377225624", ActorUserID: adminID, Reason: "purchase cancelled", Created: reversedAt}
+
+	// support staff and the store can't reverse a withdrawal by id
+	supportToken, err := authService.GenerateToken(adminID, "session", []string{repository.RoleSupport, repository.RoleStore})
+	require.NoError(t, err)
+	resp, _ := authRequest(t, ts, http.MethodPost, "/api/admin/withdrawals/7/reversal", supportToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	adminToken, err := authService.GenerateToken(adminID, "session", []string{repository.RoleAdmin})
+	require.NoError(t, err)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/withdrawals/7/reversal", adminToken, bytes.NewBufferString(`{"reason":" "}`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	mockTransactionRepository.EXPECT().
+		ReverseWithdrawal(gomock.Any(), repository.Reversal{WithdrawalTransactionID: 7, ActorUserID: adminID, Reason: "purchase cancelled"}).
+		Return(&reversal, nil)
+	resp, respBody := authRequest(t, ts, http.MethodPost, "/api/admin/withdrawals/7/reversal", adminToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	adminReversal := AdminReversalDTO{}
+	require.NoError(t, json.Unmarshal(respBody, &adminReversal))
+	require.Equal(t, AdminReversalDTO{TransactionID: 9, WithdrawalTransactionID: 7, UserID: customerID, Order: "2377225624",
+		Sum: money.FromPoints(751), Reason: "purchase cancelled", ActorUserID: adminID, ReversedAt: reversedAt}, adminReversal)
+
+	// the second reversal is refused
+	mockTransactionRepository.EXPECT().ReverseWithdrawal(gomock.Any(), gomock.Any()).Return(nil, repository.ErrWithdrawalAlreadyReversed)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/withdrawals/7/reversal", adminToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	mockTransactionRepository.EXPECT().ReverseWithdrawal(gomock.Any(), gomock.Any()).Return(nil, postgres.ErrTransactionNotFound)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/admin/withdrawals/8/reversal", adminToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// the store API is only for the store account
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/store/orders/2377225624/reversal", adminToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	storeToken, err := authService.GenerateToken(storeID, "session", []string{repository.RoleStore})
+	require.NoError(t, err)
+	mockTransactionRepository.EXPECT().GetWithdrawalsByOrderNumber(gomock.Any(), "12345678903").Return([]repository.Withdrawal{}, nil)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/store/orders/12345678903/reversal", storeToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// the store cancels the purchase, only the withdrawal not reversed yet is returned
+	secondWithdrawal := withdrawal
+	secondWithdrawal.TransactionID = 8
+	secondWithdrawal.Amount = money.FromPoints(49)
+	storeReversal := repository.Reversal{TransactionID: 10, WithdrawalTransactionID: 8, UserID: customerID, Amount: money.FromPoints(49),
+		OrderNumber: "2377225624", ActorUserID: storeID, Reason: "purchase cancelled", Created: reversedAt}
+	mockTransactionRepository.EXPECT().
+		GetWithdrawalsByOrderNumber(gomock.Any(), "2377225624").
+		Return([]repository.Withdrawal{{Transaction: withdrawal, Reversal: &reversal}, {Transaction: secondWithdrawal}}, nil)
+	mockTransactionRepository.EXPECT().
+		ReverseWithdrawal(gomock.Any(), repository.Reversal{WithdrawalTransactionID: 8, ActorUserID: storeID, Reason: "purchase cancelled"}).
+		Return(&storeReversal, nil)
+	resp, respBody = authRequest(t, ts, http.MethodPost, "/api/store/orders/2377225624/reversal", storeToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	storeReversals := make([]StoreReversalDTO, 0)
+	require.NoError(t, json.Unmarshal(respBody, &storeReversals))
+	require.Equal(t, []StoreReversalDTO{{Order: "2377225624", Sum: money.FromPoints(49), ReversedAt: reversedAt}}, storeReversals)
+
+	mockTransactionRepository.EXPECT().
+		GetWithdrawalsByOrderNumber(gomock.Any(), "2377225624").
+		Return([]repository.Withdrawal{{Transaction: withdrawal, Reversal: &reversal}, {Transaction: secondWithdrawal, Reversal: &storeReversal}}, nil)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/store/orders/2377225624/reversal", storeToken, bytes.NewBufferString(body))
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// the user sees the reversal status of the withdrawals
+	customerToken, err := authService.GenerateToken(customerID, "session", nil)
+	require.NoError(t, err)
+	mockTransactionRepository.EXPECT().
+		GetWithdrawalsByUserID(gomock.Any(), customerID).
+		Return([]repository.Withdrawal{{Transaction: withdrawal, Reversal: &reversal}, {Transaction: secondWithdrawal}}, nil)
+	resp, respBody = authRequest(t, ts, http.MethodGet, "/api/user/withdrawals", customerToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	withdrawals := make([]userWithdrawal, 0)
+	require.NoError(t, json.Unmarshal(respBody, &withdrawals))
+	require.Equal(t, []userWithdrawal{
+		{OrderWithdrawNumber: "2377225624", Sum: money.FromPoints(751), ProcessedAt: reversedAt, Status: WithdrawalStatusReversed, ReversedAt: &reversedAt},
+		{OrderWithdrawNumber: "2377225624", Sum: money.FromPoints(49), ProcessedAt: reversedAt, Status: WithdrawalStatusProcessed},
+	}, withdrawals)
+}
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"time"
 
+	authmiddleware "github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/repository"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -26,6 +28,26 @@ func NewRouter(s *ServiceHandlers, middlewares ...func(http.Handler) http.Handle
 	r.Post("/api/user/register", s.PostRegisterUser)
 	//POST /api/user/login — аутентификация пользователя;
 	r.Post("/api/user/login", s.PostLoginUser)
+	//POST /api/user/login/mfa — второй шаг аутентификации пользователя с двухфакторной аутентификацией;
+	r.Post("/api/user/login/mfa", s.PostLoginMFA)
+	//POST /api/user/token/refresh — обмен refresh-токена на новую пару токенов;
+	r.Post("/api/user/token/refresh", s.PostTokenRefresh)
+	//POST /api/user/logout — выход пользователя, отзыв токенов;
+	r.Post("/api/user/logout", s.PostLogoutUser)
+	//PUT /api/user/password — смена пароля;
+	r.Put("/api/user/password", s.PutUserPasswordHandler)
+	//DELETE /api/user — удаление учётной записи пользователя;
+	r.Delete("/api/user", s.DeleteUserHandler)
+	//GET /api/user/sessions — получение списка активных сессий пользователя;
+	r.Get("/api/user/sessions", s.GetSessionsHandler)
+	//DELETE /api/user/sessions/{id} — отзыв сессии или всех сессий, кроме текущей;
+	r.Delete("/api/user/sessions/{id}", s.DeleteSessionHandler)
+	//POST /api/user/2fa/totp — создание секрета TOTP и кодов восстановления;
+	r.Post("/api/user/2fa/totp", s.PostTOTPEnrollHandler)
+	//POST /api/user/2fa/totp/confirm — включение двухфакторной аутентификации;
+	r.Post("/api/user/2fa/totp/confirm", s.PostTOTPConfirmHandler)
+	//POST /api/user/2fa/totp/disable — отключение двухфакторной аутентификации;
+	r.Post("/api/user/2fa/totp/disable", s.PostTOTPDisableHandler)
 	//POST /api/user/orders — загрузка пользователем номера заказа для расчёта;
 	r.Post("/api/user/orders", s.PostOrdersHandler)
 	//GET /api/user/orders — получение списка загруженных пользователем номеров заказов, статусов их обработки и информации о начислениях;
@@ -34,8 +56,48 @@ func NewRouter(s *ServiceHandlers, middlewares ...func(http.Handler) http.Handle
 	r.Get("/api/user/balance", s.GetBalanceHandler)
 	//POST /api/user/balance/withdraw — запрос на списание баллов с накопительного счёта в счёт оплаты нового заказа;
 	r.Post("/api/user/balance/withdraw", s.PostWithdrawHandler)
+	//POST /api/user/balance/holds — резервирование баллов в счёт оплаты заказа;
+	r.Post("/api/user/balance/holds", s.PostHoldHandler)
+	//POST /api/user/balance/holds/{id}/capture — списание зарезервированных баллов;
+	r.Post("/api/user/balance/holds/{id}/capture", s.PostHoldCaptureHandler)
+	//POST /api/user/balance/holds/{id}/release — отмена резерва баллов;
+	r.Post("/api/user/balance/holds/{id}/release", s.PostHoldReleaseHandler)
 	//GET /api/user/withdrawals — получение информации о выводе средств с накопительного счёта пользователем.
 	r.Get("/api/user/withdrawals", s.GetWithdrawalsHandler)
+	//GET /api/user/adjustments — получение информации о корректировках баланса поддержкой;
+	r.Get("/api/user/adjustments", s.GetAdjustmentsHandler)
+	// the admin API is for support staff and operators, the roles are granted by the role command
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(authmiddleware.RequireRole(repository.RoleSupport, repository.RoleAdmin))
+		//GET /api/admin/users?login=<login> — поиск пользователя по логину;
+		r.Get("/users", s.GetAdminUserHandler)
+		//GET /api/admin/users/{id} — получение пользователя и его баланса;
+		r.Get("/users/{id}", s.GetAdminUserByIDHandler)
+		//GET /api/admin/users/{id}/orders — получение заказов пользователя с состоянием их обработки;
+		r.Get("/users/{id}/orders", s.GetAdminUserOrdersHandler)
+		//GET /api/admin/users/{id}/transactions — получение истории операций пользователя;
+		r.Get("/users/{id}/transactions", s.GetAdminUserTransactionsHandler)
+		//GET /api/admin/users/{id}/adjustments — получение корректировок баланса пользователя;
+		r.Get("/users/{id}/adjustments", s.GetAdminAdjustmentsHandler)
+		//POST /api/admin/users/{id}/adjustments — начисление или списание баллов с указанием причины, только для admin;
+		r.With(authmiddleware.RequireRole(repository.RoleAdmin)).Post("/users/{id}/adjustments", s.PostAdminAdjustmentHandler)
+		//POST /api/admin/orders/{number}/requeue — повторная обработка заказа в статусе INVALID, только для admin;
+		r.With(authmiddleware.RequireRole(repository.RoleAdmin)).Post("/orders/{number}/requeue", s.PostAdminOrderRequeueHandler)
+		//POST /api/admin/orders/{number}/recheck — повторный запрос начисления за заказ в статусе PROCESSED, только для admin;
+		r.With(authmiddleware.RequireRole(repository.RoleAdmin)).Post("/orders/{number}/recheck", s.PostAdminOrderRecheckHandler)
+		//POST /api/admin/orders/requeue — повторная обработка заказов по статусу и времени загрузки, только для admin;
+		r.With(authmiddleware.RequireRole(repository.RoleAdmin)).Post("/orders/requeue", s.PostAdminOrdersRequeueHandler)
+		//POST /api/admin/withdrawals/{id}/reversal — возврат списанных баллов пользователю, только для admin;
+		r.With(authmiddleware.RequireRole(repository.RoleAdmin)).Post("/withdrawals/{id}/reversal", s.PostAdminWithdrawalReversalHandler)
+	})
+	// the store API is for the account of the store, the role is granted by the role command
+	r.Route("/api/store", func(r chi.Router) {
+		r.Use(authmiddleware.RequireRole(repository.RoleStore))
+		//POST /api/store/orders/{number}/reversal — возврат баллов, списанных в счёт отменённой покупки;
+		r.Post("/orders/{number}/reversal", s.PostStoreOrderReversalHandler)
+	})
+	//GET /.well-known/jwks.json — публичные ключи проверки токенов;
+	r.Get("/.well-known/jwks.json", s.GetJWKSHandler)
 	r.Get("/api/ping", s.GetPingHandler)
 	r.Get("/", func(writer http.ResponseWriter, request *http.Request) {
 		writer.Header().Set("Content-Type", "text/html")
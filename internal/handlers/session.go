@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+const (
+	// DeviceNameHeader is set by the client applications to name the device in the list of sessions
+	DeviceNameHeader = "X-Device-Name"
+	// otherSessionsID revokes all sessions but the current one
+	otherSessionsID = "others"
+)
+
+type SessionDTO struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current marks the session the request is authenticated with
+	Current bool `json:"current"`
+}
+
+type RevokedSessionsDTO struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// clientInfo describes the client of the request, RemoteAddr is already replaced by RealIP middleware
+func clientInfo(r *http.Request) services.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return services.NewClientInfo(r.Header.Get(DeviceNameHeader), r.UserAgent(), ip)
+}
+
+// GetSessionsHandler получение списка сессий
+// #### **Получение списка сессий**
+//
+// Хендлер: `GET /api/user/sessions`.
+//
+// Хендлер доступен только авторизованному пользователю. Возвращает активные сессии пользователя,
+// сначала последние использованные. Сессия создаётся при входе и живёт до выхода или отзыва,
+// имя устройства передаётся клиентом при входе в заголовке `X-Device-Name`.
+//
+// Формат запроса:
+//
+// GET /api/user/sessions HTTP/1.1
+// Content-Length: 0
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// [
+// {
+// "id": "<id>",
+// "device": "iPhone",
+// "user_agent": "gophermart-ios/1.2",
+// "ip": "192.0.2.1",
+// "created_at": "2020-12-09T16:09:57+03:00",
+// "last_seen_at": "2020-12-10T16:09:57+03:00",
+// "current": true
+// }
+// ]
+//
+// Возможные коды ответа:
+//
+// *   `200` — успешная обработка запроса;
+// *   `401` — пользователь не авторизован;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, err := middleware.GetTokenClaims(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.authService.GetSessions(ctx, claims.UserID)
+	if err != nil {
+		logger.Logger().Error("authService.GetSessions", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sessionDTOs := make([]SessionDTO, 0, len(sessions))
+	for _, session := range sessions {
+		sessionDTOs = append(sessionDTOs, SessionDTO{
+			ID:         session.ID,
+			Device:     session.Device,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.Created,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == claims.FamilyID,
+		})
+	}
+	bytes, err := json.Marshal(sessionDTOs)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bytes)
+	if err != nil {
+		logger.Logger().Error("write sessions response", zap.Error(err))
+	}
+}
+
+// DeleteSessionHandler отзыв сессии
+// #### **Отзыв сессии**
+//
+// Хендлер: `DELETE /api/user/sessions/{id}`.
+//
+// Хендлер доступен только авторизованному пользователю. Отзывает сессию пользователя с идентификатором `id`,
+// access- и refresh-токены сессии перестают приниматься. Вместо идентификатора можно передать `others`,
+// тогда отзываются все сессии пользователя, кроме текущей, и в ответе возвращается их количество.
+//
+// Формат запроса:
+//
+// DELETE /api/user/sessions/others HTTP/1.1
+// Content-Length: 0
+//
+// Формат ответа при отзыве остальных сессий:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "revoked": 2
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — сессия отозвана;
+// *   `401` — пользователь не авторизован;
+// *   `404` — у пользователя нет активной сессии с таким идентификатором;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, err := middleware.GetTokenClaims(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := chi.URLParam(r, "id")
+	if sessionID == otherSessionsID {
+		h.deleteOtherSessions(w, r, claims)
+		return
+	}
+
+	err = h.authService.RevokeSession(ctx, claims.UserID, sessionID)
+	if err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.Logger().Error("authService.RevokeSession", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *ServiceHandlers) deleteOtherSessions(w http.ResponseWriter, r *http.Request, claims services.TokenClaims) {
+	revoked, err := h.authService.RevokeOtherSessions(r.Context(), claims.UserID, claims.FamilyID)
+	if err != nil {
+		logger.Logger().Error("authService.RevokeOtherSessions", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	bytes, err := json.Marshal(RevokedSessionsDTO{Revoked: revoked})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bytes)
+	if err != nil {
+		logger.Logger().Error("write revoked sessions response", zap.Error(err))
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionHandlers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	const currentSessionID = "current"
+	mockTokenRepository := mock.NewMockTokenRepository(ctrl)
+	mockTokenRepository.EXPECT().
+		IsAccessTokenRevoked(gomock.Any(), gomock.Any(), currentSessionID).
+		Return(false, nil).
+		AnyTimes()
+	mockSessionRepository := mock.NewMockSessionRepository(ctrl)
+
+	authService := newTestAuthService(t, nil, mockTokenRepository, mockSessionRepository)
+	ts := newTestServer(t, authService, nil, nil, nil)
+
+	accessToken, err := authService.GenerateToken(testUser, currentSessionID, nil)
+	require.NoError(t, err)
+
+	// the list marks the session of the request
+	now := time.Now().UTC().Truncate(time.Second)
+	mockSessionRepository.EXPECT().
+		GetActiveSessionsByUserID(gomock.Any(), testUser).
+		Return([]repository.Session{
+			{ID: currentSessionID, UserID: testUser, Device: "web", IP: "192.0.2.1", Created: now, LastSeenAt: now},
+			{ID: "phone", UserID: testUser, Device: "iPhone", UserAgent: "gophermart-ios", Created: now, LastSeenAt: now},
+		}, nil)
+	resp, body := authRequest(t, ts, http.MethodGet, "/api/user/sessions", accessToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	sessions := make([]SessionDTO, 0)
+	require.NoError(t, json.Unmarshal(body, &sessions))
+	require.Equal(t, []SessionDTO{
+		{ID: currentSessionID, Device: "web", IP: "192.0.2.1", CreatedAt: now, LastSeenAt: now, Current: true},
+		{ID: "phone", Device: "iPhone", UserAgent: "gophermart-ios", CreatedAt: now, LastSeenAt: now},
+	}, sessions)
+
+	mockSessionRepository.EXPECT().
+		RevokeSession(gomock.Any(), testUser, "phone").
+		Return(nil)
+	resp, _ = authRequest(t, ts, http.MethodDelete, "/api/user/sessions/phone", accessToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	mockSessionRepository.EXPECT().
+		RevokeSession(gomock.Any(), testUser, "unknown").
+		Return(repository.ErrSessionNotFound)
+	resp, _ = authRequest(t, ts, http.MethodDelete, "/api/user/sessions/unknown", accessToken, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// others keeps the current session
+	mockSessionRepository.EXPECT().
+		RevokeOtherSessions(gomock.Any(), testUser, currentSessionID).
+		Return(int64(2), nil)
+	resp, body = authRequest(t, ts, http.MethodDelete, "/api/user/sessions/others", accessToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, `{"revoked":2}`, string(body))
+
+	resp, _ = authRequest(t, ts, http.MethodGet, "/api/user/sessions", "", nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/andreevym/gophermart/internal/middleware"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// MFARequiredDTO is returned on login of a user with 2FA instead of tokens
+type MFARequiredDTO struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	// ExpiresIn is the lifetime of the mfa token in seconds
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type LoginMFADTO struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type TOTPEnrollmentDTO struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TOTPCodeDTO struct {
+	Code string `json:"code"`
+}
+
+func writeMFARequired(w http.ResponseWriter, mfaErr *services.MFARequiredError) {
+	bytes, err := json.Marshal(MFARequiredDTO{
+		MFARequired: true,
+		MFAToken:    mfaErr.MFAToken,
+		ExpiresIn:   int64(mfaErr.ExpiresIn.Seconds()),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_, err = w.Write(bytes)
+	if err != nil {
+		logger.Logger().Error("write mfa required response", zap.Error(err))
+	}
+}
+
+// PostLoginMFA второй шаг аутентификации
+// #### **Второй шаг аутентификации**
+//
+// Хендлер: `POST /api/user/login/mfa`.
+//
+// Завершает вход пользователя с включённой двухфакторной аутентификацией. `mfa_token` возвращается
+// `POST /api/user/login` после проверки пароля и действует 5 минут, `code` — код из приложения-аутентификатора
+// или один из кодов восстановления. Неверный код считается неудачной попыткой входа.
+//
+// Формат запроса:
+//
+// POST /api/user/login/mfa HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "mfa_token": "<mfa_token>",
+// "code": "123456"
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — пользователь успешно аутентифицирован, в теле ответа пара токенов, см. PostTokenRefresh;
+// *   `400` — неверный формат запроса;
+// *   `401` — `mfa_token` или код неверен;
+// *   `429` — слишком много неудачных попыток входа, заголовок `Retry-After` содержит число секунд до следующей попытки;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostLoginMFA(w http.ResponseWriter, r *http.Request) {
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	loginMFADTO := LoginMFADTO{}
+	err = json.Unmarshal(bytes, &loginMFADTO)
+	if err != nil || loginMFADTO.MFAToken == "" || loginMFADTO.Code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tokenPair, err := h.authService.LoginMFA(r.Context(), loginMFADTO.MFAToken, loginMFADTO.Code, clientInfo(r))
+	if err != nil {
+		logger.Logger().Warn("authService.LoginMFA", zap.Error(err))
+		var lockedErr *services.LoginLockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			writeTooManyRequests(w, lockedErr)
+		case errors.Is(err, services.ErrAuthMFATokenInvalid), errors.Is(err, services.ErrTOTPCodeInvalid):
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	writeTokenPair(w, tokenPair)
+}
+
+// PostTOTPEnrollHandler подключение двухфакторной аутентификации
+// #### **Подключение двухфакторной аутентификации**
+//
+// Хендлер: `POST /api/user/2fa/totp`.
+//
+// Хендлер доступен только авторизованному пользователю. Создаёт секрет TOTP и коды восстановления,
+// они возвращаются только один раз. `otpauth_uri` показывается пользователю QR-кодом для приложения-аутентификатора.
+// Двухфакторная аутентификация включается после подтверждения кодом, см. PostTOTPConfirmHandler,
+// повторный запрос до подтверждения заменяет секрет.
+//
+// Формат ответа:
+//
+// 200 OK HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "secret": "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP",
+// "otpauth_uri": "otpauth://totp/Gophermart:user?algorithm=SHA1&digits=6&issuer=Gophermart&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP",
+// "recovery_codes": ["k7vq2-m3xha", "..."]
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — секрет создан;
+// *   `401` — пользователь не авторизован;
+// *   `409` — двухфакторная аутентификация уже включена;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostTOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	enrollment, err := h.authService.EnrollTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrTOTPAlreadyEnabled) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		logger.Logger().Error("authService.EnrollTOTP", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	bytes, err := json.Marshal(TOTPEnrollmentDTO{
+		Secret:        enrollment.Secret,
+		URI:           enrollment.URI,
+		RecoveryCodes: enrollment.RecoveryCodes,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// the secret must not stay in any cache
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bytes)
+	if err != nil {
+		logger.Logger().Error("write totp enrollment response", zap.Error(err))
+	}
+}
+
+// PostTOTPConfirmHandler подтверждение двухфакторной аутентификации
+// #### **Подтверждение двухфакторной аутентификации**
+//
+// Хендлер: `POST /api/user/2fa/totp/confirm`.
+//
+// Хендлер доступен только авторизованному пользователю. Включает двухфакторную аутентификацию,
+// код из приложения-аутентификатора подтверждает, что секрет сохранён.
+//
+// Формат запроса:
+//
+// POST /api/user/2fa/totp/confirm HTTP/1.1
+// Content-Type: application/json
+//
+// {
+// "code": "123456"
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — двухфакторная аутентификация включена;
+// *   `400` — неверный формат запроса;
+// *   `401` — пользователь не авторизован;
+// *   `404` — секрет не создан, см. PostTOTPEnrollHandler;
+// *   `409` — двухфакторная аутентификация уже включена;
+// *   `422` — неверный код;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostTOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	h.handleTOTPCode(w, r, h.authService.ConfirmTOTP)
+}
+
+// PostTOTPDisableHandler отключение двухфакторной аутентификации
+// #### **Отключение двухфакторной аутентификации**
+//
+// Хендлер: `POST /api/user/2fa/totp/disable`.
+//
+// Хендлер доступен только авторизованному пользователю. Удаляет секрет и коды восстановления,
+// `code` — код из приложения-аутентификатора или один из кодов восстановления.
+//
+// Формат запроса:
+//
+// POST /api/user/2fa/totp/disable HTTP/1.1
+// Content-Type: application/json
+//
+// {
+// "code": "123456"
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — двухфакторная аутентификация отключена;
+// *   `400` — неверный формат запроса;
+// *   `401` — пользователь не авторизован;
+// *   `404` — двухфакторная аутентификация не включена;
+// *   `422` — неверный код;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostTOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+	h.handleTOTPCode(w, r, h.authService.DisableTOTP)
+}
+
+func (h *ServiceHandlers) handleTOTPCode(
+	w http.ResponseWriter,
+	r *http.Request,
+	action func(ctx context.Context, userID int64, code string) error,
+) {
+	ctx := r.Context()
+	userID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	codeDTO := TOTPCodeDTO{}
+	err = json.Unmarshal(bytes, &codeDTO)
+	if err != nil || codeDTO.Code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = action(ctx, userID, codeDTO.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTOTPNotEnrolled):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, services.ErrTOTPAlreadyEnabled):
+			w.WriteHeader(http.StatusConflict)
+		case errors.Is(err, services.ErrTOTPCodeInvalid):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		default:
+			logger.Logger().Error("totp action", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
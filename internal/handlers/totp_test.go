@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/andreevym/gophermart/pkg/password"
+	"github.com/andreevym/gophermart/pkg/totp"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPFlow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	passwordHash, err := password.Hash("password")
+	require.NoError(t, err)
+	user := &repository.User{ID: testUser, Username: "user", Password: passwordHash}
+	mockUserRepository := mock.NewMockUserRepository(ctrl)
+	mockUserRepository.EXPECT().GetUserByID(gomock.Any(), testUser).Return(user, nil).AnyTimes()
+	mockUserRepository.EXPECT().GetUserByUsername(gomock.Any(), "user").Return(user, nil).AnyTimes()
+	userService := services.NewUserService(mockUserRepository)
+
+	mockTokenRepository := mock.NewMockTokenRepository(ctrl)
+	mockTokenRepository.EXPECT().IsAccessTokenRevoked(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+	mockTokenRepository.EXPECT().CreateRefreshToken(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockSessionRepository := mock.NewMockSessionRepository(ctrl)
+	mockSessionRepository.EXPECT().CreateSession(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockLoginFailureRepository := mock.NewMockLoginFailureRepository(ctrl)
+	mockLoginFailureRepository.EXPECT().GetLoginFailure(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	mockLoginFailureRepository.EXPECT().ResetLoginFailures(gomock.Any(), gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+	mockTransactionRepository := mock.NewMockTransactionRepository(ctrl)
+	transactionService := services.NewTransactionService(mockTransactionRepository)
+
+	mockTOTPRepository := mock.NewMockTOTPRepository(ctrl)
+	authService := newTestAuthService(t, userService, mockTokenRepository, mockSessionRepository)
+	authService.TOTPRepository = mockTOTPRepository
+	authService.LoginThrottle = services.NewLoginThrottle(mockLoginFailureRepository)
+	ts := newTestServer(t, authService, userService, nil, transactionService)
+
+	accessToken, err := authService.GenerateToken(testUser, "session", nil)
+	require.NoError(t, err)
+
+	// enrollment returns the secret and the recovery codes once
+	var stored repository.TOTP
+	var recoveryCodeHashes []string
+	mockTOTPRepository.EXPECT().GetTOTP(gomock.Any(), testUser).Return(nil, nil)
+	mockTOTPRepository.EXPECT().
+		SaveTOTP(gomock.Any(), testUser, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ interface{}, userID int64, secret string, hashes []string) error {
+			stored = repository.TOTP{UserID: userID, Secret: secret}
+			recoveryCodeHashes = hashes
+			return nil
+		})
+	resp, body := authRequest(t, ts, http.MethodPost, "/api/user/2fa/totp", accessToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	enrollment := TOTPEnrollmentDTO{}
+	require.NoError(t, json.Unmarshal(body, &enrollment))
+	require.Equal(t, stored.Secret, enrollment.Secret)
+	require.Contains(t, enrollment.URI, "otpauth://totp/Gophermart:user?")
+	require.Len(t, enrollment.RecoveryCodes, len(recoveryCodeHashes))
+	require.NotContains(t, recoveryCodeHashes, enrollment.RecoveryCodes[0], "recovery codes must be stored hashed")
+
+	// the enrollment is confirmed with a code of the authenticator app
+	mockTOTPRepository.EXPECT().GetTOTP(gomock.Any(), testUser).Return(&stored, nil).Times(2)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/user/2fa/totp/confirm", accessToken, bytes.NewBufferString(`{"code":"12345"}`))
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	code, err := totp.Code(stored.Secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	mockTOTPRepository.EXPECT().UseTOTPStep(gomock.Any(), testUser, gomock.Any()).Return(true, nil)
+	mockTOTPRepository.EXPECT().EnableTOTP(gomock.Any(), testUser).Return(nil)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/user/2fa/totp/confirm", accessToken, bytes.NewBufferString(`{"code":"`+code+`"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	enabledAt := time.Now()
+	stored.EnabledAt = &enabledAt
+	mockTOTPRepository.EXPECT().GetTOTP(gomock.Any(), testUser).Return(&stored, nil).AnyTimes()
+
+	// the password alone is not enough anymore
+	resp, body = authRequest(t, ts, http.MethodPost, "/api/user/login", "", bytes.NewBufferString(`{"login":"user","password":"password"}`))
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	mfaRequired := MFARequiredDTO{}
+	require.NoError(t, json.Unmarshal(body, &mfaRequired))
+	require.True(t, mfaRequired.MFARequired)
+	require.NotEmpty(t, mfaRequired.MFAToken)
+	require.Empty(t, resp.Header.Get("Authorization"))
+
+	// the mfa token is not an access token
+	resp, _ = authRequest(t, ts, http.MethodGet, "/api/user/sessions", mfaRequired.MFAToken, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// a recovery code completes the login
+	mockTOTPRepository.EXPECT().UseRecoveryCode(gomock.Any(), testUser, gomock.Any()).Return(true, nil)
+	resp, body = authRequest(t, ts, http.MethodPost, "/api/user/login/mfa", "",
+		bytes.NewBufferString(`{"mfa_token":"`+mfaRequired.MFAToken+`","code":"`+enrollment.RecoveryCodes[0]+`"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	tokens := TokenDTO{}
+	require.NoError(t, json.Unmarshal(body, &tokens))
+	require.NotEmpty(t, tokens.AccessToken)
+
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/user/login/mfa", "",
+		bytes.NewBufferString(`{"mfa_token":"forged","code":"123456"}`))
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// a withdrawal above the threshold needs a fresh code
+	sum := services.DefaultWithdrawTOTPThreshold + money.FromPoints(1)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/user/balance/withdraw", accessToken,
+		bytes.NewBufferString(`{"order":"2377225624","sum":`+sum.String()+`}`))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	mockTOTPRepository.EXPECT().UseTOTPStep(gomock.Any(), testUser, gomock.Any()).Return(true, nil)
+	mockTransactionRepository.EXPECT().
+		Withdraw(gomock.Any(), testUser, "2377225624", sum).
+		Return(&repository.Transaction{}, nil)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/user/balance/withdraw", accessToken,
+		bytes.NewBufferString(`{"order":"2377225624","sum":`+sum.String()+`,"totp_code":"`+code+`"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the same code is not accepted twice
+	mockTOTPRepository.EXPECT().UseTOTPStep(gomock.Any(), testUser, gomock.Any()).Return(false, nil)
+	mockLoginFailureRepository.EXPECT().
+		RecordLoginFailure(gomock.Any(), repository.LoginFailureScopeLogin, "user", gomock.Any()).
+		Return(&repository.LoginFailure{Failures: 1}, nil)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/user/balance/withdraw", accessToken,
+		bytes.NewBufferString(`{"order":"2377225624","sum":`+sum.String()+`,"totp_code":"`+code+`"}`))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
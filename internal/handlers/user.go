@@ -7,8 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 
+	"github.com/andreevym/gophermart/internal/middleware"
 	"github.com/andreevym/gophermart/internal/services"
 	"github.com/andreevym/gophermart/pkg/logger"
 	"go.uber.org/zap"
@@ -19,12 +22,58 @@ type AuthDTO struct {
 	Password string `json:"password"`
 }
 
+// TokenDTO is returned on login, registration and refresh, the access token is also set in the Authorization header
+type TokenDTO struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// ExpiresIn is the lifetime of the access token in seconds
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type RefreshTokenDTO struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ChangePasswordDTO struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type DeleteUserDTO struct {
+	Password string `json:"password"`
+	// TOTPCode is required if the user has 2FA enabled, a recovery code is accepted as well
+	TOTPCode string `json:"totp_code,omitempty"`
+}
+
+// writeTokenPair sets the access token in the Authorization header and writes both tokens in the body
+func writeTokenPair(w http.ResponseWriter, tokenPair *services.TokenPair) {
+	bytes, err := json.Marshal(TokenDTO{
+		AccessToken:  tokenPair.AccessToken,
+		RefreshToken: tokenPair.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokenPair.ExpiresIn.Seconds()),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Authorization", fmt.Sprintf("Bearer %s", tokenPair.AccessToken))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bytes)
+	if err != nil {
+		logger.Logger().Error("write token response", zap.Error(err))
+	}
+}
+
 // PostRegisterUser регистрация пользователя
 // #### **Регистрация пользователя**
 //
 // Хендлер: `POST /api/user/register`.
 //
-// Регистрация производится по паре логин/пароль. Каждый логин должен быть уникальным. После успешной регистрации должна происходить автоматическая аутентификация пользователя.
+// Регистрация производится по паре логин/пароль. Каждый логин должен быть уникальным. Логины не различаются по регистру и хранятся в нижнем регистре, пробелы по краям отбрасываются. Логин должен быть длиной от 3 до 64 символов, состоять из латинских букв, цифр, `.`, `_` и `-` и начинаться с буквы или цифры. После успешной регистрации должна происходить автоматическая аутентификация пользователя.
 //
 // Для передачи аутентификационных данных используйте механизм cookies или HTTP-заголовок `Authorization`.
 //
@@ -41,9 +90,9 @@ type AuthDTO struct {
 //
 // Возможные коды ответа:
 //
-// *   `200` — пользователь успешно зарегистрирован и аутентифицирован;
-// *   `400` — неверный формат запроса;
-// *   `409` — логин уже занят;
+// *   `200` — пользователь успешно зарегистрирован и аутентифицирован, в теле ответа пара токенов, см. PostTokenRefresh;
+// *   `400` — неверный формат запроса, логин не соответствует правилам или пароль не соответствует политике, причина в теле ответа;
+// *   `409` — логин уже занят, логины не различаются по регистру;
 // *   `500` — внутренняя ошибка сервера.
 func (h *ServiceHandlers) PostRegisterUser(w http.ResponseWriter, r *http.Request) {
 	bytes, err := io.ReadAll(r.Body)
@@ -60,18 +109,21 @@ func (h *ServiceHandlers) PostRegisterUser(w http.ResponseWriter, r *http.Reques
 	}
 
 	ctx := r.Context()
-	authToken, err := h.authService.Register(ctx, a.Login, a.Password)
+	tokenPair, err := h.authService.Register(ctx, a.Login, a.Password, clientInfo(r))
 	if err != nil {
 		logger.Logger().Warn("authService.Register", zap.Error(err))
-		if errors.Is(err, services.ErrUserAlreadyExists) {
+		switch {
+		case errors.Is(err, services.ErrUserAlreadyExists):
 			w.WriteHeader(http.StatusConflict)
-		} else {
+		case errors.Is(err, services.ErrUserLoginInvalid), errors.Is(err, services.ErrUserPasswordEmpty),
+			errors.Is(err, services.ErrUserPasswordWeak):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		return
 	}
-	w.Header().Add("Authorization", fmt.Sprintf("Bearer %s", authToken))
-	w.WriteHeader(http.StatusOK)
+	writeTokenPair(w, tokenPair)
 }
 
 // PostLoginUser аутентификация пользователя
@@ -79,7 +131,7 @@ func (h *ServiceHandlers) PostRegisterUser(w http.ResponseWriter, r *http.Reques
 //
 // Хендлер: `POST /api/user/login`.
 //
-// Аутентификация производится по паре логин/пароль.
+// Аутентификация производится по паре логин/пароль. Каждый вход создаёт новую сессию, см. GetSessionsHandler.
 //
 // Для передачи аутентификационных данных используйте механизм cookies или HTTP-заголовок `Authorization`.
 //
@@ -96,10 +148,17 @@ func (h *ServiceHandlers) PostRegisterUser(w http.ResponseWriter, r *http.Reques
 //
 // Возможные коды ответа:
 //
-// *   `200` — пользователь успешно аутентифицирован;
+// *   `200` — пользователь успешно аутентифицирован, в теле ответа пара токенов, см. PostTokenRefresh;
 // *   `400` — неверный формат запроса;
 // *   `401` — неверная пара логин/пароль;
+// *   `401` — у пользователя включена двухфакторная аутентификация, в теле ответа `mfa_token`,
+// вход завершается через PostLoginMFA;
+// *   `429` — слишком много неудачных попыток входа для логина или адреса клиента,
+// заголовок `Retry-After` содержит число секунд до следующей попытки;
 // *   `500` — внутренняя ошибка сервера.
+//
+// После нескольких неудачных попыток подряд вход блокируется, каждая следующая неудачная попытка
+// удваивает время блокировки. Разблокировать логин или адрес можно командой `gophermart unlock`.
 func (h *ServiceHandlers) PostLoginUser(w http.ResponseWriter, r *http.Request) {
 	bytes, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -113,17 +172,259 @@ func (h *ServiceHandlers) PostLoginUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	authToken, err := h.authService.Login(r.Context(), a.Login, a.Password)
+	tokenPair, err := h.authService.Login(r.Context(), a.Login, a.Password, clientInfo(r))
 	if err != nil {
 		logger.Logger().Warn("authService.Login", zap.Error(err))
-		if errors.Is(err, services.ErrAuthBadCredentials) {
+		var lockedErr *services.LoginLockedError
+		var mfaErr *services.MFARequiredError
+		switch {
+		case errors.As(err, &lockedErr):
+			writeTooManyRequests(w, lockedErr)
+		case errors.As(err, &mfaErr):
+			writeMFARequired(w, mfaErr)
+		case errors.Is(err, services.ErrAuthBadCredentials):
 			w.WriteHeader(http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	writeTokenPair(w, tokenPair)
+}
+
+// writeTooManyRequests tells the client how many seconds to wait before the next login attempt
+func writeTooManyRequests(w http.ResponseWriter, lockedErr *services.LoginLockedError) {
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(lockedErr.RetryAfter.Seconds())), 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
+
+// PostTokenRefresh обновление токенов
+// #### **Обновление токенов**
+//
+// Хендлер: `POST /api/user/token/refresh`.
+//
+// Обмен refresh-токена на новую пару токенов. Refresh-токен одноразовый: повторное использование
+// уже обменянного токена отзывает сессию и все её токены.
+//
+// Формат запроса:
+//
+// POST /api/user/token/refresh HTTP/1.1
+// Content-Type: application/json
+// ...
+//
+// {
+// "refresh_token": "<refresh_token>"
+// }
+//
+// Пример ответа:
+//
+// 200 OK HTTP/1.1
+// Authorization: Bearer <access_token>
+// Content-Type: application/json
+// ...
+//
+// {
+// "access_token": "<access_token>",
+// "refresh_token": "<refresh_token>",
+// "token_type": "Bearer",
+// "expires_in": 900
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — токены успешно обновлены;
+// *   `400` — неверный формат запроса;
+// *   `401` — refresh-токен неизвестен, истёк или отозван;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	refreshTokenDTO := RefreshTokenDTO{}
+	err = json.Unmarshal(bytes, &refreshTokenDTO)
+	if err != nil || refreshTokenDTO.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tokenPair, err := h.authService.Refresh(r.Context(), refreshTokenDTO.RefreshToken, clientInfo(r))
+	if err != nil {
+		logger.Logger().Warn("authService.Refresh", zap.Error(err))
+		if errors.Is(err, services.ErrAuthRefreshTokenInvalid) {
+			w.WriteHeader(http.StatusUnauthorized)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		return
 	}
-	w.Header().Add("Authorization", fmt.Sprintf("Bearer %s", authToken))
+	writeTokenPair(w, tokenPair)
+}
+
+// PostLogoutUser выход пользователя
+// #### **Выход пользователя**
+//
+// Хендлер: `POST /api/user/logout`.
+//
+// Отзывает access-токен запроса и текущую сессию со всеми её refresh-токенами.
+//
+// Формат запроса:
+//
+// POST /api/user/logout HTTP/1.1
+// Authorization: Bearer <access_token>
+// Content-Length: 0
+//
+// Возможные коды ответа:
+//
+// *   `200` — пользователь успешно вышел;
+// *   `401` — пользователь не авторизован;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PostLogoutUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, err := middleware.GetTokenClaims(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	err = h.authService.Logout(ctx, claims)
+	if err != nil {
+		logger.Logger().Error("authService.Logout", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// PutUserPasswordHandler смена пароля
+// #### **Смена пароля**
+//
+// Хендлер: `PUT /api/user/password`.
+//
+// Хендлер доступен только авторизованному пользователю. Новый пароль должен соответствовать политике паролей,
+// все сессии пользователя, кроме текущей, отзываются. Неверный старый пароль считается неудачной попыткой входа.
+//
+// Формат запроса:
+//
+// PUT /api/user/password HTTP/1.1
+// Content-Type: application/json
+//
+// {
+// "old_password": "<old_password>",
+// "new_password": "<new_password>"
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — пароль изменён;
+// *   `400` — неверный формат запроса или новый пароль не соответствует политике, причина в теле ответа;
+// *   `401` — пользователь не авторизован;
+// *   `403` — неверный старый пароль;
+// *   `429` — слишком много неудачных попыток, заголовок `Retry-After` содержит число секунд до следующей попытки;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) PutUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, err := middleware.GetTokenClaims(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	changePasswordDTO := ChangePasswordDTO{}
+	err = json.Unmarshal(bytes, &changePasswordDTO)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.authService.ChangePassword(ctx, claims, changePasswordDTO.OldPassword, changePasswordDTO.NewPassword)
+	if err != nil {
+		logger.Logger().Warn("authService.ChangePassword", zap.Error(err))
+		var lockedErr *services.LoginLockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			writeTooManyRequests(w, lockedErr)
+		case errors.Is(err, services.ErrUserPasswordInvalid):
+			w.WriteHeader(http.StatusForbidden)
+		case errors.Is(err, services.ErrUserPasswordEmpty), errors.Is(err, services.ErrUserPasswordWeak):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteUserHandler удаление учётной записи
+// #### **Удаление учётной записи**
+//
+// Хендлер: `DELETE /api/user`.
+//
+// Хендлер доступен только авторизованному пользователю. Логин и пароль пользователя заменяются
+// случайными значениями, все сессии отзываются, войти в учётную запись больше нельзя.
+// Заказы, баланс и журнал операций сохраняются для бухгалтерского учёта.
+// Если у пользователя включена двухфакторная аутентификация, нужен код в поле `totp_code`.
+//
+// Формат запроса:
+//
+// DELETE /api/user HTTP/1.1
+// Content-Type: application/json
+//
+// {
+// "password": "<password>",
+// "totp_code": "123456"
+// }
+//
+// Возможные коды ответа:
+//
+// *   `200` — учётная запись удалена;
+// *   `400` — неверный формат запроса;
+// *   `401` — пользователь не авторизован;
+// *   `403` — неверный пароль или код, либо нужен код из приложения-аутентификатора;
+// *   `429` — слишком много неудачных попыток, заголовок `Retry-After` содержит число секунд до следующей попытки;
+// *   `500` — внутренняя ошибка сервера.
+func (h *ServiceHandlers) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := middleware.GetUserID(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	deleteUserDTO := DeleteUserDTO{}
+	err = json.Unmarshal(bytes, &deleteUserDTO)
+	if err != nil || deleteUserDTO.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.authService.DeleteAccount(ctx, userID, deleteUserDTO.Password, deleteUserDTO.TOTPCode)
+	if err != nil {
+		logger.Logger().Warn("authService.DeleteAccount", zap.Error(err))
+		var lockedErr *services.LoginLockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			writeTooManyRequests(w, lockedErr)
+		case errors.Is(err, services.ErrUserPasswordInvalid),
+			errors.Is(err, services.ErrTOTPCodeRequired),
+			errors.Is(err, services.ErrTOTPCodeInvalid):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/keyring"
+	"github.com/andreevym/gophermart/pkg/password"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func authRequest(t *testing.T, ts *httptest.Server, method, path, accessToken string, reqBody io.Reader) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	require.NoError(t, err)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, respBody
+}
+
+func TestTokenRefreshAndLogout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	passwordHash, err := password.Hash("password")
+	require.NoError(t, err)
+	mockUserRepository := mock.NewMockUserRepository(ctrl)
+	mockUserRepository.EXPECT().
+		GetUserByUsername(gomock.Any(), "user").
+		Return(&repository.User{ID: testUser, Username: "user", Password: passwordHash}, nil)
+	userService := services.NewUserService(mockUserRepository)
+
+	var refreshToken repository.RefreshToken
+	mockTokenRepository := mock.NewMockTokenRepository(ctrl)
+	mockTokenRepository.EXPECT().
+		CreateRefreshToken(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ interface{}, token repository.RefreshToken) error {
+			refreshToken = token
+			return nil
+		})
+
+	var session repository.Session
+	mockSessionRepository := mock.NewMockSessionRepository(ctrl)
+	mockSessionRepository.EXPECT().
+		CreateSession(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ interface{}, s repository.Session) error {
+			session = s
+			return nil
+		})
+
+	mockLoginFailureRepository := mock.NewMockLoginFailureRepository(ctrl)
+	mockLoginFailureRepository.EXPECT().GetLoginFailure(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).Times(2)
+	mockLoginFailureRepository.EXPECT().ResetLoginFailures(gomock.Any(), repository.LoginFailureScopeLogin, "user").Return(false, nil)
+
+	mockTOTPRepository := mock.NewMockTOTPRepository(ctrl)
+	mockTOTPRepository.EXPECT().GetTOTP(gomock.Any(), testUser).Return(nil, nil)
+
+	authService := newTestAuthService(t, userService, mockTokenRepository, mockSessionRepository)
+	authService.TOTPRepository = mockTOTPRepository
+	authService.LoginThrottle = services.NewLoginThrottle(mockLoginFailureRepository)
+	ts := newTestServer(t, authService, userService, nil, nil)
+
+	// login returns a pair of tokens
+	resp, body := authRequest(t, ts, http.MethodPost, "/api/user/login", "",
+		bytes.NewBufferString(`{"login":"user","password":"password"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	loginTokens := TokenDTO{}
+	require.NoError(t, json.Unmarshal(body, &loginTokens))
+	require.NotEmpty(t, loginTokens.AccessToken)
+	require.NotEmpty(t, loginTokens.RefreshToken)
+	require.Equal(t, "Bearer "+loginTokens.AccessToken, resp.Header.Get("Authorization"))
+	require.Equal(t, testUser, refreshToken.UserID)
+	require.NotEqual(t, loginTokens.RefreshToken, refreshToken.TokenHash, "refresh token must be stored hashed")
+	require.Equal(t, refreshToken.FamilyID, session.ID, "the session id is the refresh token family")
+	require.Equal(t, testUser, session.UserID)
+
+	// refresh rotates the refresh token
+	mockTokenRepository.EXPECT().
+		RotateRefreshToken(gomock.Any(), refreshToken.TokenHash, gomock.Any()).
+		Return(&refreshToken, nil)
+	mockSessionRepository.EXPECT().
+		TouchSession(gomock.Any(), session.ID, gomock.Any()).
+		Return(nil)
+	mockUserRepository.EXPECT().
+		GetUserByID(gomock.Any(), testUser).
+		Return(&repository.User{ID: testUser, Username: "user", Password: passwordHash}, nil)
+	resp, body = authRequest(t, ts, http.MethodPost, "/api/user/token/refresh", "",
+		bytes.NewBufferString(`{"refresh_token":"`+loginTokens.RefreshToken+`"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	refreshedTokens := TokenDTO{}
+	require.NoError(t, json.Unmarshal(body, &refreshedTokens))
+	require.NotEqual(t, loginTokens.RefreshToken, refreshedTokens.RefreshToken)
+
+	// the used refresh token is rejected
+	mockTokenRepository.EXPECT().
+		RotateRefreshToken(gomock.Any(), refreshToken.TokenHash, gomock.Any()).
+		Return(nil, repository.ErrRefreshTokenReused)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/user/token/refresh", "",
+		bytes.NewBufferString(`{"refresh_token":"`+loginTokens.RefreshToken+`"}`))
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/user/token/refresh", "", bytes.NewBufferString(`{}`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// logout revokes the access token and the session
+	mockTokenRepository.EXPECT().
+		IsAccessTokenRevoked(gomock.Any(), gomock.Any(), refreshToken.FamilyID).
+		Return(false, nil)
+	mockTokenRepository.EXPECT().
+		RevokeAccessToken(gomock.Any(), gomock.Any(), testUser, gomock.Any()).
+		Return(nil)
+	mockSessionRepository.EXPECT().
+		RevokeSession(gomock.Any(), testUser, session.ID).
+		Return(nil)
+	resp, _ = authRequest(t, ts, http.MethodPost, "/api/user/logout", refreshedTokens.AccessToken, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	mockTokenRepository.EXPECT().
+		IsAccessTokenRevoked(gomock.Any(), gomock.Any(), refreshToken.FamilyID).
+		Return(true, nil)
+	resp, _ = authRequest(t, ts, http.MethodGet, "/api/user/balance", refreshedTokens.AccessToken, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestLoginLockout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	passwordHash, err := password.Hash("password")
+	require.NoError(t, err)
+	mockUserRepository := mock.NewMockUserRepository(ctrl)
+	mockUserRepository.EXPECT().
+		GetUserByUsername(gomock.Any(), "user").
+		Return(&repository.User{ID: testUser, Username: "user", Password: passwordHash}, nil)
+	userService := services.NewUserService(mockUserRepository)
+
+	mockLoginFailureRepository := mock.NewMockLoginFailureRepository(ctrl)
+	authService := services.NewAuthService(userService, nil, nil, nil)
+	authService.LoginThrottle = services.NewLoginThrottle(mockLoginFailureRepository)
+	ts := newTestServer(t, authService, userService, nil, nil)
+
+	// the failure over the limit locks the login out
+	mockLoginFailureRepository.EXPECT().GetLoginFailure(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).Times(2)
+	mockLoginFailureRepository.EXPECT().
+		RecordLoginFailure(gomock.Any(), repository.LoginFailureScopeLogin, "user", services.DefaultLoginFailureWindow).
+		Return(&repository.LoginFailure{Failures: services.DefaultLoginLockoutPolicy.MaxFailures + 2}, nil)
+	mockLoginFailureRepository.EXPECT().
+		RecordLoginFailure(gomock.Any(), repository.LoginFailureScopeIP, "127.0.0.1", services.DefaultLoginFailureWindow).
+		Return(&repository.LoginFailure{Failures: 1}, nil)
+	var lockedUntil time.Time
+	mockLoginFailureRepository.EXPECT().
+		LockLogin(gomock.Any(), repository.LoginFailureScopeLogin, "user", gomock.Any()).
+		DoAndReturn(func(_ interface{}, _ string, _ string, until time.Time) error {
+			lockedUntil = until
+			return nil
+		})
+	statusCode, _, _ := testRequest(t, ts, http.MethodPost, "/api/user/login",
+		bytes.NewBufferString(`{"login":"user","password":"wrong"}`))
+	require.Equal(t, http.StatusConflict, statusCode)
+	require.WithinDuration(t, time.Now().Add(2*services.DefaultLoginLockoutPolicy.BaseDelay), lockedUntil, time.Second)
+
+	// a locked out login is rejected before the password is checked
+	lockedUntil = time.Now().Add(90 * time.Second)
+	mockLoginFailureRepository.EXPECT().
+		GetLoginFailure(gomock.Any(), repository.LoginFailureScopeLogin, "user").
+		Return(&repository.LoginFailure{Failures: 7, LockedUntil: &lockedUntil}, nil)
+	mockLoginFailureRepository.EXPECT().
+		GetLoginFailure(gomock.Any(), repository.LoginFailureScopeIP, "127.0.0.1").
+		Return(nil, nil)
+	resp, _ := authRequest(t, ts, http.MethodPost, "/api/user/login", "",
+		bytes.NewBufferString(`{"login":"user","password":"password"}`))
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "90", resp.Header.Get("Retry-After"))
+}
+
+func TestPostRegisterUserValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		createErr  error
+		createCall bool
+		statusCode int
+	}{
+		{
+			name:       "login is too short",
+			body:       `{"login":"ab","password":"password1"}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "login has forbidden characters",
+			body:       `{"login":"user name","password":"password1"}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "login is reserved for deleted users",
+			body:       `{"login":"deleted-1-abcd","password":"password1"}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "concurrent registration of the same login",
+			body:       `{"login":" User ","password":"password1"}`,
+			createErr:  postgres.ErrUserAlreadyExists,
+			createCall: true,
+			statusCode: http.StatusConflict,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockUserRepository := mock.NewMockUserRepository(ctrl)
+			if test.createCall {
+				// the login is normalized before the lookup and the insert
+				mockUserRepository.EXPECT().GetUserByUsername(gomock.Any(), "user").Return(nil, postgres.ErrUserNotFound)
+				mockUserRepository.EXPECT().
+					CreateUser(gomock.Any(), gomock.AssignableToTypeOf(repository.User{})).
+					DoAndReturn(func(_ interface{}, user repository.User) error {
+						require.Equal(t, "user", user.Username)
+						return test.createErr
+					})
+			}
+			userService := services.NewUserService(mockUserRepository)
+			authService := services.NewAuthService(userService, nil, nil, nil)
+			ts := newTestServer(t, authService, userService, nil, nil)
+
+			statusCode, _, _ := testRequest(t, ts, http.MethodPost, "/api/user/register", bytes.NewBufferString(test.body))
+			require.Equal(t, test.statusCode, statusCode)
+		})
+	}
+}
+
+func TestGetJWKSHandler(t *testing.T) {
+	keyRing, err := keyring.Generate()
+	require.NoError(t, err)
+	authService := services.NewAuthService(nil, nil, nil, keyRing)
+	ts := newTestServer(t, authService, nil, nil, nil)
+
+	statusCode, contentType, body := testRequest(t, ts, http.MethodGet, "/.well-known/jwks.json", nil)
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "application/json", contentType)
+
+	jwks := keyring.JWKS{}
+	require.NoError(t, json.Unmarshal([]byte(body), &jwks))
+	require.Len(t, jwks.Keys, 1)
+	require.Equal(t, keyRing.SigningKeyID(), jwks.Keys[0].KeyID)
+	require.Equal(t, "ES256", jwks.Keys[0].Algorithm)
+}
+
+func TestChangePasswordAndDeleteUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	passwordHash, err := password.Hash("password1")
+	require.NoError(t, err)
+	user := repository.User{ID: testUser, Username: "user", Password: passwordHash}
+	mockUserRepository := mock.NewMockUserRepository(ctrl)
+	mockUserRepository.EXPECT().GetUserByID(gomock.Any(), testUser).DoAndReturn(
+		func(_ interface{}, _ int64) (*repository.User, error) {
+			u := user
+			return &u, nil
+		}).AnyTimes()
+	mockUserRepository.EXPECT().GetUserByUsername(gomock.Any(), "user").DoAndReturn(
+		func(_ interface{}, _ string) (*repository.User, error) {
+			u := user
+			return &u, nil
+		}).AnyTimes()
+	userService := services.NewUserService(mockUserRepository)
+
+	mockTokenRepository := mock.NewMockTokenRepository(ctrl)
+	mockTokenRepository.EXPECT().IsAccessTokenRevoked(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil).AnyTimes()
+	mockSessionRepository := mock.NewMockSessionRepository(ctrl)
+	mockLoginFailureRepository := mock.NewMockLoginFailureRepository(ctrl)
+	mockLoginFailureRepository.EXPECT().GetLoginFailure(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	mockTOTPRepository := mock.NewMockTOTPRepository(ctrl)
+	mockTOTPRepository.EXPECT().GetTOTP(gomock.Any(), testUser).Return(nil, nil).AnyTimes()
+
+	authService := newTestAuthService(t, userService, mockTokenRepository, mockSessionRepository)
+	authService.TOTPRepository = mockTOTPRepository
+	authService.LoginThrottle = services.NewLoginThrottle(mockLoginFailureRepository)
+	ts := newTestServer(t, authService, userService, nil, nil)
+
+	accessToken, err := authService.GenerateToken(testUser, "current", nil)
+	require.NoError(t, err)
+
+	// a wrong old password is a failed login attempt
+	mockLoginFailureRepository.EXPECT().
+		RecordLoginFailure(gomock.Any(), repository.LoginFailureScopeLogin, "user", gomock.Any()).
+		Return(&repository.LoginFailure{Failures: 1}, nil)
+	resp, _ := authRequest(t, ts, http.MethodPut, "/api/user/password", accessToken,
+		bytes.NewBufferString(`{"old_password":"wrong","new_password":"password2"}`))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, body := authRequest(t, ts, http.MethodPut, "/api/user/password", accessToken,
+		bytes.NewBufferString(`{"old_password":"password1","new_password":"short1"}`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, string(body), "at least 8 characters")
+
+	// the password is changed and the other sessions are revoked
+	mockUserRepository.EXPECT().
+		UpdateUser(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ interface{}, updated repository.User) error {
+			user = updated
+			return nil
+		})
+	mockSessionRepository.EXPECT().RevokeOtherSessions(gomock.Any(), testUser, "current").Return(int64(2), nil)
+	resp, _ = authRequest(t, ts, http.MethodPut, "/api/user/password", accessToken,
+		bytes.NewBufferString(`{"old_password":"password1","new_password":"password2"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	match, _, err := password.Verify("password2", user.Password)
+	require.NoError(t, err)
+	require.True(t, match)
+
+	// the deleted user is anonymized, not removed
+	mockUserRepository.EXPECT().
+		UpdateUser(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ interface{}, updated repository.User) error {
+			user = updated
+			return nil
+		})
+	mockSessionRepository.EXPECT().RevokeOtherSessions(gomock.Any(), testUser, "").Return(int64(1), nil)
+	resp, _ = authRequest(t, ts, http.MethodDelete, "/api/user", accessToken, bytes.NewBufferString(`{"password":"password2"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, testUser, user.ID)
+	require.NotEqual(t, "user", user.Username)
+	require.NotNil(t, user.Deleted)
+
+	// the old login doesn't work anymore
+	mockLoginFailureRepository.EXPECT().
+		RecordLoginFailure(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&repository.LoginFailure{Failures: 1}, nil).
+		Times(2)
+	statusCode, _, _ := testRequest(t, ts, http.MethodPost, "/api/user/login",
+		bytes.NewBufferString(`{"login":"user","password":"password2"}`))
+	require.Equal(t, http.StatusConflict, statusCode)
+}
@@ -16,6 +16,7 @@ type ContextKey int
 
 const (
 	UserIDContextKey ContextKey = iota
+	TokenClaimsContextKey
 )
 
 var ErrAuthUnauthorized = errors.New("unauthorized")
@@ -33,6 +34,9 @@ func NewAuthMiddleware(authService *services.AuthService) *AuthMiddleware {
 	allowUnauthorizedURI["/api/ping"] = struct{}{}
 	allowUnauthorizedURI["/api/user/register"] = struct{}{}
 	allowUnauthorizedURI["/api/user/login"] = struct{}{}
+	allowUnauthorizedURI["/api/user/login/mfa"] = struct{}{}
+	allowUnauthorizedURI["/api/user/token/refresh"] = struct{}{}
+	allowUnauthorizedURI["/.well-known/jwks.json"] = struct{}{}
 	return &AuthMiddleware{authService, allowUnauthorizedURI}
 }
 
@@ -53,16 +57,17 @@ func (am *AuthMiddleware) WithAuthentication(next http.Handler) http.Handler {
 
 		tokenString := authHeader[len("Bearer "):]
 
-		// Validate the token and extract user ID
-		userID, err := am.authService.ValidateToken(tokenString)
+		// Validate the token, it must not be expired or revoked, and extract user ID
+		claims, err := am.authService.ValidateToken(r.Context(), tokenString)
 		if err != nil {
 			logger.Logger().Warn("authService.ValidateToken", zap.Error(err))
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		// Set the user ID from the token in the request context
-		ctx := setUserID(r, userID)
+		// Set the user ID and the token claims in the request context
+		ctx := setUserID(r, claims.UserID)
+		ctx = context.WithValue(ctx, TokenClaimsContextKey, *claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -78,3 +83,12 @@ func GetUserID(ctx context.Context) (int64, error) {
 	}
 	return userID.(int64), nil
 }
+
+// GetTokenClaims returns the claims of the access token the request is authenticated with.
+func GetTokenClaims(ctx context.Context) (services.TokenClaims, error) {
+	claims, ok := ctx.Value(TokenClaimsContextKey).(services.TokenClaims)
+	if !ok {
+		return services.TokenClaims{}, ErrAuthUnauthorized
+	}
+	return claims, nil
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// RequireRole allows requests authenticated with an access token of a user with any of the roles,
+// it's used for route groups behind AuthMiddleware.WithAuthentication.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := GetTokenClaims(r.Context())
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !claims.HasRole(roles...) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
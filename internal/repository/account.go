@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/andreevym/gophermart/pkg/money"
+)
+
+// Account is a materialized balance of a user, it is maintained in the same
+// database transaction as every ledger write. Balances of the system accounts
+// are computed from the ledger instead.
+type Account struct {
+	UserID    int64        `json:"userId"`
+	Current   money.Amount `json:"current"`
+	Withdrawn money.Amount `json:"withdrawn"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+	// Held is the part of Current reserved by active holds, it's computed from the holds
+	Held money.Amount `json:"held"`
+}
+
+// Available returns the points the user can withdraw or hold
+func (a Account) Available() money.Amount {
+	return a.Current - a.Held
+}
+
+// AccountDrift describes a difference between a materialized account and its ledger.
+type AccountDrift struct {
+	UserID            int64        `json:"userId"`
+	Current           money.Amount `json:"current"`
+	ExpectedCurrent   money.Amount `json:"expectedCurrent"`
+	Withdrawn         money.Amount `json:"withdrawn"`
+	ExpectedWithdrawn money.Amount `json:"expectedWithdrawn"`
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/andreevym/gophermart/pkg/money"
+)
+
+// Adjustment is a manual correction of a user balance by an operator, it's recorded with
+// an adjustment transaction between the user and the adjustment system account.
+type Adjustment struct {
+	// TransactionID is the ledger entry of the adjustment, filled by database while insert
+	TransactionID int64 `json:"transactionId"`
+	UserID        int64 `json:"userId"`
+	// Amount is credited to the user if it's positive and debited if it's negative
+	Amount money.Amount `json:"amount"`
+	// AdminUserID is the operator who made the adjustment
+	AdminUserID int64  `json:"adminUserId"`
+	Reason      string `json:"reason"`
+	// Ticket references the support request the adjustment is made for
+	Ticket string `json:"ticket"`
+	// Created is the combined date and time, filled by database while insert
+	Created time.Time `json:"created,omitempty"`
+}
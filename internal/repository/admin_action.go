@@ -0,0 +1,25 @@
+package repository
+
+import "time"
+
+const (
+	// AdminActionOrderRequeue sends an INVALID or a pending order back to processing
+	AdminActionOrderRequeue = "order.requeue"
+	// AdminActionOrderRecheck queries the accrual system again for a PROCESSED order
+	AdminActionOrderRecheck = "order.recheck"
+	// AdminActionOrdersRequeue sends all orders in a status uploaded in a time range back to processing
+	AdminActionOrdersRequeue = "orders.requeue"
+)
+
+// AdminAction is an entry of the admin audit log, it's recorded with the change it describes.
+type AdminAction struct {
+	ID          int64  `json:"id"`
+	AdminUserID int64  `json:"adminUserId"`
+	Action      string `json:"action"`
+	// Target is the object of the action, like an order number or a filter of orders
+	Target string `json:"target"`
+	// Details describe the result of the action, like the numbers of the affected orders
+	Details string `json:"details"`
+	// Created is the combined date and time, filled by database while insert
+	Created time.Time `json:"created,omitempty"`
+}
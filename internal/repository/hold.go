@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/andreevym/gophermart/pkg/money"
+)
+
+const (
+	// HoldStatusHeld the points are reserved, they can't be withdrawn or held again
+	HoldStatusHeld = "HELD"
+	// HoldStatusCaptured the points are withdrawn with the withdraw transaction of the hold
+	HoldStatusCaptured = "CAPTURED"
+	// HoldStatusReleased the reservation is cancelled, the points are available again
+	HoldStatusReleased = "RELEASED"
+	// HoldStatusExpired the hold was neither captured nor released in time, the points are available again
+	HoldStatusExpired = "EXPIRED"
+)
+
+var (
+	// ErrHoldNotFound is returned when the user has no hold with the id.
+	ErrHoldNotFound = errors.New("hold not found")
+	// ErrHoldNotActive is returned when the hold is already captured, released or expired.
+	ErrHoldNotActive = errors.New("hold is not active")
+)
+
+// Hold reserves points of a user in payment for an order until the payment completes,
+// the held points are captured as a withdrawal or released.
+type Hold struct {
+	// ID is filled by database while insert
+	ID          int64        `json:"id"`
+	UserID      int64        `json:"userId"`
+	Amount      money.Amount `json:"amount"`
+	OrderNumber string       `json:"order_number"`
+	Status      string       `json:"status"`
+	// TransactionID is the withdraw transaction of a captured hold
+	TransactionID int64 `json:"transactionId,omitempty"`
+	// Created is the combined date and time, filled by database while insert
+	Created time.Time `json:"created,omitempty"`
+	// Expires is the time the hold is expired unless it's captured or released before
+	Expires time.Time `json:"expires"`
+}
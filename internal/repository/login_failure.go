@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// LoginFailureScopeLogin counts failed attempts to log in as one user
+	LoginFailureScopeLogin = "login"
+	// LoginFailureScopeIP counts failed attempts from one client address to log in as any user
+	LoginFailureScopeIP = "ip"
+)
+
+// LoginFailure is the number of failed login attempts in a row for a login or a client address.
+type LoginFailure struct {
+	Scope         string
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	// LockedUntil is set while login attempts for the key are rejected
+	LockedUntil *time.Time
+}
+
+// LoginFailureRepository stores failed login attempts.
+//
+//go:generate mockgen -source=login_failure.go -destination=./mock/login_failure.go -package=mock
+type LoginFailureRepository interface {
+	// GetLoginFailure returns nil if no failed attempts are recorded for the key.
+	GetLoginFailure(ctx context.Context, scope string, key string) (*LoginFailure, error)
+	// RecordLoginFailure increments the failed attempts of the key and returns the updated record,
+	// the attempts are counted from one again if the previous one failed longer than window ago.
+	RecordLoginFailure(ctx context.Context, scope string, key string, window time.Duration) (*LoginFailure, error)
+	// LockLogin rejects login attempts for the key until the time.
+	LockLogin(ctx context.Context, scope string, key string, until time.Time) error
+	// ResetLoginFailures forgets the failed attempts and the lock of the key, false is returned if there was nothing to forget.
+	ResetLoginFailures(ctx context.Context, scope string, key string) (bool, error)
+}
@@ -7,6 +7,7 @@ package mock
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	repository "github.com/andreevym/gophermart/internal/repository"
 	gomock "github.com/golang/mock/gomock"
@@ -35,6 +36,21 @@ func (m *MockOrderRepository) EXPECT() *MockOrderRepositoryMockRecorder {
 	return m.recorder
 }
 
+// ClaimOrders mocks base method.
+func (m *MockOrderRepository) ClaimOrders(ctx context.Context, owner string, statuses []string, limit int, lease time.Duration) ([]repository.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimOrders", ctx, owner, statuses, limit, lease)
+	ret0, _ := ret[0].([]repository.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimOrders indicates an expected call of ClaimOrders.
+func (mr *MockOrderRepositoryMockRecorder) ClaimOrders(ctx, owner, statuses, limit, lease interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimOrders", reflect.TypeOf((*MockOrderRepository)(nil).ClaimOrders), ctx, owner, statuses, limit, lease)
+}
+
 // CreateOrder mocks base method.
 func (m *MockOrderRepository) CreateOrder(ctx context.Context, order repository.Order) error {
 	m.ctrl.T.Helper()
@@ -108,6 +124,49 @@ func (mr *MockOrderRepositoryMockRecorder) GetOrdersByUserID(ctx, userID interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersByUserID", reflect.TypeOf((*MockOrderRepository)(nil).GetOrdersByUserID), ctx, userID)
 }
 
+// RequeueOrders mocks base method.
+func (m *MockOrderRepository) RequeueOrders(ctx context.Context, filter repository.OrderFilter, status string, action repository.AdminAction) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequeueOrders", ctx, filter, status, action)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RequeueOrders indicates an expected call of RequeueOrders.
+func (mr *MockOrderRepositoryMockRecorder) RequeueOrders(ctx, filter, status, action interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequeueOrders", reflect.TypeOf((*MockOrderRepository)(nil).RequeueOrders), ctx, filter, status, action)
+}
+
+// ScheduleOrderPoll mocks base method.
+func (m *MockOrderRepository) ScheduleOrderPoll(ctx context.Context, orderNumber, owner, status string, delay time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ScheduleOrderPoll", ctx, orderNumber, owner, status, delay)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ScheduleOrderPoll indicates an expected call of ScheduleOrderPoll.
+func (mr *MockOrderRepositoryMockRecorder) ScheduleOrderPoll(ctx, orderNumber, owner, status, delay interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ScheduleOrderPoll", reflect.TypeOf((*MockOrderRepository)(nil).ScheduleOrderPoll), ctx, orderNumber, owner, status, delay)
+}
+
+// ScheduleOrderRetry mocks base method.
+func (m *MockOrderRepository) ScheduleOrderRetry(ctx context.Context, orderNumber, owner string, delay time.Duration, lastError string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ScheduleOrderRetry", ctx, orderNumber, owner, delay, lastError)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ScheduleOrderRetry indicates an expected call of ScheduleOrderRetry.
+func (mr *MockOrderRepositoryMockRecorder) ScheduleOrderRetry(ctx, orderNumber, owner, delay, lastError interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ScheduleOrderRetry", reflect.TypeOf((*MockOrderRepository)(nil).ScheduleOrderRetry), ctx, orderNumber, owner, delay, lastError)
+}
+
 // UpdateOrder mocks base method.
 func (m *MockOrderRepository) UpdateOrder(ctx context.Context, order repository.Order) error {
 	m.ctrl.T.Helper()
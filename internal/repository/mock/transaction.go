@@ -9,6 +9,7 @@ import (
 	reflect "reflect"
 
 	repository "github.com/andreevym/gophermart/internal/repository"
+	money "github.com/andreevym/gophermart/pkg/money"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -36,17 +37,47 @@ func (m *MockTransactionRepository) EXPECT() *MockTransactionRepositoryMockRecor
 }
 
 // AccrualAmount mocks base method.
-func (m *MockTransactionRepository) AccrualAmount(ctx context.Context, userID int64, orderNumber string, accrual float32, orderStatus string) error {
+func (m *MockTransactionRepository) AccrualAmount(ctx context.Context, userID int64, orderNumber, owner string, accrual money.Amount, orderStatus string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AccrualAmount", ctx, userID, orderNumber, accrual, orderStatus)
+	ret := m.ctrl.Call(m, "AccrualAmount", ctx, userID, orderNumber, owner, accrual, orderStatus)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AccrualAmount indicates an expected call of AccrualAmount.
-func (mr *MockTransactionRepositoryMockRecorder) AccrualAmount(ctx, userID, orderNumber, accrual, orderStatus interface{}) *gomock.Call {
+func (mr *MockTransactionRepositoryMockRecorder) AccrualAmount(ctx, userID, orderNumber, owner, accrual, orderStatus interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AccrualAmount", reflect.TypeOf((*MockTransactionRepository)(nil).AccrualAmount), ctx, userID, orderNumber, accrual, orderStatus)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AccrualAmount", reflect.TypeOf((*MockTransactionRepository)(nil).AccrualAmount), ctx, userID, orderNumber, owner, accrual, orderStatus)
+}
+
+// Adjust mocks base method.
+func (m *MockTransactionRepository) Adjust(ctx context.Context, adjustment repository.Adjustment) (*repository.Adjustment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Adjust", ctx, adjustment)
+	ret0, _ := ret[0].(*repository.Adjustment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Adjust indicates an expected call of Adjust.
+func (mr *MockTransactionRepositoryMockRecorder) Adjust(ctx, adjustment interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Adjust", reflect.TypeOf((*MockTransactionRepository)(nil).Adjust), ctx, adjustment)
+}
+
+// CaptureHold mocks base method.
+func (m *MockTransactionRepository) CaptureHold(ctx context.Context, userID, holdID int64) (*repository.Hold, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CaptureHold", ctx, userID, holdID)
+	ret0, _ := ret[0].(*repository.Hold)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CaptureHold indicates an expected call of CaptureHold.
+func (mr *MockTransactionRepositoryMockRecorder) CaptureHold(ctx, userID, holdID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CaptureHold", reflect.TypeOf((*MockTransactionRepository)(nil).CaptureHold), ctx, userID, holdID)
 }
 
 // CreateTransaction mocks base method.
@@ -78,6 +109,51 @@ func (mr *MockTransactionRepositoryMockRecorder) DeleteTransaction(ctx, transact
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTransaction", reflect.TypeOf((*MockTransactionRepository)(nil).DeleteTransaction), ctx, transactionID)
 }
 
+// ExpireHolds mocks base method.
+func (m *MockTransactionRepository) ExpireHolds(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExpireHolds", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExpireHolds indicates an expected call of ExpireHolds.
+func (mr *MockTransactionRepositoryMockRecorder) ExpireHolds(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpireHolds", reflect.TypeOf((*MockTransactionRepository)(nil).ExpireHolds), ctx)
+}
+
+// GetAccount mocks base method.
+func (m *MockTransactionRepository) GetAccount(ctx context.Context, userID int64) (*repository.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAccount", ctx, userID)
+	ret0, _ := ret[0].(*repository.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAccount indicates an expected call of GetAccount.
+func (mr *MockTransactionRepositoryMockRecorder) GetAccount(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccount", reflect.TypeOf((*MockTransactionRepository)(nil).GetAccount), ctx, userID)
+}
+
+// GetAdjustmentsByUserID mocks base method.
+func (m *MockTransactionRepository) GetAdjustmentsByUserID(ctx context.Context, userID int64) ([]repository.Adjustment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAdjustmentsByUserID", ctx, userID)
+	ret0, _ := ret[0].([]repository.Adjustment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAdjustmentsByUserID indicates an expected call of GetAdjustmentsByUserID.
+func (mr *MockTransactionRepositoryMockRecorder) GetAdjustmentsByUserID(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAdjustmentsByUserID", reflect.TypeOf((*MockTransactionRepository)(nil).GetAdjustmentsByUserID), ctx, userID)
+}
+
 // GetTransactionByID mocks base method.
 func (m *MockTransactionRepository) GetTransactionByID(ctx context.Context, transactionID int64) (*repository.Transaction, error) {
 	m.ctrl.T.Helper()
@@ -123,6 +199,96 @@ func (mr *MockTransactionRepositoryMockRecorder) GetTransactionsByUserIDAndOpera
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransactionsByUserIDAndOperationType", reflect.TypeOf((*MockTransactionRepository)(nil).GetTransactionsByUserIDAndOperationType), ctx, userID, operationType)
 }
 
+// GetWithdrawalsByOrderNumber mocks base method.
+func (m *MockTransactionRepository) GetWithdrawalsByOrderNumber(ctx context.Context, orderNumber string) ([]repository.Withdrawal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithdrawalsByOrderNumber", ctx, orderNumber)
+	ret0, _ := ret[0].([]repository.Withdrawal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWithdrawalsByOrderNumber indicates an expected call of GetWithdrawalsByOrderNumber.
+func (mr *MockTransactionRepositoryMockRecorder) GetWithdrawalsByOrderNumber(ctx, orderNumber interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawalsByOrderNumber", reflect.TypeOf((*MockTransactionRepository)(nil).GetWithdrawalsByOrderNumber), ctx, orderNumber)
+}
+
+// GetWithdrawalsByUserID mocks base method.
+func (m *MockTransactionRepository) GetWithdrawalsByUserID(ctx context.Context, userID int64) ([]repository.Withdrawal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithdrawalsByUserID", ctx, userID)
+	ret0, _ := ret[0].([]repository.Withdrawal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWithdrawalsByUserID indicates an expected call of GetWithdrawalsByUserID.
+func (mr *MockTransactionRepositoryMockRecorder) GetWithdrawalsByUserID(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawalsByUserID", reflect.TypeOf((*MockTransactionRepository)(nil).GetWithdrawalsByUserID), ctx, userID)
+}
+
+// PlaceHold mocks base method.
+func (m *MockTransactionRepository) PlaceHold(ctx context.Context, hold repository.Hold) (*repository.Hold, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PlaceHold", ctx, hold)
+	ret0, _ := ret[0].(*repository.Hold)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PlaceHold indicates an expected call of PlaceHold.
+func (mr *MockTransactionRepositoryMockRecorder) PlaceHold(ctx, hold interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PlaceHold", reflect.TypeOf((*MockTransactionRepository)(nil).PlaceHold), ctx, hold)
+}
+
+// ReconcileAccounts mocks base method.
+func (m *MockTransactionRepository) ReconcileAccounts(ctx context.Context, fix bool) ([]repository.AccountDrift, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcileAccounts", ctx, fix)
+	ret0, _ := ret[0].([]repository.AccountDrift)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReconcileAccounts indicates an expected call of ReconcileAccounts.
+func (mr *MockTransactionRepositoryMockRecorder) ReconcileAccounts(ctx, fix interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcileAccounts", reflect.TypeOf((*MockTransactionRepository)(nil).ReconcileAccounts), ctx, fix)
+}
+
+// ReleaseHold mocks base method.
+func (m *MockTransactionRepository) ReleaseHold(ctx context.Context, userID, holdID int64) (*repository.Hold, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseHold", ctx, userID, holdID)
+	ret0, _ := ret[0].(*repository.Hold)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReleaseHold indicates an expected call of ReleaseHold.
+func (mr *MockTransactionRepositoryMockRecorder) ReleaseHold(ctx, userID, holdID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseHold", reflect.TypeOf((*MockTransactionRepository)(nil).ReleaseHold), ctx, userID, holdID)
+}
+
+// ReverseWithdrawal mocks base method.
+func (m *MockTransactionRepository) ReverseWithdrawal(ctx context.Context, reversal repository.Reversal) (*repository.Reversal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReverseWithdrawal", ctx, reversal)
+	ret0, _ := ret[0].(*repository.Reversal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReverseWithdrawal indicates an expected call of ReverseWithdrawal.
+func (mr *MockTransactionRepositoryMockRecorder) ReverseWithdrawal(ctx, reversal interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReverseWithdrawal", reflect.TypeOf((*MockTransactionRepository)(nil).ReverseWithdrawal), ctx, reversal)
+}
+
 // UpdateTransaction mocks base method.
 func (m *MockTransactionRepository) UpdateTransaction(ctx context.Context, transaction repository.Transaction) error {
 	m.ctrl.T.Helper()
@@ -136,3 +302,18 @@ func (mr *MockTransactionRepositoryMockRecorder) UpdateTransaction(ctx, transact
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTransaction", reflect.TypeOf((*MockTransactionRepository)(nil).UpdateTransaction), ctx, transaction)
 }
+
+// Withdraw mocks base method.
+func (m *MockTransactionRepository) Withdraw(ctx context.Context, userID int64, orderNumber string, amount money.Amount) (*repository.Transaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Withdraw", ctx, userID, orderNumber, amount)
+	ret0, _ := ret[0].(*repository.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Withdraw indicates an expected call of Withdraw.
+func (mr *MockTransactionRepositoryMockRecorder) Withdraw(ctx, userID, orderNumber, amount interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Withdraw", reflect.TypeOf((*MockTransactionRepository)(nil).Withdraw), ctx, userID, orderNumber, amount)
+}
@@ -93,6 +93,20 @@ func (mr *MockUserRepositoryMockRecorder) GetUserByUsername(ctx, username interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByUsername", reflect.TypeOf((*MockUserRepository)(nil).GetUserByUsername), ctx, username)
 }
 
+// SetUserRoles mocks base method.
+func (m *MockUserRepository) SetUserRoles(ctx context.Context, userID int64, roles []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUserRoles", ctx, userID, roles)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUserRoles indicates an expected call of SetUserRoles.
+func (mr *MockUserRepositoryMockRecorder) SetUserRoles(ctx, userID, roles interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserRoles", reflect.TypeOf((*MockUserRepository)(nil).SetUserRoles), ctx, userID, roles)
+}
+
 // UpdateUser mocks base method.
 func (m *MockUserRepository) UpdateUser(ctx context.Context, user repository.User) error {
 	m.ctrl.T.Helper()
@@ -2,16 +2,46 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
+
+	"github.com/andreevym/gophermart/pkg/money"
 )
 
+// ErrOrderLeaseLost is returned when a result of an order is stored by an instance which doesn't hold
+// the lease of the order anymore, the order may be processed by another instance already.
+var ErrOrderLeaseLost = errors.New("order lease is lost")
+
 // Order represents an order entity in the application.
 type Order struct {
-	Number     string    `json:"number"`
-	UserID     int64     `json:"userId"`
-	Status     string    `json:"status"`
-	Accrual    float32   `json:"accrual"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	Number     string       `json:"number"`
+	UserID     int64        `json:"userId"`
+	Status     string       `json:"status"`
+	Accrual    money.Amount `json:"accrual"`
+	UploadedAt time.Time    `json:"uploaded_at"`
+
+	// Attempts is the number of failed processing attempts in a row
+	Attempts int `json:"attempts"`
+	// NextAttemptAt is the time the order becomes due for processing
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	// LastError is the error of the last failed processing attempt
+	LastError string `json:"last_error,omitempty"`
+	// LeaseOwner is the instance processing the order, empty if the order is not claimed
+	LeaseOwner string `json:"lease_owner,omitempty"`
+	// LeaseExpiresAt is the time another instance may claim the order again
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	// RequeuedAt is the last time an operator sent the order back to processing
+	RequeuedAt *time.Time `json:"requeued_at,omitempty"`
+}
+
+// OrderFilter selects orders by the number or by the status and the upload time,
+// zero fields don't restrict the selection.
+type OrderFilter struct {
+	Number string
+	Status string
+	// UploadedFrom and UploadedTo select orders uploaded in [UploadedFrom, UploadedTo)
+	UploadedFrom time.Time
+	UploadedTo   time.Time
 }
 
 // OrderRepository represents the interface for order repository operations.
@@ -25,4 +55,17 @@ type OrderRepository interface {
 	GetOrderByNumber(ctx context.Context, number string) (*Order, error)
 	GetOrdersByUserID(ctx context.Context, userID int64) ([]Order, error)
 	GetOrdersByStatus(ctx context.Context, status string) ([]Order, error)
+	// ClaimOrders leases at most limit due orders with one of statuses to owner for lease duration,
+	// orders leased by other instances are skipped until their lease expires
+	ClaimOrders(ctx context.Context, owner string, statuses []string, limit int, lease time.Duration) ([]Order, error)
+	// ScheduleOrderRetry increments attempts of the order and postpones its next attempt by delay, the lease of the order is released.
+	// ErrOrderLeaseLost is returned if the order is not leased to owner.
+	ScheduleOrderRetry(ctx context.Context, orderNumber string, owner string, delay time.Duration, lastError string) error
+	// ScheduleOrderPoll sets a non-final status of the order, resets its failed attempts and postpones the next poll by delay,
+	// the lease of the order is released. ErrOrderLeaseLost is returned if the order is not leased to owner.
+	ScheduleOrderPoll(ctx context.Context, orderNumber string, owner string, status string, delay time.Duration) error
+	// RequeueOrders sets status of the orders selected by filter, resets their failed attempts, makes them due now
+	// and releases their leases, the action with the numbers of the orders is recorded to the admin audit log
+	// in the same database transaction. The numbers of the requeued orders are returned.
+	RequeueOrders(ctx context.Context, filter OrderFilter, status string, action AdminAction) ([]string, error)
 }
@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx"
+	pgxv4 "github.com/jackc/pgx/v4"
+)
+
+// systemAccounts are the other side of withdrawals, accruals and adjustments. Their balances aren't materialized:
+// every ledger write would lock the same row, so writes of all users would be serialized on it,
+// the balance is computed from the ledger on demand instead
+var systemAccounts = []int64{WithdrawUserID, AccrualUserID, AdjustmentUserID}
+
+func isSystemAccount(userID int64) bool {
+	for _, systemUserID := range systemAccounts {
+		if userID == systemUserID {
+			return true
+		}
+	}
+	return false
+}
+
+// insertTransaction inserts a ledger entry and applies it to the accounts of both sides,
+// it must be called inside a database transaction so the ledger and accounts never diverge
+func insertTransaction(ctx context.Context, tx pgxv4.Tx, transaction repository.Transaction) (*repository.Transaction, error) {
+	sql := `INSERT INTO transactions (from_user_id, to_user_id, amount, order_number, operation_type)
+		VALUES ($1, $2, $3, $4, $5) RETURNING transaction_id, created_at`
+	err := tx.QueryRow(ctx, sql, transaction.FromUserID, transaction.ToUserID, numericFromAmount(transaction.Amount),
+		transaction.OrderNumber, transaction.OperationType).Scan(&transaction.TransactionID, &transaction.Created)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrTransactionAlreadyExists
+		}
+		return nil, fmt.Errorf("failed to create transaction: %v", err)
+	}
+
+	err = applyTransaction(ctx, tx, transaction, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transaction, nil
+}
+
+// applyTransaction adds (sign = 1) or removes (sign = -1) the transaction effect on the accounts
+func applyTransaction(ctx context.Context, tx pgxv4.Tx, transaction repository.Transaction, sign money.Amount) error {
+	amount := transaction.Amount * sign
+
+	// a reversal takes the returned points out of the withdrawn sum of the user
+	var withdrawn, returned money.Amount
+	switch transaction.OperationType {
+	case repository.WithdrawOperationType:
+		withdrawn = amount
+	case repository.ReversalOperationType:
+		returned = -amount
+	}
+
+	err := addToAccount(ctx, tx, transaction.FromUserID, -amount, withdrawn)
+	if err != nil {
+		return err
+	}
+
+	return addToAccount(ctx, tx, transaction.ToUserID, amount, returned)
+}
+
+func addToAccount(ctx context.Context, tx pgxv4.Tx, userID int64, current money.Amount, withdrawn money.Amount) error {
+	if isSystemAccount(userID) {
+		return nil
+	}
+
+	sql := `INSERT INTO accounts (user_id, current, withdrawn) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			current = accounts.current + EXCLUDED.current,
+			withdrawn = accounts.withdrawn + EXCLUDED.withdrawn,
+			updated_at = CURRENT_TIMESTAMP`
+	_, err := tx.Exec(ctx, sql, userID, numericFromAmount(current), numericFromAmount(withdrawn))
+	if err != nil {
+		return fmt.Errorf("failed to update account of user %d: %v", userID, err)
+	}
+
+	return nil
+}
+
+// lockAccount locks the account row of the user until the end of the database transaction
+// and returns the locked account, the row is created if the user has no account yet
+func lockAccount(ctx context.Context, tx pgxv4.Tx, userID int64) (*repository.Account, error) {
+	insertSQL := `INSERT INTO accounts (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING`
+	_, err := tx.Exec(ctx, insertSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create account of user %d: %v", userID, err)
+	}
+
+	sql := `SELECT current, withdrawn, updated_at FROM accounts WHERE user_id = $1 FOR UPDATE`
+	account, err := scanAccount(tx.QueryRow(ctx, sql, userID), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// holds of the user are placed with the account locked, so the sum doesn't change until commit
+	account.Held, err = heldAmount(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// rowQuerier is implemented by both the pool and a database transaction
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgxv4.Row
+}
+
+// heldAmount returns the points of the user reserved by holds which are not expired
+func heldAmount(ctx context.Context, q rowQuerier, userID int64) (money.Amount, error) {
+	sql := `SELECT COALESCE(SUM(amount), 0) FROM holds WHERE user_id = $1 AND status = $2 AND expires_at > CURRENT_TIMESTAMP`
+	var held pgtype.Numeric
+	err := q.QueryRow(ctx, sql, userID, repository.HoldStatusHeld).Scan(&held)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get held amount of user %d: %v", userID, err)
+	}
+
+	amount, err := amountFromNumeric(held)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert held amount: %v", err)
+	}
+	return amount, nil
+}
+
+func scanAccount(row pgxv4.Row, userID int64) (*repository.Account, error) {
+	var current, withdrawn pgtype.Numeric
+	account := repository.Account{UserID: userID}
+	err := row.Scan(&current, &withdrawn, &account.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	account.Current, err = amountFromNumeric(current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert account current: %v", err)
+	}
+	account.Withdrawn, err = amountFromNumeric(withdrawn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert account withdrawn: %v", err)
+	}
+
+	return &account, nil
+}
+
+func (r *TransactionRepository) GetAccount(ctx context.Context, userID int64) (*repository.Account, error) {
+	if isSystemAccount(userID) {
+		return r.getSystemAccount(ctx, userID)
+	}
+
+	sql := `SELECT current, withdrawn, updated_at FROM accounts WHERE user_id = $1`
+	account, err := scanAccount(r.db.QueryRow(ctx, sql, userID), userID)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return &repository.Account{UserID: userID}, nil
+		}
+		return nil, fmt.Errorf("failed to get account of user %d: %v", userID, err)
+	}
+
+	account.Held, err = heldAmount(ctx, r.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// getSystemAccount sums the ledger entries of the system account
+func (r *TransactionRepository) getSystemAccount(ctx context.Context, userID int64) (*repository.Account, error) {
+	sql := `SELECT COALESCE(SUM(CASE WHEN to_user_id = $1 THEN amount ELSE -amount END), 0),
+			COALESCE(SUM(CASE
+				WHEN from_user_id = $1 AND operation_type = $2 THEN amount
+				WHEN to_user_id = $1 AND operation_type = $3 THEN -amount
+				ELSE 0 END), 0),
+			COALESCE(MAX(created_at), CURRENT_TIMESTAMP)
+		FROM transactions WHERE from_user_id = $1 OR to_user_id = $1`
+	account, err := scanAccount(r.db.QueryRow(ctx, sql, userID, repository.WithdrawOperationType, repository.ReversalOperationType), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account of system user %d: %v", userID, err)
+	}
+
+	return account, nil
+}
+
+// ReconcileAccounts compares accounts with the balances recomputed from the whole ledger,
+// system accounts are skipped, they have no materialized balance.
+// Both are read from one snapshot, in fix mode accounts are locked against concurrent
+// ledger writes until drifted rows are overwritten.
+func (r *TransactionRepository) ReconcileAccounts(ctx context.Context, fix bool) ([]repository.AccountDrift, error) {
+	tx, err := r.db.BeginTx(ctx, pgxv4.TxOptions{IsoLevel: pgxv4.RepeatableRead})
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if fix {
+		_, err = tx.Exec(ctx, `LOCK TABLE accounts IN SHARE ROW EXCLUSIVE MODE`)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock accounts: %v", err)
+		}
+	}
+
+	sql := `WITH expected AS (
+			SELECT user_id, SUM(current) AS current, SUM(withdrawn) AS withdrawn
+			FROM (
+				SELECT to_user_id AS user_id, amount AS current, CASE WHEN operation_type = $2 THEN -amount ELSE 0 END AS withdrawn
+				FROM transactions
+				UNION ALL
+				SELECT from_user_id, -amount, CASE WHEN operation_type = $1 THEN amount ELSE 0 END FROM transactions
+			) ledger
+			GROUP BY user_id
+		)
+		SELECT COALESCE(a.user_id, e.user_id),
+			COALESCE(a.current, 0), COALESCE(e.current, 0),
+			COALESCE(a.withdrawn, 0), COALESCE(e.withdrawn, 0)
+		FROM accounts a FULL OUTER JOIN expected e ON a.user_id = e.user_id
+		WHERE (COALESCE(a.current, 0) <> COALESCE(e.current, 0)
+				OR COALESCE(a.withdrawn, 0) <> COALESCE(e.withdrawn, 0))
+			AND NOT COALESCE(a.user_id, e.user_id) = ANY ($3)
+		ORDER BY 1`
+	rows, err := tx.Query(ctx, sql, repository.WithdrawOperationType, repository.ReversalOperationType, systemAccounts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile accounts: %v", err)
+	}
+	defer rows.Close()
+
+	drifts := make([]repository.AccountDrift, 0)
+	for rows.Next() {
+		var drift repository.AccountDrift
+		var current, expectedCurrent, withdrawn, expectedWithdrawn pgtype.Numeric
+		err = rows.Scan(&drift.UserID, &current, &expectedCurrent, &withdrawn, &expectedWithdrawn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan account drift row: %v", err)
+		}
+
+		for _, v := range []struct {
+			dst *money.Amount
+			src pgtype.Numeric
+		}{
+			{&drift.Current, current},
+			{&drift.ExpectedCurrent, expectedCurrent},
+			{&drift.Withdrawn, withdrawn},
+			{&drift.ExpectedWithdrawn, expectedWithdrawn},
+		} {
+			*v.dst, err = amountFromNumeric(v.src)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert account drift of user %d: %v", drift.UserID, err)
+			}
+		}
+
+		drifts = append(drifts, drift)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over account drift rows: %v", err)
+	}
+
+	if !fix {
+		return drifts, nil
+	}
+
+	for _, drift := range drifts {
+		fixSQL := `INSERT INTO accounts (user_id, current, withdrawn) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id) DO UPDATE SET
+				current = EXCLUDED.current,
+				withdrawn = EXCLUDED.withdrawn,
+				updated_at = CURRENT_TIMESTAMP`
+		_, err = tx.Exec(ctx, fixSQL, drift.UserID, numericFromAmount(drift.ExpectedCurrent), numericFromAmount(drift.ExpectedWithdrawn))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fix account of user %d: %v", drift.UserID, err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit reconciliation: %w", err)
+	}
+
+	return drifts, nil
+}
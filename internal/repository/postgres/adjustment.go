@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/jackc/pgtype"
+)
+
+// Adjust locks the user account before the ledger is written, so a debit is serialized with withdrawals of the user
+// and can't make the balance negative, a credit locks it as well, so both take the row locks in the same order
+func (r *TransactionRepository) Adjust(ctx context.Context, adjustment repository.Adjustment) (*repository.Adjustment, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	transaction := repository.Transaction{
+		FromUserID:    AdjustmentUserID,
+		ToUserID:      adjustment.UserID,
+		Amount:        adjustment.Amount,
+		OperationType: repository.AdjustmentOperationType,
+	}
+	account, err := lockAccount(ctx, tx, adjustment.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock account of user %d: %v", adjustment.UserID, err)
+	}
+	if adjustment.Amount < 0 {
+		if account.Available() < -adjustment.Amount {
+			return nil, repository.ErrInsufficientFunds
+		}
+		transaction.FromUserID, transaction.ToUserID, transaction.Amount = adjustment.UserID, AdjustmentUserID, -adjustment.Amount
+	}
+
+	createdTransaction, err := insertTransaction(ctx, tx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `INSERT INTO adjustments (transaction_id, user_id, admin_user_id, reason, ticket, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.Exec(ctx, sql, createdTransaction.TransactionID, adjustment.UserID, adjustment.AdminUserID,
+		adjustment.Reason, adjustment.Ticket, createdTransaction.Created)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create adjustment: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit tx, userID: %d, amount: %s: %w", adjustment.UserID, adjustment.Amount, err)
+	}
+
+	adjustment.TransactionID = createdTransaction.TransactionID
+	adjustment.Created = createdTransaction.Created
+	return &adjustment, nil
+}
+
+func (r *TransactionRepository) GetAdjustmentsByUserID(ctx context.Context, userID int64) ([]repository.Adjustment, error) {
+	sql := `SELECT a.transaction_id, a.admin_user_id, a.reason, a.ticket, a.created_at, t.amount, t.to_user_id = a.user_id
+		FROM adjustments a JOIN transactions t ON t.transaction_id = a.transaction_id
+		WHERE a.user_id = $1 ORDER BY a.created_at, a.transaction_id`
+	rows, err := r.db.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get adjustments: %v", err)
+	}
+	defer rows.Close()
+
+	adjustments := make([]repository.Adjustment, 0)
+	for rows.Next() {
+		adjustment := repository.Adjustment{UserID: userID}
+		var amount pgtype.Numeric
+		var credit bool
+		err = rows.Scan(&adjustment.TransactionID, &adjustment.AdminUserID, &adjustment.Reason, &adjustment.Ticket,
+			&adjustment.Created, &amount, &credit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan adjustment row: %v", err)
+		}
+
+		adjustment.Amount, err = amountFromNumeric(amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert adjustment amount: %v", err)
+		}
+		if !credit {
+			adjustment.Amount = -adjustment.Amount
+		}
+
+		adjustments = append(adjustments, adjustment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over adjustment rows: %v", err)
+	}
+
+	return adjustments, nil
+}
@@ -0,0 +1,15 @@
+package postgres
+
+import (
+	"errors"
+
+	"github.com/jackc/pgconn"
+)
+
+// uniqueViolationCode is the SQLSTATE of a unique constraint violation
+const uniqueViolationCode = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx"
+	pgxv4 "github.com/jackc/pgx/v4"
+)
+
+// holdColumns are selected by every hold query, the order must match scanHold,
+// a stale hold is EXPIRED even if ExpireHolds hasn't marked it yet
+const holdColumns = `id, user_id, amount, order_number,
+	CASE WHEN status = 'HELD' AND expires_at <= CURRENT_TIMESTAMP THEN 'EXPIRED' ELSE status END,
+	transaction_id, created_at, expires_at`
+
+func scanHold(row pgxv4.Row) (*repository.Hold, error) {
+	var hold repository.Hold
+	var amount pgtype.Numeric
+	var transactionID pgtype.Int8
+	err := row.Scan(&hold.ID, &hold.UserID, &amount, &hold.OrderNumber, &hold.Status, &transactionID, &hold.Created, &hold.Expires)
+	if err != nil {
+		return nil, err
+	}
+
+	hold.Amount, err = amountFromNumeric(amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert hold amount: %v", err)
+	}
+
+	if transactionID.Status == pgtype.Present {
+		hold.TransactionID = transactionID.Int
+	}
+
+	return &hold, nil
+}
+
+// PlaceHold locks the user account, so the hold is serialized with withdrawals and other holds of the user,
+// and checks the available balance
+func (r *TransactionRepository) PlaceHold(ctx context.Context, hold repository.Hold) (*repository.Hold, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	account, err := lockAccount(ctx, tx, hold.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock account of user %d: %v", hold.UserID, err)
+	}
+	if account.Available() < hold.Amount {
+		return nil, repository.ErrInsufficientFunds
+	}
+
+	sql := `INSERT INTO holds (user_id, amount, order_number, status, expires_at) VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + holdColumns
+	createdHold, err := scanHold(tx.QueryRow(ctx, sql, hold.UserID, numericFromAmount(hold.Amount), hold.OrderNumber,
+		repository.HoldStatusHeld, hold.Expires))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hold: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit tx, userID: %d, orderNumber: %s, amount: %s: %w", hold.UserID, hold.OrderNumber, hold.Amount, err)
+	}
+	return createdHold, nil
+}
+
+// CaptureHold locks the hold, so it's captured or released only once, and inserts the withdraw transaction,
+// the balance isn't checked again, the points are reserved by the hold
+func (r *TransactionRepository) CaptureHold(ctx context.Context, userID int64, holdID int64) (*repository.Hold, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	hold, err := lockActiveHold(ctx, tx, userID, holdID)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := insertTransaction(ctx, tx, repository.Transaction{
+		FromUserID:    userID,
+		ToUserID:      WithdrawUserID,
+		Amount:        hold.Amount,
+		OrderNumber:   hold.OrderNumber,
+		OperationType: repository.WithdrawOperationType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `UPDATE holds SET status = $1, transaction_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3`
+	_, err = tx.Exec(ctx, sql, repository.HoldStatusCaptured, transaction.TransactionID, holdID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture hold: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit tx, holdID: %d: %w", holdID, err)
+	}
+
+	hold.Status = repository.HoldStatusCaptured
+	hold.TransactionID = transaction.TransactionID
+	return hold, nil
+}
+
+func (r *TransactionRepository) ReleaseHold(ctx context.Context, userID int64, holdID int64) (*repository.Hold, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	hold, err := lockActiveHold(ctx, tx, userID, holdID)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `UPDATE holds SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+	_, err = tx.Exec(ctx, sql, repository.HoldStatusReleased, holdID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release hold: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit tx, holdID: %d: %w", holdID, err)
+	}
+
+	hold.Status = repository.HoldStatusReleased
+	return hold, nil
+}
+
+// lockActiveHold locks the hold of the user until the end of the database transaction
+func lockActiveHold(ctx context.Context, tx pgxv4.Tx, userID int64, holdID int64) (*repository.Hold, error) {
+	sql := `SELECT ` + holdColumns + ` FROM holds WHERE id = $1 AND user_id = $2 FOR UPDATE`
+	hold, err := scanHold(tx.QueryRow(ctx, sql, holdID, userID))
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, repository.ErrHoldNotFound
+		}
+		return nil, fmt.Errorf("failed to lock hold: %v", err)
+	}
+	if hold.Status != repository.HoldStatusHeld {
+		return nil, repository.ErrHoldNotActive
+	}
+
+	return hold, nil
+}
+
+// ExpireHolds marks the stale holds, they already don't reserve the points, so it's only a bookkeeping
+func (r *TransactionRepository) ExpireHolds(ctx context.Context) (int64, error) {
+	sql := `UPDATE holds SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE status = $2 AND expires_at <= CURRENT_TIMESTAMP`
+	tag, err := r.db.Exec(ctx, sql, repository.HoldStatusExpired, repository.HoldStatusHeld)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire holds: %v", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
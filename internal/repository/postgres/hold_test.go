@@ -0,0 +1,104 @@
+package postgres_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionRepositoryHolds(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	userRepo := postgres.NewUserRepository(testDB)
+	err := userRepo.CreateUser(ctx, repository.User{Username: "holduser", Password: "password"})
+	require.NoError(t, err)
+	user, err := userRepo.GetUserByUsername(ctx, "holduser")
+	require.NoError(t, err)
+
+	repo := postgres.NewTransactionRepository(testDB)
+	_, err = repo.CreateTransaction(ctx, repository.Transaction{
+		FromUserID:    postgres.AccrualUserID,
+		ToUserID:      user.ID,
+		Amount:        money.FromPoints(100),
+		OrderNumber:   "4111111111111111",
+		OperationType: repository.AccrualOperationType,
+	})
+	require.NoError(t, err)
+
+	expires := time.Now().Add(time.Hour)
+	captured, err := repo.PlaceHold(ctx, repository.Hold{UserID: user.ID, Amount: money.FromPoints(60), OrderNumber: "2377225624", Expires: expires})
+	require.NoError(t, err)
+	require.NotZero(t, captured.ID)
+	require.Equal(t, repository.HoldStatusHeld, captured.Status)
+
+	// the held points can't be held or withdrawn again
+	_, err = repo.PlaceHold(ctx, repository.Hold{UserID: user.ID, Amount: money.FromPoints(41), OrderNumber: "2377225624", Expires: expires})
+	require.ErrorIs(t, err, repository.ErrInsufficientFunds)
+	_, err = repo.Withdraw(ctx, user.ID, "2377225624", money.FromPoints(41))
+	require.ErrorIs(t, err, repository.ErrInsufficientFunds)
+
+	released, err := repo.PlaceHold(ctx, repository.Hold{UserID: user.ID, Amount: money.FromPoints(40), OrderNumber: "2377225624", Expires: expires})
+	require.NoError(t, err)
+
+	account, err := repo.GetAccount(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.FromPoints(100), account.Current)
+	require.Equal(t, money.FromPoints(100), account.Held)
+	require.Equal(t, money.Amount(0), account.Available())
+
+	// capture withdraws the held points
+	captured, err = repo.CaptureHold(ctx, user.ID, captured.ID)
+	require.NoError(t, err)
+	require.Equal(t, repository.HoldStatusCaptured, captured.Status)
+	require.NotZero(t, captured.TransactionID)
+	_, err = repo.ReleaseHold(ctx, user.ID, captured.ID)
+	require.ErrorIs(t, err, repository.ErrHoldNotActive)
+
+	// release makes the points available again
+	released, err = repo.ReleaseHold(ctx, user.ID, released.ID)
+	require.NoError(t, err)
+	require.Equal(t, repository.HoldStatusReleased, released.Status)
+	_, err = repo.CaptureHold(ctx, user.ID, released.ID)
+	require.ErrorIs(t, err, repository.ErrHoldNotActive)
+
+	// holds of other users are not found
+	_, err = repo.CaptureHold(ctx, postgres.WithdrawUserID, released.ID)
+	require.ErrorIs(t, err, repository.ErrHoldNotFound)
+
+	account, err = repo.GetAccount(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.FromPoints(40), account.Current)
+	require.Equal(t, money.Amount(0), account.Held)
+	require.Equal(t, money.FromPoints(60), account.Withdrawn)
+
+	withdrawals, err := repo.GetWithdrawalsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	require.Equal(t, captured.TransactionID, withdrawals[0].TransactionID)
+
+	// a stale hold doesn't reserve the points even before it's marked expired
+	stale, err := repo.PlaceHold(ctx, repository.Hold{UserID: user.ID, Amount: money.FromPoints(40), OrderNumber: "2377225624", Expires: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+	account, err = repo.GetAccount(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(0), account.Held)
+	_, err = repo.CaptureHold(ctx, user.ID, stale.ID)
+	require.ErrorIs(t, err, repository.ErrHoldNotActive)
+
+	expired, err := repo.ExpireHolds(ctx)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, expired, int64(1))
+	expired, err = repo.ExpireHolds(ctx)
+	require.NoError(t, err)
+	require.Zero(t, expired)
+
+	drifts, err := repo.ReconcileAccounts(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+}
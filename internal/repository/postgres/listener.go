@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	// NewOrdersChannel is notified with the order number by CreateOrder and RequeueOrders
+	NewOrdersChannel = "orders_new"
+
+	// listenReconnectDelay is the pause before the listener reconnects after a lost connection
+	listenReconnectDelay = time.Second
+)
+
+// OrderListener wakes up order processing when a new order is created by any instance.
+type OrderListener struct {
+	db *pgxpool.Pool
+}
+
+func NewOrderListener(db *pgxpool.Pool) *OrderListener {
+	return &OrderListener{db: db}
+}
+
+// Listen sends to wakeup on every notification of NewOrdersChannel until ctx is done,
+// the send never blocks, so notifications received while the previous one is not consumed are coalesced.
+// A lost connection is reestablished, wakeup is also sent after reconnect,
+// because notifications sent meanwhile are lost.
+func (l *OrderListener) Listen(ctx context.Context, wakeup chan<- struct{}) {
+	for {
+		err := l.listen(ctx, wakeup)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Logger().Error("listen for new orders, reconnecting", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenReconnectDelay):
+		}
+	}
+}
+
+func (l *OrderListener) listen(ctx context.Context, wakeup chan<- struct{}) error {
+	conn, err := l.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	// the connection may be broken, so it's closed instead of returning to the pool
+	defer func() {
+		conn.Conn().Close(context.Background()) //nolint:errcheck
+		conn.Release()
+	}()
+
+	_, err = conn.Exec(ctx, "LISTEN "+NewOrdersChannel)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", NewOrdersChannel, err)
+	}
+	notify(wakeup)
+
+	for {
+		_, err = conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("wait for notification: %w", err)
+		}
+		notify(wakeup)
+	}
+}
+
+func notify(wakeup chan<- struct{}) {
+	select {
+	case wakeup <- struct{}{}:
+	default:
+	}
+}
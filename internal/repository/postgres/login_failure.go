@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx"
+	pgxv4 "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type LoginFailureRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginFailureRepository(db *pgxpool.Pool) *LoginFailureRepository {
+	return &LoginFailureRepository{db: db}
+}
+
+const loginFailureColumns = `scope, key, failures, last_failure_at, locked_until`
+
+func scanLoginFailure(row pgxv4.Row) (*repository.LoginFailure, error) {
+	var failure repository.LoginFailure
+	var lockedUntil pgtype.Timestamptz
+	err := row.Scan(&failure.Scope, &failure.Key, &failure.Failures, &failure.LastFailureAt, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	failure.LockedUntil = timeFromTimestamptz(lockedUntil)
+
+	return &failure, nil
+}
+
+func (r *LoginFailureRepository) GetLoginFailure(ctx context.Context, scope string, key string) (*repository.LoginFailure, error) {
+	sql := `SELECT ` + loginFailureColumns + ` FROM login_failures WHERE scope = $1 AND key = $2`
+	failure, err := scanLoginFailure(r.db.QueryRow(ctx, sql, scope, key))
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get login failure: %v", err)
+	}
+
+	return failure, nil
+}
+
+// RecordLoginFailure counts the attempt with one upsert, so concurrent failed attempts are never lost
+func (r *LoginFailureRepository) RecordLoginFailure(ctx context.Context, scope string, key string, window time.Duration) (*repository.LoginFailure, error) {
+	sql := `INSERT INTO login_failures (scope, key, failures) VALUES ($1, $2, 1)
+		ON CONFLICT (scope, key) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failure_at < CURRENT_TIMESTAMP - $3::interval
+				THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure_at = CURRENT_TIMESTAMP
+		RETURNING ` + loginFailureColumns
+	failure, err := scanLoginFailure(r.db.QueryRow(ctx, sql, scope, key, window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %v", err)
+	}
+
+	return failure, nil
+}
+
+func (r *LoginFailureRepository) LockLogin(ctx context.Context, scope string, key string, until time.Time) error {
+	sql := `UPDATE login_failures SET locked_until = $3 WHERE scope = $1 AND key = $2`
+	_, err := r.db.Exec(ctx, sql, scope, key, until)
+	if err != nil {
+		return fmt.Errorf("failed to lock login: %v", err)
+	}
+
+	return nil
+}
+
+func (r *LoginFailureRepository) ResetLoginFailures(ctx context.Context, scope string, key string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM login_failures WHERE scope = $1 AND key = $2`, scope, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to reset login failures: %v", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,53 @@
+package postgres_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginFailureRepository(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	repo := postgres.NewLoginFailureRepository(testDB)
+	failure, err := repo.GetLoginFailure(ctx, repository.LoginFailureScopeLogin, "locked-user")
+	require.NoError(t, err)
+	require.Nil(t, failure)
+
+	for i := 1; i <= 3; i++ {
+		failure, err = repo.RecordLoginFailure(ctx, repository.LoginFailureScopeLogin, "locked-user", time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, i, failure.Failures)
+	}
+
+	// the same key of another scope is counted separately
+	failure, err = repo.RecordLoginFailure(ctx, repository.LoginFailureScopeIP, "locked-user", time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 1, failure.Failures)
+
+	until := time.Now().Add(time.Minute)
+	err = repo.LockLogin(ctx, repository.LoginFailureScopeLogin, "locked-user", until)
+	require.NoError(t, err)
+	failure, err = repo.GetLoginFailure(ctx, repository.LoginFailureScopeLogin, "locked-user")
+	require.NoError(t, err)
+	require.NotNil(t, failure.LockedUntil)
+	require.WithinDuration(t, until, *failure.LockedUntil, time.Millisecond)
+
+	// failures older than the window are forgotten
+	time.Sleep(10 * time.Millisecond)
+	failure, err = repo.RecordLoginFailure(ctx, repository.LoginFailureScopeLogin, "locked-user", time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, 1, failure.Failures)
+
+	reset, err := repo.ResetLoginFailures(ctx, repository.LoginFailureScopeLogin, "locked-user")
+	require.NoError(t, err)
+	require.True(t, reset)
+	reset, err = repo.ResetLoginFailures(ctx, repository.LoginFailureScopeLogin, "locked-user")
+	require.NoError(t, err)
+	require.False(t, reset)
+}
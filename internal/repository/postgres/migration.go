@@ -2,10 +2,15 @@ package postgres
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/fs"
-	"os"
-	"path/filepath"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/andreevym/gophermart/pkg/logger"
@@ -13,38 +18,312 @@ import (
 	"go.uber.org/zap"
 )
 
-func ApplyMigration(ctx context.Context, db *pgxpool.Pool, sql string) error {
-	rCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
-	defer cancel()
+const (
+	migrateUpMarker   = "-- +migrate Up"
+	migrateDownMarker = "-- +migrate Down"
 
-	_, err := db.Exec(rCtx, sql)
+	// migrationLockID is a pg_advisory_lock key, it keeps several instances
+	// from applying migrations at the same time
+	migrationLockID int64 = 7_390_186_534_001
+)
+
+var (
+	ErrMigrationChecksumMismatch = errors.New("applied migration was changed")
+	ErrMigrationNoDown           = errors.New("migration has no down section")
+)
+
+// Migration is a versioned schema change parsed from a migration file.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// HasDown is false when the file has no down section, such migration can't be rolled back,
+	// an empty down section rolls back nothing
+	HasDown  bool
+	Checksum string
+}
+
+// MigrationStatus describes whether a migration is applied to the database.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies ordered, checksummed migrations exactly once, bookkeeping
+// is kept in the schema_migrations table.
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []Migration
+}
+
+// NewMigrator parses all *.sql files of fsys, usually migrations.FS.
+func NewMigrator(db *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	files, err := fs.Glob(fsys, "*.sql")
 	if err != nil {
-		return fmt.Errorf("failed apply sql '%s': %w", sql, err)
+		return nil, fmt.Errorf("list migrations: %w", err)
 	}
 
-	return nil
+	migrations := make([]Migration, 0, len(files))
+	versions := make(map[int64]string, len(files))
+	for _, file := range files {
+		bytes, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", file, err)
+		}
+
+		migration, err := parseMigration(file, string(bytes))
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := versions[migration.Version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version %d", other, file, migration.Version)
+		}
+		versions[migration.Version] = file
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return &Migrator{db: db, migrations: migrations}, nil
 }
 
-func Migration(ctx context.Context, db *pgxpool.Pool) error {
-	err := filepath.Walk("migrations", func(path string, info fs.FileInfo, err error) error {
-		if !info.IsDir() {
-			logger.Logger().Info("apply migration", zap.String("path", path))
-			bytes, err := os.ReadFile(path)
+func parseMigration(file string, content string) (*Migration, error) {
+	base := strings.TrimSuffix(path.Base(file), ".sql")
+	versionStr, name, ok := strings.Cut(base, "_")
+	if !ok {
+		return nil, fmt.Errorf("migration %s: file name must be <version>_<name>.sql", file)
+	}
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("migration %s: parse version: %w", file, err)
+	}
+
+	upIndex := strings.Index(content, migrateUpMarker)
+	if upIndex < 0 {
+		return nil, fmt.Errorf("migration %s: %q section is missing", file, migrateUpMarker)
+	}
+	up := content[upIndex+len(migrateUpMarker):]
+	down := ""
+	downIndex := strings.Index(up, migrateDownMarker)
+	if downIndex >= 0 {
+		down = up[downIndex+len(migrateDownMarker):]
+		up = up[:downIndex]
+	}
+	up = strings.TrimSpace(up)
+	down = strings.TrimSpace(down)
+
+	checksum := sha256.Sum256([]byte(up))
+
+	return &Migration{
+		Version:  version,
+		Name:     name,
+		Up:       up,
+		Down:     down,
+		HasDown:  downIndex >= 0,
+		Checksum: hex.EncodeToString(checksum[:]),
+	}, nil
+}
+
+// Up applies all pending migrations in version order.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		err = m.verify(applied)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			logger.Logger().Info("apply migration", zap.Int64("version", migration.Version), zap.String("name", migration.Name))
+			insertSQL := `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`
+			err = inTx(ctx, conn, migration.Up, insertSQL, migration.Version, migration.Name, migration.Checksum)
 			if err != nil {
-				return err
+				return fmt.Errorf("apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down rolls back the last steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		err = m.verify(applied)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if !migration.HasDown {
+				return fmt.Errorf("roll back migration %d_%s: %w", migration.Version, migration.Name, ErrMigrationNoDown)
 			}
 
-			sql := string(bytes)
-			err = ApplyMigration(ctx, db, sql)
+			logger.Logger().Info("roll back migration", zap.Int64("version", migration.Version), zap.String("name", migration.Name))
+			deleteSQL := `DELETE FROM schema_migrations WHERE version = $1`
+			err = inTx(ctx, conn, migration.Down, deleteSQL, migration.Version)
 			if err != nil {
-				return fmt.Errorf("apply migration, path '%s', sql '%s': %w", path, sql, err)
+				return fmt.Errorf("roll back migration %d_%s: %w", migration.Version, migration.Name, err)
 			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// Status returns all known migrations with their applied time.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
 		}
 
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Migration: migration}
+			if appliedMigration, ok := applied[migration.Version]; ok {
+				status.AppliedAt = appliedMigration.AppliedAt
+			}
+			statuses = append(statuses, status)
+		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("exec migration: %w", err)
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// verify checks that already applied migrations were not changed afterwards
+func (m *Migrator) verify(applied map[int64]MigrationStatus) error {
+	known := make(map[int64]struct{}, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = struct{}{}
+		appliedMigration, ok := applied[migration.Version]
+		if !ok {
+			continue
+		}
+		if appliedMigration.Checksum != migration.Checksum {
+			return fmt.Errorf("%w: %d_%s", ErrMigrationChecksumMismatch, migration.Version, migration.Name)
+		}
 	}
+
+	for version, appliedMigration := range applied {
+		if _, ok := known[version]; !ok {
+			logger.Logger().Warn(
+				"database has a migration unknown to this binary",
+				zap.Int64("version", version),
+				zap.String("name", appliedMigration.Name),
+			)
+		}
+	}
+
 	return nil
 }
+
+// withLock runs fn on a dedicated connection holding the migration advisory lock
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID)
+	if err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+		if err != nil {
+			logger.Logger().Error("release migration lock", zap.Error(err))
+		}
+	}()
+
+	createSQL := `CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version    BIGINT PRIMARY KEY,
+			name       TEXT                     NOT NULL,
+			checksum   TEXT                     NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`
+	_, err = conn.Exec(ctx, createSQL)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int64]MigrationStatus, error) {
+	rows, err := conn.Query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]MigrationStatus)
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt time.Time
+		err = rows.Scan(&status.Version, &status.Name, &status.Checksum, &appliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan migration row: %v", err)
+		}
+		status.AppliedAt = &appliedAt
+		applied[status.Version] = status
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over migration rows: %v", err)
+	}
+
+	return applied, nil
+}
+
+// inTx executes the migration script and its bookkeeping statement atomically
+func inTx(ctx context.Context, conn *pgxpool.Conn, script string, bookkeepingSQL string, args ...interface{}) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	// a script without arguments is sent with the simple protocol, so it may contain several statements
+	if script != "" {
+		_, err = tx.Exec(ctx, script)
+		if err != nil {
+			return fmt.Errorf("exec script: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, bookkeepingSQL, args...)
+	if err != nil {
+		return fmt.Errorf("exec bookkeeping: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
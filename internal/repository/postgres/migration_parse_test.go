@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMigration(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		wantUp   string
+		wantDown string
+		hasDown  bool
+		wantErr  bool
+	}{
+		{
+			name:     "up and down",
+			file:     "00001_init.sql",
+			content:  "-- +migrate Up\nCREATE TABLE t (id INT);\n\n-- +migrate Down\nDROP TABLE t;\n",
+			wantUp:   "CREATE TABLE t (id INT);",
+			wantDown: "DROP TABLE t;",
+			hasDown:  true,
+		},
+		{
+			name:    "empty down rolls back nothing",
+			file:    "00002_data.sql",
+			content: "-- +migrate Up\nUPDATE t SET id = 1;\n-- +migrate Down\n-- nothing to restore\n",
+			wantUp:  "UPDATE t SET id = 1;",
+			// the comment is kept, it's a valid script which does nothing
+			wantDown: "-- nothing to restore",
+			hasDown:  true,
+		},
+		{
+			name:    "no down section",
+			file:    "00003_irreversible.sql",
+			content: "-- +migrate Up\nDELETE FROM t;\n",
+			wantUp:  "DELETE FROM t;",
+		},
+		{
+			name:    "no up section",
+			file:    "00004_broken.sql",
+			content: "CREATE TABLE t (id INT);\n",
+			wantErr: true,
+		},
+		{
+			name:    "no version",
+			file:    "init.sql",
+			content: "-- +migrate Up\nCREATE TABLE t (id INT);\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migration, err := parseMigration(test.file, test.content)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.wantUp, migration.Up)
+			require.Equal(t, test.wantDown, migration.Down)
+			require.Equal(t, test.hasDown, migration.HasDown)
+			require.NotEmpty(t, migration.Checksum)
+		})
+	}
+}
@@ -2,29 +2,40 @@ package postgres_test
 
 import (
 	"context"
-	"fmt"
-	"io/fs"
-	"os"
-	"path/filepath"
+	"testing"
 
 	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/migrations"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
 )
 
 func migrate(db *pgxpool.Pool) error {
-	return filepath.Walk("../../../migrations", func(path string, info fs.FileInfo, err error) error {
-		if !info.IsDir() {
-			bytes, err := os.ReadFile(path)
-			if err != nil {
-				return fmt.Errorf("read file: %w", err)
-			}
-
-			err = postgres.ApplyMigration(context.TODO(), db, string(bytes))
-			if err != nil {
-				return fmt.Errorf("apply migration: %w", err)
-			}
-		}
+	migrator, err := postgres.NewMigrator(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	return migrator.Up(context.TODO())
+}
+
+func TestMigrator(t *testing.T) {
+	require.NotNil(t, testDB)
 
-		return nil
-	})
+	migrator, err := postgres.NewMigrator(testDB, migrations.FS)
+	require.NoError(t, err)
+
+	// migrations are already applied by TestMain, the second run is a no-op
+	err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	statuses, err := migrator.Status(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, statuses)
+	for i, status := range statuses {
+		require.NotNil(t, status.AppliedAt, status.Name)
+		if i > 0 {
+			require.Greater(t, status.Version, statuses[i-1].Version)
+		}
+	}
 }
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/jackc/pgtype"
+)
+
+// numericFromAmount converts an amount to NUMERIC query argument.
+// money.Amount must never be passed to a query as is, pgx would encode it as integer minor units.
+func numericFromAmount(amount money.Amount) pgtype.Numeric {
+	return pgtype.Numeric{
+		Int:    big.NewInt(amount.Minor()),
+		Exp:    -money.Scale,
+		Status: pgtype.Present,
+	}
+}
+
+// amountFromNumeric converts a scanned NUMERIC value to amount, NULL is converted to zero.
+func amountFromNumeric(numeric pgtype.Numeric) (money.Amount, error) {
+	if numeric.Status != pgtype.Present {
+		return 0, nil
+	}
+	if numeric.NaN || numeric.InfinityModifier != pgtype.None {
+		return 0, fmt.Errorf("numeric %v can't be converted to amount", numeric)
+	}
+
+	minor := new(big.Int).Set(numeric.Int)
+	shift := int64(numeric.Exp) + money.Scale
+	if shift >= 0 {
+		minor.Mul(minor, new(big.Int).Exp(big.NewInt(10), big.NewInt(shift), nil))
+	} else {
+		remainder := new(big.Int)
+		minor.QuoRem(minor, new(big.Int).Exp(big.NewInt(10), big.NewInt(-shift), nil), remainder)
+		if remainder.Sign() != 0 {
+			return 0, fmt.Errorf("numeric has more than %d fraction digits", money.Scale)
+		}
+	}
+	if !minor.IsInt64() {
+		return 0, fmt.Errorf("numeric overflows amount")
+	}
+
+	return money.FromMinor(minor.Int64()), nil
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/andreevym/gophermart/internal/repository"
 	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx"
+	pgxv4 "github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -24,40 +26,63 @@ func NewOrderRepository(db *pgxpool.Pool) *OrderRepository {
 	return &OrderRepository{db: db}
 }
 
+// CreateOrder inserts the order and notifies NewOrdersChannel with the order number,
+// the notification is delivered only if the order is committed
 func (r *OrderRepository) CreateOrder(ctx context.Context, order repository.Order) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
 	if order.UploadedAt.IsZero() {
 		sql := `INSERT INTO orders (number, user_id, status) VALUES ($1, $2, $3)`
-		_, err := r.db.Exec(ctx, sql, order.Number, order.UserID, order.Status)
+		_, err = tx.Exec(ctx, sql, order.Number, order.UserID, order.Status)
 		if err != nil {
 			return fmt.Errorf("failed to create order: %v", err)
 		}
 	} else {
 		sql := `INSERT INTO orders (number, user_id, status, uploaded_at) VALUES ($1, $2, $3, $4)`
-		_, err := r.db.Exec(ctx, sql, order.Number, order.UserID, order.Status, order.UploadedAt)
+		_, err = tx.Exec(ctx, sql, order.Number, order.UserID, order.Status, order.UploadedAt)
 		if err != nil {
 			return fmt.Errorf("failed to create order: %v", err)
 		}
 	}
 
+	_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, NewOrdersChannel, order.Number)
+	if err != nil {
+		return fmt.Errorf("failed to notify new order: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+
 	return nil
 }
 
-func (r *OrderRepository) GetOrderByNumber(ctx context.Context, orderNumber string) (*repository.Order, error) {
-	sql := `SELECT user_id, status, accrual, uploaded_at FROM orders WHERE number = $1`
+// orderColumns are selected by every order query, the order must match scanOrder
+const orderColumns = `number, user_id, status, accrual, uploaded_at, attempts, next_attempt_at, last_error,
+	lease_owner, lease_expires_at, requeued_at`
+
+func scanOrder(row pgxv4.Row) (*repository.Order, error) {
 	var order repository.Order
-	var accrual pgtype.Float4
+	var accrual pgtype.Numeric
 	var uploadedAtNullable pgtype.Timestamptz
-	err := r.db.QueryRow(ctx, sql, orderNumber).Scan(&order.UserID, &order.Status, &accrual, &uploadedAtNullable)
+	var lastError pgtype.Text
+	var leaseOwner pgtype.Text
+	var leaseExpiresAt pgtype.Timestamptz
+	var requeuedAt pgtype.Timestamptz
+	err := row.Scan(&order.Number, &order.UserID, &order.Status, &accrual, &uploadedAtNullable,
+		&order.Attempts, &order.NextAttemptAt, &lastError, &leaseOwner, &leaseExpiresAt, &requeuedAt)
 	if err != nil {
-		if err.Error() == pgx.ErrNoRows.Error() {
-			return nil, ErrOrderNotFound
-		}
-		return nil, fmt.Errorf("failed to get order: %v", err)
+		return nil, err
 	}
 
-	order.Number = orderNumber
-	if accrual.Status == pgtype.Present {
-		order.Accrual = accrual.Float
+	order.Accrual, err = amountFromNumeric(accrual)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert order accrual: %v", err)
 	}
 
 	if uploadedAtNullable.Status == pgtype.Present {
@@ -66,19 +91,68 @@ func (r *OrderRepository) GetOrderByNumber(ctx context.Context, orderNumber stri
 		order.UploadedAt = time.Time{}
 	}
 
+	if lastError.Status == pgtype.Present {
+		order.LastError = lastError.String
+	}
+
+	if leaseOwner.Status == pgtype.Present {
+		order.LeaseOwner = leaseOwner.String
+	}
+
+	if leaseExpiresAt.Status == pgtype.Present {
+		order.LeaseExpiresAt = leaseExpiresAt.Time
+	}
+
+	if requeuedAt.Status == pgtype.Present {
+		order.RequeuedAt = &requeuedAt.Time
+	}
+
 	return &order, nil
 }
 
+func scanOrders(rows pgxv4.Rows) ([]repository.Order, error) {
+	defer rows.Close()
+
+	orders := make([]repository.Order, 0)
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order row: %v", err)
+		}
+
+		orders = append(orders, *order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over order rows: %v", err)
+	}
+
+	return orders, nil
+}
+
+func (r *OrderRepository) GetOrderByNumber(ctx context.Context, orderNumber string) (*repository.Order, error) {
+	sql := `SELECT ` + orderColumns + ` FROM orders WHERE number = $1`
+	order, err := scanOrder(r.db.QueryRow(ctx, sql, orderNumber))
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("failed to get order: %v", err)
+	}
+
+	return order, nil
+}
+
 func (r *OrderRepository) UpdateOrder(ctx context.Context, order repository.Order) error {
 	if order.UploadedAt.IsZero() {
 		sql := `UPDATE orders SET user_id = $1, status = $2, accrual = $3 WHERE number = $4`
-		_, err := r.db.Exec(ctx, sql, order.UserID, order.Status, order.Accrual, order.Number)
+		_, err := r.db.Exec(ctx, sql, order.UserID, order.Status, numericFromAmount(order.Accrual), order.Number)
 		if err != nil {
 			return fmt.Errorf("failed to update order, sql %s: %v", sql, err)
 		}
 	} else {
 		sql := `UPDATE orders SET user_id = $1, status = $2, accrual = $3, uploaded_at = $4 WHERE number = $5`
-		_, err := r.db.Exec(ctx, sql, order.UserID, order.Status, order.Accrual, order.UploadedAt, order.Number)
+		_, err := r.db.Exec(ctx, sql, order.UserID, order.Status, numericFromAmount(order.Accrual), order.UploadedAt, order.Number)
 		if err != nil {
 			return fmt.Errorf("failed to update order, sql %s: %v", sql, err)
 		}
@@ -98,77 +172,152 @@ func (r *OrderRepository) DeleteOrder(ctx context.Context, orderNumber string) e
 }
 
 func (r *OrderRepository) GetOrdersByUserID(ctx context.Context, userID int64) ([]repository.Order, error) {
-	sql := `SELECT number, user_id, status, accrual, uploaded_at FROM orders WHERE user_id = $1 ORDER BY uploaded_at`
+	sql := `SELECT ` + orderColumns + ` FROM orders WHERE user_id = $1 ORDER BY uploaded_at`
 	rows, err := r.db.Query(ctx, sql, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get orders: %v", err)
 	}
-	defer rows.Close()
 
-	orders := make([]repository.Order, 0)
-	for rows.Next() {
-		var uploadedAtNullable pgtype.Timestamptz
-		var order repository.Order
-		var accrual pgtype.Float4
-		err := rows.Scan(&order.Number, &order.UserID, &order.Status, &accrual, &uploadedAtNullable)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan order row: %v", err)
-		}
+	return scanOrders(rows)
+}
 
-		if accrual.Status == pgtype.Present {
-			order.Accrual = accrual.Float
-		}
+func (r *OrderRepository) GetOrdersByStatus(ctx context.Context, status string) ([]repository.Order, error) {
+	sql := `SELECT ` + orderColumns + ` FROM orders WHERE status = $1 ORDER BY uploaded_at`
+	rows, err := r.db.Query(ctx, sql, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get orders: %v", err)
+	}
 
-		if uploadedAtNullable.Status == pgtype.Present {
-			order.UploadedAt = uploadedAtNullable.Time
-		} else {
-			order.UploadedAt = time.Time{}
-		}
+	return scanOrders(rows)
+}
 
-		orders = append(orders, order)
+// ClaimOrders leases due orders to owner, the orders are selected with FOR UPDATE SKIP LOCKED,
+// so concurrent claims of several instances never return the same order
+func (r *OrderRepository) ClaimOrders(ctx context.Context, owner string, statuses []string, limit int, lease time.Duration) ([]repository.Order, error) {
+	sql := `UPDATE orders SET lease_owner = $1, lease_expires_at = CURRENT_TIMESTAMP + $4::interval
+		WHERE number IN (
+			SELECT number FROM orders
+			WHERE status = ANY($2) AND next_attempt_at <= CURRENT_TIMESTAMP
+				AND (lease_expires_at IS NULL OR lease_expires_at <= CURRENT_TIMESTAMP)
+			ORDER BY next_attempt_at LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + orderColumns
+	rows, err := r.db.Query(ctx, sql, owner, statuses, limit, lease)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim orders: %v", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over order rows: %v", err)
+	return scanOrders(rows)
+}
+
+// ScheduleOrderPoll stores a non-final status received from the accrual system and postpones the next poll by delay,
+// the update is fenced by the lease, so an instance whose lease has been taken over can't overwrite the result of another one
+func (r *OrderRepository) ScheduleOrderPoll(ctx context.Context, orderNumber string, owner string, status string, delay time.Duration) error {
+	sql := `UPDATE orders SET status = $3, attempts = 0, next_attempt_at = CURRENT_TIMESTAMP + $4::interval, last_error = NULL,
+			lease_owner = NULL, lease_expires_at = NULL
+		WHERE number = $1 AND lease_owner = $2`
+	tag, err := r.db.Exec(ctx, sql, orderNumber, owner, status, delay)
+	if err != nil {
+		return fmt.Errorf("failed to schedule order poll: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrOrderLeaseLost
 	}
 
-	return orders, nil
+	return nil
 }
 
-func (r *OrderRepository) GetOrdersByStatus(ctx context.Context, status string) ([]repository.Order, error) {
-	sql := `SELECT  number, user_id, status, accrual, uploaded_at FROM orders WHERE status = $1 ORDER BY uploaded_at`
-	rows, err := r.db.Query(ctx, sql, status)
+// ScheduleOrderRetry records a failed processing attempt and postpones the next one by delay,
+// the update is fenced by the lease like ScheduleOrderPoll
+func (r *OrderRepository) ScheduleOrderRetry(ctx context.Context, orderNumber string, owner string, delay time.Duration, lastError string) error {
+	sql := `UPDATE orders SET attempts = attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + $3::interval, last_error = $4,
+			lease_owner = NULL, lease_expires_at = NULL
+		WHERE number = $1 AND lease_owner = $2`
+	tag, err := r.db.Exec(ctx, sql, orderNumber, owner, delay, lastError)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get orders: %v", err)
+		return fmt.Errorf("failed to schedule order retry: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrOrderLeaseLost
 	}
-	defer rows.Close()
 
-	orders := make([]repository.Order, 0)
+	return nil
+}
+
+// RequeueOrders updates the selected orders and records the action with one database transaction,
+// NewOrdersChannel is notified, so the requeued orders are processed without waiting for the next poll
+func (r *OrderRepository) RequeueOrders(ctx context.Context, filter repository.OrderFilter, status string, action repository.AdminAction) ([]string, error) {
+	// an empty filter would requeue every order ever uploaded
+	if filter.Number == "" && filter.Status == "" {
+		return nil, errors.New("failed to requeue orders: filter must select orders by number or status")
+	}
+
+	args := []interface{}{status}
+	conditions := make([]string, 0, 4)
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Number != "" {
+		addCondition("number = $%d", filter.Number)
+	}
+	if filter.Status != "" {
+		addCondition("status = $%d", filter.Status)
+	}
+	if !filter.UploadedFrom.IsZero() {
+		addCondition("uploaded_at >= $%d", filter.UploadedFrom)
+	}
+	if !filter.UploadedTo.IsZero() {
+		addCondition("uploaded_at < $%d", filter.UploadedTo)
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	sql := `UPDATE orders SET status = $1, attempts = 0, next_attempt_at = CURRENT_TIMESTAMP, last_error = NULL,
+			lease_owner = NULL, lease_expires_at = NULL, requeued_at = CURRENT_TIMESTAMP
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		RETURNING number`
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to requeue orders: %v", err)
+	}
+	numbers := make([]string, 0)
 	for rows.Next() {
-		var uploadedAtNullable pgtype.Timestamptz
-		var order repository.Order
-		var accrual pgtype.Float4
-		err := rows.Scan(&order.Number, &order.UserID, &order.Status, &accrual, &uploadedAtNullable)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan order row: %v", err)
+		var number string
+		if err = rows.Scan(&number); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan requeued order number: %v", err)
 		}
+		numbers = append(numbers, number)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over requeued orders: %v", err)
+	}
 
-		if accrual.Status == pgtype.Present {
-			order.Accrual = accrual.Float
-		}
+	action.Details = strings.Join(numbers, ",")
+	sql = `INSERT INTO admin_audit_log (admin_user_id, action, target, details) VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(ctx, sql, action.AdminUserID, action.Action, action.Target, action.Details)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record admin action: %v", err)
+	}
 
-		if uploadedAtNullable.Status == pgtype.Present {
-			order.UploadedAt = uploadedAtNullable.Time
-		} else {
-			order.UploadedAt = time.Time{}
+	if len(numbers) > 0 {
+		_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, NewOrdersChannel, numbers[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to notify requeued orders: %v", err)
 		}
-
-		orders = append(orders, order)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over order rows: %v", err)
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit tx: %w", err)
 	}
 
-	return orders, nil
+	return numbers, nil
 }
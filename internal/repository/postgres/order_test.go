@@ -3,9 +3,11 @@ package postgres_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/andreevym/gophermart/internal/repository"
 	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/pkg/money"
 	"github.com/stretchr/testify/require"
 )
 
@@ -46,7 +48,7 @@ func TestOrderRepository(t *testing.T) {
 		Number:  order.Number,
 		UserID:  order.UserID,
 		Status:  "completed",
-		Accrual: 200,
+		Accrual: money.FromMinor(20050),
 	}
 
 	err = repo.UpdateOrder(context.Background(), orderToUpdate)
@@ -76,3 +78,198 @@ func TestOrderRepository(t *testing.T) {
 	require.Error(t, err)
 	require.EqualError(t, err, postgres.ErrOrderNotFound.Error())
 }
+
+func TestOrderRepositoryRetry(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	repo := postgres.NewOrderRepository(testDB)
+
+	order := repository.Order{
+		Number: "4561261212345467",
+		UserID: 1,
+		Status: "NEW",
+	}
+	err := repo.CreateOrder(ctx, order)
+	require.NoError(t, err)
+	defer repo.DeleteOrder(ctx, order.Number) //nolint:errcheck
+
+	// failed attempt postpones the order
+	leaseOrder(t, order.Number, "instance-1")
+	err = repo.ScheduleOrderRetry(ctx, order.Number, "instance-1", time.Hour, "accrual system is unavailable")
+	require.NoError(t, err)
+
+	retriedOrder, err := repo.GetOrderByNumber(ctx, order.Number)
+	require.NoError(t, err)
+	require.Equal(t, 1, retriedOrder.Attempts)
+	require.Equal(t, "accrual system is unavailable", retriedOrder.LastError)
+	require.True(t, retriedOrder.NextAttemptAt.After(time.Now().Add(50*time.Minute)))
+}
+
+func TestOrderRepositoryClaim(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	repo := postgres.NewOrderRepository(testDB)
+
+	order := repository.Order{
+		Number: "79927398713",
+		UserID: 1,
+		Status: "NEW",
+	}
+	err := repo.CreateOrder(ctx, order)
+	require.NoError(t, err)
+	defer repo.DeleteOrder(ctx, order.Number) //nolint:errcheck
+
+	claimedOrders, err := repo.ClaimOrders(ctx, "instance-1", []string{"NEW"}, 100, time.Hour)
+	require.NoError(t, err)
+	require.Contains(t, orderNumbers(claimedOrders), order.Number)
+
+	claimedOrder, err := repo.GetOrderByNumber(ctx, order.Number)
+	require.NoError(t, err)
+	require.Equal(t, "instance-1", claimedOrder.LeaseOwner)
+	require.True(t, claimedOrder.LeaseExpiresAt.After(time.Now().Add(50*time.Minute)))
+
+	// the leased order is skipped by another instance
+	claimedOrders, err = repo.ClaimOrders(ctx, "instance-2", []string{"NEW"}, 100, time.Hour)
+	require.NoError(t, err)
+	require.NotContains(t, orderNumbers(claimedOrders), order.Number)
+
+	// an expired lease is reclaimed
+	_, err = testDB.Exec(ctx, `UPDATE orders SET lease_expires_at = CURRENT_TIMESTAMP - interval '1 second' WHERE number = $1`, order.Number)
+	require.NoError(t, err)
+	claimedOrders, err = repo.ClaimOrders(ctx, "instance-2", []string{"NEW"}, 100, time.Hour)
+	require.NoError(t, err)
+	require.Contains(t, orderNumbers(claimedOrders), order.Number)
+
+	// the instance which has lost the lease can't store its result
+	err = repo.ScheduleOrderPoll(ctx, order.Number, "instance-1", "PROCESSING", 0)
+	require.ErrorIs(t, err, repository.ErrOrderLeaseLost)
+	err = repo.ScheduleOrderRetry(ctx, order.Number, "instance-1", time.Hour, "timeout")
+	require.ErrorIs(t, err, repository.ErrOrderLeaseLost)
+	err = postgres.NewTransactionRepository(testDB).AccrualAmount(ctx, order.UserID, order.Number, "instance-1", 0, postgres.InvalidOrderStatus)
+	require.ErrorIs(t, err, repository.ErrOrderLeaseLost)
+
+	// storing the attempt result releases the lease
+	err = repo.ScheduleOrderPoll(ctx, order.Number, "instance-2", "PROCESSING", 0)
+	require.NoError(t, err)
+	releasedOrder, err := repo.GetOrderByNumber(ctx, order.Number)
+	require.NoError(t, err)
+	require.Empty(t, releasedOrder.LeaseOwner)
+	require.True(t, releasedOrder.LeaseExpiresAt.IsZero())
+}
+
+func TestOrderRepositoryRequeue(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	repo := postgres.NewOrderRepository(testDB)
+
+	uploadedAt := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	for _, order := range []repository.Order{
+		{Number: "5105105105105100", UserID: 1, Status: "INVALID", UploadedAt: uploadedAt},
+		{Number: "4012888888881881", UserID: 1, Status: "INVALID", UploadedAt: uploadedAt.Add(time.Hour)},
+		{Number: "4222222222222", UserID: 1, Status: "PROCESSED", UploadedAt: uploadedAt},
+	} {
+		err := repo.CreateOrder(ctx, order)
+		require.NoError(t, err)
+		defer repo.DeleteOrder(ctx, order.Number) //nolint:errcheck
+	}
+	leaseOrder(t, "5105105105105100", "instance-1")
+	err := repo.ScheduleOrderRetry(ctx, "5105105105105100", "instance-1", time.Hour, "accrual system is unavailable")
+	require.NoError(t, err)
+
+	// only INVALID orders uploaded in the range are requeued
+	numbers, err := repo.RequeueOrders(ctx,
+		repository.OrderFilter{Status: "INVALID", UploadedFrom: uploadedAt, UploadedTo: uploadedAt.Add(time.Minute)},
+		"NEW",
+		repository.AdminAction{AdminUserID: postgres.WithdrawUserID, Action: repository.AdminActionOrdersRequeue, Target: "status=INVALID"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"5105105105105100"}, numbers)
+
+	requeuedOrder, err := repo.GetOrderByNumber(ctx, "5105105105105100")
+	require.NoError(t, err)
+	require.Equal(t, "NEW", requeuedOrder.Status)
+	require.Zero(t, requeuedOrder.Attempts)
+	require.Empty(t, requeuedOrder.LastError)
+	require.NotNil(t, requeuedOrder.RequeuedAt)
+	require.False(t, requeuedOrder.NextAttemptAt.After(time.Now()))
+
+	untouchedOrder, err := repo.GetOrderByNumber(ctx, "4012888888881881")
+	require.NoError(t, err)
+	require.Equal(t, "INVALID", untouchedOrder.Status)
+	require.Nil(t, untouchedOrder.RequeuedAt)
+
+	// the status is checked, so an order processed meanwhile is left as is
+	numbers, err = repo.RequeueOrders(ctx,
+		repository.OrderFilter{Number: "4222222222222", Status: "INVALID"},
+		"NEW",
+		repository.AdminAction{AdminUserID: postgres.WithdrawUserID, Action: repository.AdminActionOrderRequeue, Target: "4222222222222"},
+	)
+	require.NoError(t, err)
+	require.Empty(t, numbers)
+
+	// the actions are recorded with the operator
+	var details []string
+	rows, err := testDB.Query(ctx, `SELECT details FROM admin_audit_log WHERE admin_user_id = $1 AND action = ANY($2) ORDER BY id`,
+		postgres.WithdrawUserID, []string{repository.AdminActionOrdersRequeue, repository.AdminActionOrderRequeue})
+	require.NoError(t, err)
+	for rows.Next() {
+		var detail string
+		require.NoError(t, rows.Scan(&detail))
+		details = append(details, detail)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{"5105105105105100", ""}, details)
+
+	_, err = repo.RequeueOrders(ctx, repository.OrderFilter{}, "NEW", repository.AdminAction{AdminUserID: postgres.WithdrawUserID})
+	require.Error(t, err)
+}
+
+func TestOrderListener(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wakeup := make(chan struct{}, 1)
+	go postgres.NewOrderListener(testDB).Listen(ctx, wakeup)
+
+	// the listener wakes up right after LISTEN, notifications sent before it are lost
+	select {
+	case <-wakeup:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener is not started")
+	}
+
+	repo := postgres.NewOrderRepository(testDB)
+	order := repository.Order{
+		Number: "49927398716",
+		UserID: 1,
+		Status: "NEW",
+	}
+	err := repo.CreateOrder(ctx, order)
+	require.NoError(t, err)
+	defer repo.DeleteOrder(context.Background(), order.Number) //nolint:errcheck
+
+	select {
+	case <-wakeup:
+	case <-time.After(5 * time.Second):
+		t.Fatal("new order is not notified")
+	}
+}
+
+func orderNumbers(orders []repository.Order) []string {
+	numbers := make([]string, 0, len(orders))
+	for _, order := range orders {
+		numbers = append(numbers, order.Number)
+	}
+	return numbers
+}
+
+// leaseOrder leases the order to owner without claiming other due orders of the shared database
+func leaseOrder(t *testing.T, number string, owner string) {
+	_, err := testDB.Exec(context.Background(),
+		`UPDATE orders SET lease_owner = $2, lease_expires_at = CURRENT_TIMESTAMP + interval '1 hour' WHERE number = $1`, number, owner)
+	require.NoError(t, err)
+}
@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/jackc/pgtype"
+	pgxv4 "github.com/jackc/pgx/v4"
+)
+
+// ReverseWithdrawal locks the withdraw transaction first, so concurrent reversals of the same withdrawal
+// are serialized and only the first one returns the points. The user account is locked before the ledger is written,
+// so the reversal takes the account lock in the same order as withdrawals and adjustments of the user
+func (r *TransactionRepository) ReverseWithdrawal(ctx context.Context, reversal repository.Reversal) (*repository.Reversal, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	withdrawal, err := lockTransaction(ctx, tx, reversal.WithdrawalTransactionID)
+	if err != nil {
+		return nil, err
+	}
+	if withdrawal.OperationType != repository.WithdrawOperationType {
+		return nil, ErrTransactionNotFound
+	}
+
+	var reversed bool
+	sql := `SELECT EXISTS (SELECT 1 FROM withdrawal_reversals WHERE withdrawal_transaction_id = $1)`
+	err = tx.QueryRow(ctx, sql, reversal.WithdrawalTransactionID).Scan(&reversed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check reversal of withdrawal: %v", err)
+	}
+	if reversed {
+		return nil, repository.ErrWithdrawalAlreadyReversed
+	}
+
+	_, err = lockAccount(ctx, tx, withdrawal.FromUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock account of user %d: %v", withdrawal.FromUserID, err)
+	}
+
+	createdTransaction, err := insertTransaction(ctx, tx, repository.Transaction{
+		FromUserID:    WithdrawUserID,
+		ToUserID:      withdrawal.FromUserID,
+		Amount:        withdrawal.Amount,
+		OrderNumber:   withdrawal.OrderNumber,
+		OperationType: repository.ReversalOperationType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sql = `INSERT INTO withdrawal_reversals (transaction_id, withdrawal_transaction_id, actor_user_id, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.Exec(ctx, sql, createdTransaction.TransactionID, reversal.WithdrawalTransactionID, reversal.ActorUserID,
+		reversal.Reason, createdTransaction.Created)
+	if err != nil {
+		// the unique constraint is the last line of defence against a double reversal
+		if isUniqueViolation(err) {
+			return nil, repository.ErrWithdrawalAlreadyReversed
+		}
+		return nil, fmt.Errorf("failed to create withdrawal reversal: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit tx, withdrawal: %d: %w", reversal.WithdrawalTransactionID, err)
+	}
+
+	reversal.TransactionID = createdTransaction.TransactionID
+	reversal.UserID = withdrawal.FromUserID
+	reversal.Amount = withdrawal.Amount
+	reversal.OrderNumber = withdrawal.OrderNumber
+	reversal.Created = createdTransaction.Created
+	return &reversal, nil
+}
+
+// withdrawalColumns are selected by every withdrawal query, the order must match scanWithdrawals
+const withdrawalColumns = `w.transaction_id, w.from_user_id, w.to_user_id, w.amount, w.order_number, w.operation_type, w.created_at,
+	r.transaction_id, r.actor_user_id, r.reason, r.created_at`
+
+const withdrawalsFrom = `transactions w LEFT JOIN withdrawal_reversals r ON r.withdrawal_transaction_id = w.transaction_id`
+
+func (r *TransactionRepository) GetWithdrawalsByUserID(ctx context.Context, userID int64) ([]repository.Withdrawal, error) {
+	sql := `SELECT ` + withdrawalColumns + ` FROM ` + withdrawalsFrom + `
+		WHERE w.from_user_id = $1 AND w.operation_type = $2 ORDER BY w.created_at DESC, w.transaction_id DESC`
+	rows, err := r.db.Query(ctx, sql, userID, repository.WithdrawOperationType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get withdrawals: %v", err)
+	}
+
+	return scanWithdrawals(rows)
+}
+
+func (r *TransactionRepository) GetWithdrawalsByOrderNumber(ctx context.Context, orderNumber string) ([]repository.Withdrawal, error) {
+	sql := `SELECT ` + withdrawalColumns + ` FROM ` + withdrawalsFrom + `
+		WHERE w.order_number = $1 AND w.operation_type = $2 ORDER BY w.created_at, w.transaction_id`
+	rows, err := r.db.Query(ctx, sql, orderNumber, repository.WithdrawOperationType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get withdrawals: %v", err)
+	}
+
+	return scanWithdrawals(rows)
+}
+
+func scanWithdrawals(rows pgxv4.Rows) ([]repository.Withdrawal, error) {
+	defer rows.Close()
+
+	withdrawals := make([]repository.Withdrawal, 0)
+	for rows.Next() {
+		var withdrawal repository.Withdrawal
+		var amount pgtype.Numeric
+		var reversalTransactionID, actorUserID pgtype.Int8
+		var reason pgtype.Text
+		var reversed pgtype.Timestamptz
+		err := rows.Scan(&withdrawal.TransactionID, &withdrawal.FromUserID, &withdrawal.ToUserID, &amount,
+			&withdrawal.OrderNumber, &withdrawal.OperationType, &withdrawal.Created,
+			&reversalTransactionID, &actorUserID, &reason, &reversed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan withdrawal row: %v", err)
+		}
+
+		withdrawal.Amount, err = amountFromNumeric(amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert withdrawal amount: %v", err)
+		}
+
+		if reversalTransactionID.Status == pgtype.Present {
+			withdrawal.Reversal = &repository.Reversal{
+				TransactionID:           reversalTransactionID.Int,
+				WithdrawalTransactionID: withdrawal.TransactionID,
+				UserID:                  withdrawal.FromUserID,
+				Amount:                  withdrawal.Amount,
+				OrderNumber:             withdrawal.OrderNumber,
+				ActorUserID:             actorUserID.Int,
+				Reason:                  reason.String,
+				Created:                 reversed.Time,
+			}
+		}
+
+		withdrawals = append(withdrawals, withdrawal)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over withdrawal rows: %v", err)
+	}
+
+	return withdrawals, nil
+}
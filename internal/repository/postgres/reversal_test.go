@@ -0,0 +1,128 @@
+package postgres_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionRepositoryReverseWithdrawal(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	userRepo := postgres.NewUserRepository(testDB)
+	err := userRepo.CreateUser(ctx, repository.User{Username: "reverseduser", Password: "password"})
+	require.NoError(t, err)
+	user, err := userRepo.GetUserByUsername(ctx, "reverseduser")
+	require.NoError(t, err)
+
+	repo := postgres.NewTransactionRepository(testDB)
+	accrual, err := repo.CreateTransaction(ctx, repository.Transaction{
+		FromUserID:    postgres.AccrualUserID,
+		ToUserID:      user.ID,
+		Amount:        money.FromPoints(100),
+		OrderNumber:   "371449635398431",
+		OperationType: repository.AccrualOperationType,
+	})
+	require.NoError(t, err)
+	withdrawal, err := repo.Withdraw(ctx, user.ID, "378282246310005", money.FromPoints(60))
+	require.NoError(t, err)
+
+	// only a withdraw transaction is reversed
+	_, err = repo.ReverseWithdrawal(ctx, repository.Reversal{WithdrawalTransactionID: accrual.TransactionID, ActorUserID: postgres.WithdrawUserID, Reason: "r"})
+	require.ErrorIs(t, err, postgres.ErrTransactionNotFound)
+
+	reversal, err := repo.ReverseWithdrawal(ctx, repository.Reversal{
+		WithdrawalTransactionID: withdrawal.TransactionID,
+		ActorUserID:             postgres.WithdrawUserID,
+		Reason:                  "purchase cancelled",
+	})
+	require.NoError(t, err)
+	require.NotZero(t, reversal.TransactionID)
+	require.Equal(t, user.ID, reversal.UserID)
+	require.Equal(t, money.FromPoints(60), reversal.Amount)
+	require.Equal(t, "378282246310005", reversal.OrderNumber)
+
+	// the points come back and are not withdrawn anymore
+	account, err := repo.GetAccount(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.FromPoints(100), account.Current)
+	require.Equal(t, money.Amount(0), account.Withdrawn)
+
+	_, err = repo.ReverseWithdrawal(ctx, repository.Reversal{WithdrawalTransactionID: withdrawal.TransactionID, ActorUserID: postgres.WithdrawUserID, Reason: "again"})
+	require.ErrorIs(t, err, repository.ErrWithdrawalAlreadyReversed)
+
+	withdrawals, err := repo.GetWithdrawalsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	require.NotNil(t, withdrawals[0].Reversal)
+	require.Equal(t, reversal.TransactionID, withdrawals[0].Reversal.TransactionID)
+	require.Equal(t, "purchase cancelled", withdrawals[0].Reversal.Reason)
+
+	withdrawals, err = repo.GetWithdrawalsByOrderNumber(ctx, "378282246310005")
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	require.Equal(t, withdrawal.TransactionID, withdrawals[0].TransactionID)
+
+	drifts, err := repo.ReconcileAccounts(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+}
+
+func TestTransactionRepositoryReverseWithdrawalConcurrently(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	userRepo := postgres.NewUserRepository(testDB)
+	err := userRepo.CreateUser(ctx, repository.User{Username: "concurrentreversal", Password: "password"})
+	require.NoError(t, err)
+	user, err := userRepo.GetUserByUsername(ctx, "concurrentreversal")
+	require.NoError(t, err)
+
+	repo := postgres.NewTransactionRepository(testDB)
+	_, err = repo.Adjust(ctx, repository.Adjustment{UserID: user.ID, Amount: money.FromPoints(100), AdminUserID: postgres.WithdrawUserID, Reason: "r", Ticket: "t"})
+	require.NoError(t, err)
+	withdrawals := make([]*repository.Transaction, 0, 10)
+	for i := 0; i < 10; i++ {
+		withdrawal, err := repo.Withdraw(ctx, user.ID, "6011111111111117", money.FromPoints(1))
+		require.NoError(t, err)
+		withdrawals = append(withdrawals, withdrawal)
+	}
+
+	// reversals, adjustments and withdrawals of the user take the account lock in the same order and never deadlock
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 3*len(withdrawals))
+	for _, withdrawal := range withdrawals {
+		wg.Add(3)
+		go func(transactionID int64) {
+			defer wg.Done()
+			_, err := repo.ReverseWithdrawal(ctx, repository.Reversal{WithdrawalTransactionID: transactionID, ActorUserID: postgres.WithdrawUserID, Reason: "r"})
+			errs <- err
+		}(withdrawal.TransactionID)
+		go func() {
+			defer wg.Done()
+			_, err := repo.Adjust(ctx, repository.Adjustment{UserID: user.ID, Amount: money.FromPoints(1), AdminUserID: postgres.WithdrawUserID, Reason: "r", Ticket: "t"})
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := repo.Withdraw(ctx, user.ID, "6011000990139424", money.FromPoints(1))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	account, err := repo.GetAccount(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.FromPoints(100), account.Current)
+	require.Equal(t, money.FromPoints(10), account.Withdrawn)
+}
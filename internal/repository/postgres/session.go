@@ -0,0 +1,174 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type SessionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewSessionRepository(db *pgxpool.Pool) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (r *SessionRepository) CreateSession(ctx context.Context, session repository.Session) error {
+	sql := `INSERT INTO sessions (id, user_id, device, user_agent, ip) VALUES ($1, $2, $3, $4, $5)`
+	_, err := r.db.Exec(ctx, sql, session.ID, session.UserID, session.Device, session.UserAgent, session.IP)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return nil
+}
+
+func (r *SessionRepository) TouchSession(ctx context.Context, sessionID string, ip string) error {
+	sql := `UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, ip = $2 WHERE id = $1`
+	_, err := r.db.Exec(ctx, sql, sessionID, ip)
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %v", err)
+	}
+
+	return nil
+}
+
+// GetActiveSessionsByUserID skips the sessions whose refresh tokens have all expired, they can't be continued
+// though nobody has revoked them
+func (r *SessionRepository) GetActiveSessionsByUserID(ctx context.Context, userID int64) ([]repository.Session, error) {
+	sql := `SELECT id, user_id, device, user_agent, ip, created_at, last_seen_at, revoked_at
+		FROM sessions s WHERE user_id = $1 AND revoked_at IS NULL
+			AND EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = s.id
+				AND t.used_at IS NULL AND t.revoked_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP)
+		ORDER BY last_seen_at DESC`
+	rows, err := r.db.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]repository.Session, 0)
+	for rows.Next() {
+		var session repository.Session
+		var revokedAt pgtype.Timestamptz
+		err = rows.Scan(&session.ID, &session.UserID, &session.Device, &session.UserAgent, &session.IP,
+			&session.Created, &session.LastSeenAt, &revokedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session row: %v", err)
+		}
+		session.RevokedAt = timeFromTimestamptz(revokedAt)
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over session rows: %v", err)
+	}
+
+	return sessions, nil
+}
+
+func (r *SessionRepository) RevokeSession(ctx context.Context, userID int64, sessionID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	sql := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	tag, err := tx.Exec(ctx, sql, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrSessionNotFound
+	}
+
+	err = revokeRefreshTokenFamilies(ctx, tx, []string{sessionID})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionRepository) RevokeOtherSessions(ctx context.Context, userID int64, keepSessionID string) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	sql := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL RETURNING id`
+	rows, err := tx.Query(ctx, sql, userID, keepSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	sessionIDs := make([]string, 0)
+	for rows.Next() {
+		var sessionID string
+		err = rows.Scan(&sessionID)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan session id: %v", err)
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating over session rows: %v", err)
+	}
+
+	err = revokeRefreshTokenFamilies(ctx, tx, sessionIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return int64(len(sessionIDs)), nil
+}
+
+// DeleteStaleSessions keeps the refresh tokens until they expire, a used token presented before that
+// is still detected as a reuse. A session is only deleted when none of its tokens is left,
+// so a session created just now is never deleted before its first token is stored.
+func (r *SessionRepository) DeleteStaleSessions(ctx context.Context, seenBefore time.Time) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	_, err = tx.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired refresh tokens: %v", err)
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired revoked tokens: %v", err)
+	}
+
+	sql := `DELETE FROM sessions s WHERE s.last_seen_at < $1
+		AND NOT EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = s.id)`
+	tag, err := tx.Exec(ctx, sql, seenBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete stale sessions: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
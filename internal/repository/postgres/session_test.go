@@ -0,0 +1,73 @@
+package postgres_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionRepository(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	repo := postgres.NewSessionRepository(testDB)
+	tokenRepo := postgres.NewTokenRepository(testDB)
+	for _, id := range []string{"web-session", "phone-session", "tablet-session", "expired-session"} {
+		err := repo.CreateSession(ctx, repository.Session{ID: id, UserID: 1, Device: id, UserAgent: "test", IP: "192.0.2.1"})
+		require.NoError(t, err)
+		expiresAt := time.Now().Add(time.Hour)
+		if id == "expired-session" {
+			expiresAt = time.Now().Add(-time.Second)
+		}
+		err = tokenRepo.CreateRefreshToken(ctx, repository.RefreshToken{TokenHash: id + "-hash", UserID: 1, FamilyID: id, ExpiresAt: expiresAt})
+		require.NoError(t, err)
+	}
+
+	err := repo.TouchSession(ctx, "phone-session", "192.0.2.2")
+	require.NoError(t, err)
+
+	sessions, err := repo.GetActiveSessionsByUserID(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, sessions, 3, "a session whose refresh tokens have expired is not active")
+	require.Equal(t, "phone-session", sessions[0].ID, "the most recently seen session goes first")
+	require.Equal(t, "192.0.2.2", sessions[0].IP)
+
+	err = repo.RevokeSession(ctx, 1, "tablet-session")
+	require.NoError(t, err)
+	err = repo.RevokeSession(ctx, 1, "tablet-session")
+	require.ErrorIs(t, err, repository.ErrSessionNotFound)
+	err = repo.RevokeSession(ctx, 2, "web-session")
+	require.ErrorIs(t, err, repository.ErrSessionNotFound, "a session of another user can't be revoked")
+
+	revoked, err := tokenRepo.IsAccessTokenRevoked(ctx, "jti", "tablet-session")
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	count, err := repo.RevokeOtherSessions(ctx, 1, "web-session")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	sessions, err = repo.GetActiveSessionsByUserID(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, "web-session", sessions[0].ID)
+
+	revoked, err = tokenRepo.IsAccessTokenRevoked(ctx, "jti", "web-session")
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	// only the expired session has no refresh tokens left, the revoked ones keep theirs until they expire
+	deleted, err := repo.DeleteStaleSessions(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	_, err = tokenRepo.RotateRefreshToken(ctx, "expired-session-hash", repository.RefreshToken{TokenHash: "next-hash", ExpiresAt: time.Now().Add(time.Hour)})
+	require.ErrorIs(t, err, repository.ErrRefreshTokenInvalid)
+
+	deleted, err = repo.DeleteStaleSessions(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, deleted, "sessions seen recently are kept")
+}
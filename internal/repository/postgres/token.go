@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx"
+	pgxv4 "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type TokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewTokenRepository(db *pgxpool.Pool) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+func (r *TokenRepository) CreateRefreshToken(ctx context.Context, token repository.RefreshToken) error {
+	sql := `INSERT INTO refresh_tokens (token_hash, user_id, family_id, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err := r.db.Exec(ctx, sql, token.TokenHash, token.UserID, token.FamilyID, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %v", err)
+	}
+
+	return nil
+}
+
+// RotateRefreshToken locks the presented token, so concurrent rotations of the same token are serialized
+// and only the first one succeeds, the next ones are treated as a reuse
+func (r *TokenRepository) RotateRefreshToken(ctx context.Context, tokenHash string, next repository.RefreshToken) (*repository.RefreshToken, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	sql := `SELECT token_hash, user_id, family_id, expires_at, created_at, used_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`
+	var token repository.RefreshToken
+	var usedAt, revokedAt pgtype.Timestamptz
+	err = tx.QueryRow(ctx, sql, tokenHash).Scan(&token.TokenHash, &token.UserID, &token.FamilyID,
+		&token.ExpiresAt, &token.Created, &usedAt, &revokedAt)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, repository.ErrRefreshTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to lock refresh token: %v", err)
+	}
+	token.UsedAt = timeFromTimestamptz(usedAt)
+	token.RevokedAt = timeFromTimestamptz(revokedAt)
+
+	if token.RevokedAt != nil || !token.ExpiresAt.After(time.Now()) {
+		return nil, repository.ErrRefreshTokenInvalid
+	}
+
+	if token.UsedAt != nil {
+		err = revokeRefreshTokenFamilies(ctx, tx, []string{token.FamilyID})
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(ctx, `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL`, token.FamilyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to revoke session: %v", err)
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to commit tx: %w", err)
+		}
+		return nil, repository.ErrRefreshTokenReused
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark refresh token used: %v", err)
+	}
+
+	sql = `INSERT INTO refresh_tokens (token_hash, user_id, family_id, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(ctx, sql, next.TokenHash, token.UserID, token.FamilyID, next.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit tx: %w", err)
+	}
+
+	return &token, nil
+}
+
+// revokeRefreshTokenFamilies revokes all refresh tokens of the families, the family id is the session id
+func revokeRefreshTokenFamilies(ctx context.Context, tx pgxv4.Tx, familyIDs []string) error {
+	sql := `UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ANY($1) AND revoked_at IS NULL`
+	_, err := tx.Exec(ctx, sql, familyIDs)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token families: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeAccessToken also removes the revoked tokens which have already expired, they can't be used anyway
+func (r *TokenRepository) RevokeAccessToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired revoked tokens: %v", err)
+	}
+
+	sql := `INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`
+	_, err = r.db.Exec(ctx, sql, jti, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to revoke access token: %v", err)
+	}
+
+	return nil
+}
+
+func (r *TokenRepository) IsAccessTokenRevoked(ctx context.Context, jti string, familyID string) (bool, error) {
+	sql := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+		OR EXISTS (SELECT 1 FROM sessions WHERE id = $2 AND revoked_at IS NOT NULL)
+		OR EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $2 AND revoked_at IS NOT NULL)`
+	var revoked bool
+	err := r.db.QueryRow(ctx, sql, jti, familyID).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check access token revocation: %v", err)
+	}
+
+	return revoked, nil
+}
+
+func timeFromTimestamptz(t pgtype.Timestamptz) *time.Time {
+	if t.Status != pgtype.Present {
+		return nil
+	}
+	return &t.Time
+}
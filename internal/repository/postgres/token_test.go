@@ -0,0 +1,74 @@
+package postgres_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenRepositoryRotateRefreshToken(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	repo := postgres.NewTokenRepository(testDB)
+	err := repo.CreateRefreshToken(ctx, repository.RefreshToken{
+		TokenHash: "first",
+		UserID:    1,
+		FamilyID:  "family",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	usedToken, err := repo.RotateRefreshToken(ctx, "first", repository.RefreshToken{
+		TokenHash: "second",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), usedToken.UserID)
+	require.Equal(t, "family", usedToken.FamilyID)
+
+	_, err = repo.RotateRefreshToken(ctx, "unknown", repository.RefreshToken{TokenHash: "third"})
+	require.ErrorIs(t, err, repository.ErrRefreshTokenInvalid)
+
+	revoked, err := repo.IsAccessTokenRevoked(ctx, "jti", "family")
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	// the reuse of the rotated token revokes the family
+	_, err = repo.RotateRefreshToken(ctx, "first", repository.RefreshToken{
+		TokenHash: "third",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.ErrorIs(t, err, repository.ErrRefreshTokenReused)
+
+	_, err = repo.RotateRefreshToken(ctx, "second", repository.RefreshToken{
+		TokenHash: "third",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.ErrorIs(t, err, repository.ErrRefreshTokenInvalid)
+
+	revoked, err = repo.IsAccessTokenRevoked(ctx, "jti", "family")
+	require.NoError(t, err)
+	require.True(t, revoked)
+}
+
+func TestTokenRepositoryRevokeAccessToken(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	repo := postgres.NewTokenRepository(testDB)
+	err := repo.RevokeAccessToken(ctx, "revoked-jti", 1, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	revoked, err := repo.IsAccessTokenRevoked(ctx, "revoked-jti", "other-family")
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	revoked, err = repo.IsAccessTokenRevoked(ctx, "other-jti", "other-family")
+	require.NoError(t, err)
+	require.False(t, revoked)
+}
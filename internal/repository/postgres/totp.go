@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type TOTPRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewTOTPRepository(db *pgxpool.Pool) *TOTPRepository {
+	return &TOTPRepository{db: db}
+}
+
+func (r *TOTPRepository) GetTOTP(ctx context.Context, userID int64) (*repository.TOTP, error) {
+	sql := `SELECT user_id, secret, created_at, enabled_at, last_used_step FROM user_totp WHERE user_id = $1`
+	var totp repository.TOTP
+	var enabledAt pgtype.Timestamptz
+	err := r.db.QueryRow(ctx, sql, userID).Scan(&totp.UserID, &totp.Secret, &totp.Created, &enabledAt, &totp.LastUsedStep)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get totp: %v", err)
+	}
+	totp.EnabledAt = timeFromTimestamptz(enabledAt)
+
+	return &totp, nil
+}
+
+// SaveTOTP never replaces an enabled secret, the row stays as is and the codes are not stored
+func (r *TOTPRepository) SaveTOTP(ctx context.Context, userID int64, secret string, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	sql := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, created_at = CURRENT_TIMESTAMP, last_used_step = 0
+		WHERE user_totp.enabled_at IS NULL`
+	tag, err := tx.Exec(ctx, sql, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to save totp: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to save totp: totp of user %d is already enabled", userID)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(ctx, `INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, codeHash)
+		if err != nil {
+			return fmt.Errorf("failed to save recovery code: %v", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+func (r *TOTPRepository) EnableTOTP(ctx context.Context, userID int64) error {
+	sql := `UPDATE user_totp SET enabled_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND enabled_at IS NULL`
+	_, err := r.db.Exec(ctx, sql, userID)
+	if err != nil {
+		return fmt.Errorf("failed to enable totp: %v", err)
+	}
+
+	return nil
+}
+
+func (r *TOTPRepository) DeleteTOTP(ctx context.Context, userID int64) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	_, err = tx.Exec(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete totp: %v", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+// UseTOTPStep is a compare-and-set, concurrent requests with the same code can't both succeed
+func (r *TOTPRepository) UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	sql := `UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+	tag, err := r.db.Exec(ctx, sql, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to use totp step: %v", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *TOTPRepository) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	sql := `UPDATE totp_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	tag, err := r.db.Exec(ctx, sql, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %v", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,59 @@
+package postgres_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPRepository(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	repo := postgres.NewTOTPRepository(testDB)
+	totp, err := repo.GetTOTP(ctx, 1)
+	require.NoError(t, err)
+	require.Nil(t, totp)
+
+	// a not confirmed enrollment is replaced
+	err = repo.SaveTOTP(ctx, 1, "FIRSTSECRET", []string{"first-code"})
+	require.NoError(t, err)
+	err = repo.SaveTOTP(ctx, 1, "SECONDSECRET", []string{"second-code"})
+	require.NoError(t, err)
+	used, err := repo.UseRecoveryCode(ctx, 1, "first-code")
+	require.NoError(t, err)
+	require.False(t, used)
+
+	err = repo.EnableTOTP(ctx, 1)
+	require.NoError(t, err)
+	totp, err = repo.GetTOTP(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "SECONDSECRET", totp.Secret)
+	require.NotNil(t, totp.EnabledAt)
+
+	err = repo.SaveTOTP(ctx, 1, "THIRDSECRET", nil)
+	require.Error(t, err, "an enabled secret can't be replaced")
+
+	// a step and a recovery code are accepted once
+	fresh, err := repo.UseTOTPStep(ctx, 1, 100)
+	require.NoError(t, err)
+	require.True(t, fresh)
+	fresh, err = repo.UseTOTPStep(ctx, 1, 100)
+	require.NoError(t, err)
+	require.False(t, fresh)
+
+	used, err = repo.UseRecoveryCode(ctx, 1, "second-code")
+	require.NoError(t, err)
+	require.True(t, used)
+	used, err = repo.UseRecoveryCode(ctx, 1, "second-code")
+	require.NoError(t, err)
+	require.False(t, used)
+
+	err = repo.DeleteTOTP(ctx, 1)
+	require.NoError(t, err)
+	totp, err = repo.GetTOTP(ctx, 1)
+	require.NoError(t, err)
+	require.Nil(t, totp)
+}
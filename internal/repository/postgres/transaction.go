@@ -6,17 +6,26 @@ import (
 	"fmt"
 
 	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx"
+	pgxv4 "github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 const (
 	WithdrawUserID              = 1
 	AccrualUserID               = 2
+	AdjustmentUserID            = -1
+	InvalidOrderStatus   string = "INVALID"
 	ProcessedOrderStatus string = "PROCESSED"
 )
 
-var ErrTransactionNotFound = errors.New("transaction not found")
+var (
+	// ErrTransactionNotFound is the same error as repository.ErrTransactionNotFound, callers outside the storage match the latter
+	ErrTransactionNotFound      = repository.ErrTransactionNotFound
+	ErrTransactionAlreadyExists = errors.New("transaction already exists")
+)
 
 type TransactionRepository struct {
 	db *pgxpool.Pool
@@ -27,69 +36,245 @@ func NewTransactionRepository(db *pgxpool.Pool) *TransactionRepository {
 }
 
 func (r *TransactionRepository) CreateTransaction(ctx context.Context, transaction repository.Transaction) (*repository.Transaction, error) {
-	var transactionID int64
-	sql := `INSERT INTO transactions (from_user_id, to_user_id, amount, order_number, operation_type) VALUES ($1, $2, $3, $4, $5) RETURNING transaction_id`
-	err := r.db.QueryRow(ctx, sql, transaction.FromUserID, transaction.ToUserID, transaction.Amount, transaction.OrderNumber, transaction.OperationType).Scan(&transactionID)
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create transaction: %v", err)
+		return nil, fmt.Errorf("begin: %w", err)
 	}
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	transaction.TransactionID = transactionID
-	return &transaction, nil
+	createdTransaction, err := insertTransaction(ctx, tx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return createdTransaction, nil
 }
 
 func (r *TransactionRepository) UpdateTransaction(ctx context.Context, transaction repository.Transaction) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	oldTransaction, err := lockTransaction(ctx, tx, transaction.TransactionID)
+	if err != nil {
+		return err
+	}
+
 	sql := `UPDATE transactions SET from_user_id = $1, to_user_id = $2, amount = $3, order_number = $4, operation_type = $5 WHERE transaction_id = $6`
-	_, err := r.db.Exec(ctx, sql, transaction.FromUserID, transaction.ToUserID, transaction.Amount, transaction.OrderNumber, transaction.OperationType, transaction.TransactionID)
+	_, err = tx.Exec(ctx, sql, transaction.FromUserID, transaction.ToUserID, numericFromAmount(transaction.Amount), transaction.OrderNumber, transaction.OperationType, transaction.TransactionID)
 	if err != nil {
 		return fmt.Errorf("failed to update transaction: %v", err)
 	}
 
+	err = applyTransaction(ctx, tx, *oldTransaction, -1)
+	if err != nil {
+		return err
+	}
+	err = applyTransaction(ctx, tx, transaction, 1)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
 	return nil
 }
 
 func (r *TransactionRepository) DeleteTransaction(ctx context.Context, transactionID int64) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	oldTransaction, err := lockTransaction(ctx, tx, transactionID)
+	if err != nil {
+		if errors.Is(err, ErrTransactionNotFound) {
+			return nil
+		}
+		return err
+	}
+
 	sql := `DELETE FROM transactions WHERE transaction_id = $1`
-	_, err := r.db.Exec(ctx, sql, transactionID)
+	_, err = tx.Exec(ctx, sql, transactionID)
 	if err != nil {
 		return fmt.Errorf("failed to delete transaction: %v", err)
 	}
 
+	err = applyTransaction(ctx, tx, *oldTransaction, -1)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
 	return nil
 }
 
-// AccrualAmount execute change update order and insert transaction with one database transaction
-func (r TransactionRepository) AccrualAmount(ctx context.Context, userID int64, orderNumber string, accrual float32, orderStatus string) error {
+func lockTransaction(ctx context.Context, tx pgxv4.Tx, transactionID int64) (*repository.Transaction, error) {
+	sql := `SELECT from_user_id, to_user_id, amount, order_number, operation_type FROM transactions WHERE transaction_id = $1 FOR UPDATE`
+	transaction := repository.Transaction{TransactionID: transactionID}
+	var amount pgtype.Numeric
+	err := tx.QueryRow(ctx, sql, transactionID).Scan(&transaction.FromUserID, &transaction.ToUserID, &amount,
+		&transaction.OrderNumber, &transaction.OperationType)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, fmt.Errorf("failed to lock transaction: %v", err)
+	}
+
+	transaction.Amount, err = amountFromNumeric(amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert transaction amount: %v", err)
+	}
+
+	return &transaction, nil
+}
+
+// AccrualAmount execute change update order and insert transaction with one database transaction,
+// the order row is locked first, so concurrent calls for the same order are serialized
+// and only the first one credits the user. An order rechecked by an operator may be credited already,
+// it's never credited twice: it keeps PROCESSED status and the credited accrual,
+// a different result of the accrual system is stored as the last error of the order for the operator.
+// The result is stored only by the lease owner, an instance whose lease has been taken over gets ErrOrderLeaseLost.
+func (r TransactionRepository) AccrualAmount(ctx context.Context, userID int64, orderNumber string, owner string, accrual money.Amount, orderStatus string) error {
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("begin: %w", err)
 	}
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	if accrual > 0 {
-		insertTxsql := `INSERT INTO transactions (from_user_id, to_user_id, amount, order_number, operation_type) VALUES ($1, $2, $3, $4, $5)`
-		_, err = tx.Exec(ctx, insertTxsql, AccrualUserID, userID, accrual, orderNumber, repository.AccrualOperationType)
+	var currentStatus string
+	var leaseOwner pgtype.Text
+	err = tx.QueryRow(ctx, `SELECT status, lease_owner FROM orders WHERE number = $1 FOR UPDATE`, orderNumber).
+		Scan(&currentStatus, &leaseOwner)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return ErrOrderNotFound
+		}
+		return fmt.Errorf("failed to lock order: %v", err)
+	}
+	if currentStatus == InvalidOrderStatus || currentStatus == ProcessedOrderStatus {
+		return repository.ErrOrderAlreadyFinalized
+	}
+	if leaseOwner.Status != pgtype.Present || leaseOwner.String != owner {
+		return repository.ErrOrderLeaseLost
+	}
+
+	credited, err := creditedAccrual(ctx, tx, orderNumber)
+	if err != nil {
+		return err
+	}
+
+	var lastError *string
+	switch {
+	case credited != nil:
+		if *credited != accrual || orderStatus != ProcessedOrderStatus {
+			discrepancy := fmt.Sprintf("accrual system reports %s %s, %s is already credited", orderStatus, accrual, *credited)
+			lastError = &discrepancy
+		}
+		accrual, orderStatus = *credited, ProcessedOrderStatus
+	case accrual > 0:
+		_, err = insertTransaction(ctx, tx, repository.Transaction{
+			FromUserID:    AccrualUserID,
+			ToUserID:      userID,
+			Amount:        accrual,
+			OrderNumber:   orderNumber,
+			OperationType: repository.AccrualOperationType,
+		})
 		if err != nil {
-			return fmt.Errorf("failed to create transaction: %v", err)
+			// the unique index is the last line of defence against a double credit
+			if errors.Is(err, ErrTransactionAlreadyExists) {
+				return repository.ErrOrderAlreadyFinalized
+			}
+			return err
 		}
 	}
 
-	sql := `UPDATE orders SET status = $1, accrual = $2 WHERE number = $3`
-	_, err = tx.Exec(ctx, sql, orderStatus, accrual, orderNumber)
+	sql := `UPDATE orders SET status = $1, accrual = $2, last_error = $3, lease_owner = NULL, lease_expires_at = NULL
+		WHERE number = $4 AND lease_owner = $5`
+	_, err = tx.Exec(ctx, sql, orderStatus, numericFromAmount(accrual), lastError, orderNumber, owner)
 	if err != nil {
 		return fmt.Errorf("failed to update order, sql %s: %v", sql, err)
 	}
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to commit tx, userID: %d, orderNumber: %s, accrual: %f: %w", userID, orderNumber, accrual, err)
+		return fmt.Errorf("failed to commit tx, userID: %d, orderNumber: %s, accrual: %s: %w", userID, orderNumber, accrual, err)
 	}
 	return nil
 }
 
+// creditedAccrual returns the amount already credited for the order, nil if there is no accrual transaction
+func creditedAccrual(ctx context.Context, tx pgxv4.Tx, orderNumber string) (*money.Amount, error) {
+	sql := `SELECT amount FROM transactions WHERE order_number = $1 AND operation_type = $2`
+	var amount pgtype.Numeric
+	err := tx.QueryRow(ctx, sql, orderNumber, repository.AccrualOperationType).Scan(&amount)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get accrual of order: %v", err)
+	}
+
+	credited, err := amountFromNumeric(amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert accrual amount: %v", err)
+	}
+	return &credited, nil
+}
+
+// Withdraw locks the user account, so concurrent withdrawals of the same user are serialized,
+// checks the balance and inserts the withdraw transaction with one database transaction
+func (r *TransactionRepository) Withdraw(ctx context.Context, userID int64, orderNumber string, amount money.Amount) (*repository.Transaction, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	account, err := lockAccount(ctx, tx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock account of user %d: %v", userID, err)
+	}
+	if account.Available() < amount {
+		return nil, repository.ErrInsufficientFunds
+	}
+
+	transaction, err := insertTransaction(ctx, tx, repository.Transaction{
+		FromUserID:    userID,
+		ToUserID:      WithdrawUserID,
+		Amount:        amount,
+		OrderNumber:   orderNumber,
+		OperationType: repository.WithdrawOperationType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit tx, userID: %d, orderNumber: %s, amount: %s: %w", userID, orderNumber, amount, err)
+	}
+	return transaction, nil
+}
+
 func (r *TransactionRepository) GetTransactionByID(ctx context.Context, transactionID int64) (*repository.Transaction, error) {
 	sql := `SELECT from_user_id, to_user_id, amount, order_number, operation_type FROM transactions WHERE transaction_id = $1`
 	var transaction repository.Transaction
-	err := r.db.QueryRow(ctx, sql, transactionID).Scan(&transaction.FromUserID, &transaction.ToUserID, &transaction.Amount, &transaction.OrderNumber,
+	var amount pgtype.Numeric
+	err := r.db.QueryRow(ctx, sql, transactionID).Scan(&transaction.FromUserID, &transaction.ToUserID, &amount, &transaction.OrderNumber,
 		&transaction.OperationType)
 	if err != nil {
 		if err.Error() == pgx.ErrNoRows.Error() {
@@ -98,6 +283,11 @@ func (r *TransactionRepository) GetTransactionByID(ctx context.Context, transact
 		return nil, fmt.Errorf("failed to get transaction: %v", err)
 	}
 
+	transaction.Amount, err = amountFromNumeric(amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert transaction amount: %v", err)
+	}
+
 	transaction.TransactionID = transactionID
 
 	return &transaction, nil
@@ -115,12 +305,18 @@ func (r *TransactionRepository) GetTransactionsByUserIDAndOperationType(ctx cont
 	transactions := make([]repository.Transaction, 0)
 	for rows.Next() {
 		var transaction repository.Transaction
+		var amount pgtype.Numeric
 		err = rows.Scan(&transaction.TransactionID, &transaction.FromUserID, &transaction.ToUserID,
-			&transaction.Amount, &transaction.OrderNumber, &transaction.Created)
+			&amount, &transaction.OrderNumber, &transaction.Created)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan transaction row: %v", err)
 		}
 
+		transaction.Amount, err = amountFromNumeric(amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert transaction amount: %v", err)
+		}
+
 		transaction.OperationType = operationType
 		transactions = append(transactions, transaction)
 	}
@@ -129,8 +325,8 @@ func (r *TransactionRepository) GetTransactionsByUserIDAndOperationType(ctx cont
 }
 
 func (r *TransactionRepository) GetTransactionsByUserID(ctx context.Context, userID int64) ([]repository.Transaction, error) {
-	sql := `SELECT transaction_id, from_user_id, to_user_id, amount, order_number, operation_type
-		FROM transactions WHERE from_user_id = $1 OR to_user_id = $1`
+	sql := `SELECT transaction_id, from_user_id, to_user_id, amount, order_number, operation_type, created_at
+		FROM transactions WHERE from_user_id = $1 OR to_user_id = $1 ORDER BY created_at, transaction_id`
 	rows, err := r.db.Query(ctx, sql, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transaction: %v", err)
@@ -140,12 +336,18 @@ func (r *TransactionRepository) GetTransactionsByUserID(ctx context.Context, use
 	transactions := make([]repository.Transaction, 0)
 	for rows.Next() {
 		var transaction repository.Transaction
+		var amount pgtype.Numeric
 		err := rows.Scan(&transaction.TransactionID, &transaction.FromUserID, &transaction.ToUserID,
-			&transaction.Amount, &transaction.OrderNumber, &transaction.OperationType)
+			&amount, &transaction.OrderNumber, &transaction.OperationType, &transaction.Created)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan transaction row: %v", err)
 		}
 
+		transaction.Amount, err = amountFromNumeric(amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert transaction amount: %v", err)
+		}
+
 		transactions = append(transactions, transaction)
 	}
 
@@ -6,6 +6,7 @@ import (
 
 	"github.com/andreevym/gophermart/internal/repository"
 	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/pkg/money"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,7 +21,7 @@ func TestTransactionRepository(t *testing.T) {
 	transaction := repository.Transaction{
 		FromUserID:    1,
 		ToUserID:      1,
-		Amount:        100,
+		Amount:        money.FromPoints(100),
 		OrderNumber:   "821546",
 		OperationType: "Test operation",
 	}
@@ -55,7 +56,7 @@ func TestTransactionRepository(t *testing.T) {
 		TransactionID: createdTransaction.TransactionID,
 		FromUserID:    1,
 		ToUserID:      1,
-		Amount:        200,
+		Amount:        money.FromMinor(20050),
 		OrderNumber:   "821546",
 		OperationType: "Updated operation",
 	}
@@ -71,3 +72,182 @@ func TestTransactionRepository(t *testing.T) {
 	require.Error(t, err)
 	require.EqualError(t, err, postgres.ErrTransactionNotFound.Error())
 }
+
+func TestTransactionRepositoryWithdraw(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	userRepo := postgres.NewUserRepository(testDB)
+	err := userRepo.CreateUser(ctx, repository.User{Username: "withdrawuser", Password: "password"})
+	require.NoError(t, err)
+	user, err := userRepo.GetUserByUsername(ctx, "withdrawuser")
+	require.NoError(t, err)
+
+	repo := postgres.NewTransactionRepository(testDB)
+	_, err = repo.CreateTransaction(ctx, repository.Transaction{
+		FromUserID:    postgres.AccrualUserID,
+		ToUserID:      user.ID,
+		Amount:        money.FromPoints(100),
+		OrderNumber:   "12345678903",
+		OperationType: repository.AccrualOperationType,
+	})
+	require.NoError(t, err)
+
+	// Withdraw more than balance
+	_, err = repo.Withdraw(ctx, user.ID, "2377225624", money.FromMinor(10001))
+	require.ErrorIs(t, err, repository.ErrInsufficientFunds)
+
+	// Withdraw whole balance
+	withdrawTransaction, err := repo.Withdraw(ctx, user.ID, "2377225624", money.FromPoints(100))
+	require.NoError(t, err)
+	require.NotZero(t, withdrawTransaction.TransactionID)
+	require.Equal(t, repository.WithdrawOperationType, withdrawTransaction.OperationType)
+
+	_, err = repo.Withdraw(ctx, user.ID, "2377225624", money.FromMinor(1))
+	require.ErrorIs(t, err, repository.ErrInsufficientFunds)
+
+	account, err := repo.GetAccount(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(0), account.Current)
+	require.Equal(t, money.FromPoints(100), account.Withdrawn)
+
+	// the balance of the system account is computed from the ledger
+	systemAccount, err := repo.GetAccount(ctx, postgres.WithdrawUserID)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, systemAccount.Current, money.FromPoints(100))
+
+	// accounts are maintained with every ledger write
+	drifts, err := repo.ReconcileAccounts(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+}
+
+func TestTransactionRepositoryAccrualAmountOnce(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	userRepo := postgres.NewUserRepository(testDB)
+	err := userRepo.CreateUser(ctx, repository.User{Username: "accrualuser", Password: "password"})
+	require.NoError(t, err)
+	user, err := userRepo.GetUserByUsername(ctx, "accrualuser")
+	require.NoError(t, err)
+
+	orderRepo := postgres.NewOrderRepository(testDB)
+	err = orderRepo.CreateOrder(ctx, repository.Order{Number: "1234567812345670", UserID: user.ID, Status: "NEW"})
+	require.NoError(t, err)
+
+	repo := postgres.NewTransactionRepository(testDB)
+	leaseOrder(t, "1234567812345670", "instance-1")
+	err = repo.AccrualAmount(ctx, user.ID, "1234567812345670", "instance-1", money.FromPoints(50), postgres.ProcessedOrderStatus)
+	require.NoError(t, err)
+
+	// reprocessing the finalized order is a no-op
+	err = repo.AccrualAmount(ctx, user.ID, "1234567812345670", "instance-1", money.FromPoints(50), postgres.ProcessedOrderStatus)
+	require.ErrorIs(t, err, repository.ErrOrderAlreadyFinalized)
+
+	account, err := repo.GetAccount(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.FromPoints(50), account.Current)
+
+	// the ledger rejects a second accrual of the order
+	_, err = repo.CreateTransaction(ctx, repository.Transaction{
+		FromUserID:    postgres.AccrualUserID,
+		ToUserID:      user.ID,
+		Amount:        money.FromPoints(50),
+		OrderNumber:   "1234567812345670",
+		OperationType: repository.AccrualOperationType,
+	})
+	require.ErrorIs(t, err, postgres.ErrTransactionAlreadyExists)
+
+	err = repo.AccrualAmount(ctx, user.ID, "79927398713000", "instance-1", money.FromPoints(50), postgres.ProcessedOrderStatus)
+	require.ErrorIs(t, err, postgres.ErrOrderNotFound)
+}
+
+func TestTransactionRepositoryAccrualAmountRecheck(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	userRepo := postgres.NewUserRepository(testDB)
+	err := userRepo.CreateUser(ctx, repository.User{Username: "recheckeduser", Password: "password"})
+	require.NoError(t, err)
+	user, err := userRepo.GetUserByUsername(ctx, "recheckeduser")
+	require.NoError(t, err)
+
+	orderRepo := postgres.NewOrderRepository(testDB)
+	err = orderRepo.CreateOrder(ctx, repository.Order{Number: "6011111111111117", UserID: user.ID, Status: "NEW"})
+	require.NoError(t, err)
+
+	repo := postgres.NewTransactionRepository(testDB)
+	leaseOrder(t, "6011111111111117", "instance-1")
+	err = repo.AccrualAmount(ctx, user.ID, "6011111111111117", "instance-1", money.FromPoints(50), postgres.ProcessedOrderStatus)
+	require.NoError(t, err)
+
+	// the operator sends the credited order back to the accrual system, which now reports another accrual
+	_, err = orderRepo.RequeueOrders(ctx, repository.OrderFilter{Number: "6011111111111117", Status: "PROCESSED"}, "PROCESSING",
+		repository.AdminAction{AdminUserID: postgres.WithdrawUserID, Action: repository.AdminActionOrderRecheck, Target: "6011111111111117"})
+	require.NoError(t, err)
+	leaseOrder(t, "6011111111111117", "instance-2")
+	err = repo.AccrualAmount(ctx, user.ID, "6011111111111117", "instance-2", money.FromPoints(70), postgres.ProcessedOrderStatus)
+	require.NoError(t, err)
+
+	account, err := repo.GetAccount(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.FromPoints(50), account.Current)
+
+	order, err := orderRepo.GetOrderByNumber(ctx, "6011111111111117")
+	require.NoError(t, err)
+	require.Equal(t, postgres.ProcessedOrderStatus, order.Status)
+	require.Equal(t, money.FromPoints(50), order.Accrual)
+	require.Contains(t, order.LastError, "already credited")
+}
+
+func TestTransactionRepositoryAdjust(t *testing.T) {
+	require.NotNil(t, testDB)
+	ctx := context.Background()
+
+	userRepo := postgres.NewUserRepository(testDB)
+	err := userRepo.CreateUser(ctx, repository.User{Username: "adjusteduser", Password: "password"})
+	require.NoError(t, err)
+	user, err := userRepo.GetUserByUsername(ctx, "adjusteduser")
+	require.NoError(t, err)
+
+	repo := postgres.NewTransactionRepository(testDB)
+	credit, err := repo.Adjust(ctx, repository.Adjustment{
+		UserID:      user.ID,
+		Amount:      money.FromPoints(30),
+		AdminUserID: postgres.WithdrawUserID,
+		Reason:      "lost order",
+		Ticket:      "SUP-1",
+	})
+	require.NoError(t, err)
+	require.NotZero(t, credit.TransactionID)
+
+	// a debit can't make the balance negative
+	_, err = repo.Adjust(ctx, repository.Adjustment{UserID: user.ID, Amount: -money.FromMinor(3001), AdminUserID: postgres.WithdrawUserID, Reason: "r", Ticket: "t"})
+	require.ErrorIs(t, err, repository.ErrInsufficientFunds)
+	_, err = repo.Adjust(ctx, repository.Adjustment{UserID: user.ID, Amount: -money.FromPoints(10), AdminUserID: postgres.WithdrawUserID, Reason: "double credit", Ticket: "SUP-2"})
+	require.NoError(t, err)
+
+	account, err := repo.GetAccount(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.FromPoints(20), account.Current)
+	require.Equal(t, money.Amount(0), account.Withdrawn)
+
+	adjustments, err := repo.GetAdjustmentsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, adjustments, 2)
+	require.Equal(t, money.FromPoints(30), adjustments[0].Amount)
+	require.Equal(t, "lost order", adjustments[0].Reason)
+	require.Equal(t, -money.FromPoints(10), adjustments[1].Amount)
+	require.Equal(t, "SUP-2", adjustments[1].Ticket)
+
+	transactions, err := repo.GetTransactionsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+	require.Equal(t, repository.AdjustmentOperationType, transactions[1].OperationType)
+	require.Equal(t, int64(postgres.AdjustmentUserID), transactions[1].ToUserID)
+
+	drifts, err := repo.ReconcileAccounts(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+}
@@ -2,16 +2,18 @@ package postgres
 
 import (
 	"context"
-	"errors"
 	"fmt"
 
 	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
+// the errors are the same as in the repository package, callers outside the storage match the latter
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound      = repository.ErrUserNotFound
+	ErrUserAlreadyExists = repository.ErrUserAlreadyExists
 )
 
 type UserRepository struct {
@@ -22,10 +24,15 @@ func NewUserRepository(db *pgxpool.Pool) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// CreateUser returns ErrUserAlreadyExists if the login differs from an existing one only in case,
+// the unique index keeps concurrent registrations of the same login from both succeeding
 func (r *UserRepository) CreateUser(ctx context.Context, user repository.User) error {
 	sql := `INSERT INTO users (username, password) VALUES ($1, $2) RETURNING id`
 	_, err := r.db.Exec(ctx, sql, user.Username, user.Password)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrUserAlreadyExists
+		}
 		return fmt.Errorf("failed to create user: %v", err)
 	}
 
@@ -33,8 +40,8 @@ func (r *UserRepository) CreateUser(ctx context.Context, user repository.User) e
 }
 
 func (r *UserRepository) UpdateUser(ctx context.Context, user repository.User) error {
-	sql := `UPDATE users SET username = $1, password = $2 WHERE id = $3`
-	_, err := r.db.Exec(ctx, sql, user.Username, user.Password, user.ID)
+	sql := `UPDATE users SET username = $1, password = $2, deleted_at = $3 WHERE id = $4`
+	_, err := r.db.Exec(ctx, sql, user.Username, user.Password, user.Deleted, user.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update user: %v", err)
 	}
@@ -53,29 +60,53 @@ func (r *UserRepository) DeleteUser(ctx context.Context, userID int64) error {
 }
 
 func (r *UserRepository) GetUserByID(ctx context.Context, userID int64) (*repository.User, error) {
-	sql := `SELECT id, username, password FROM users WHERE id = $1`
+	sql := `SELECT id, username, password, created_at, deleted_at, roles FROM users WHERE id = $1`
 	var user repository.User
-	err := r.db.QueryRow(ctx, sql, userID).Scan(&user.ID, &user.Username, &user.Password)
+	var createdAt, deletedAt pgtype.Timestamptz
+	err := r.db.QueryRow(ctx, sql, userID).Scan(&user.ID, &user.Username, &user.Password, &createdAt, &deletedAt, &user.Roles)
 	if err != nil {
 		if err.Error() == pgx.ErrNoRows.Error() {
 			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %v", err)
 	}
+	user.Created = timeFromTimestamptz(createdAt)
+	user.Deleted = timeFromTimestamptz(deletedAt)
 
 	return &user, nil
 }
 
 func (r *UserRepository) GetUserByUsername(ctx context.Context, username string) (*repository.User, error) {
-	sql := `SELECT id, username, password FROM users WHERE username = $1`
+	// logins registered before they were normalized may have upper case letters
+	sql := `SELECT id, username, password, created_at, deleted_at, roles FROM users WHERE lower(username) = lower($1)`
 	var user repository.User
-	err := r.db.QueryRow(ctx, sql, username).Scan(&user.ID, &user.Username, &user.Password)
+	var createdAt, deletedAt pgtype.Timestamptz
+	err := r.db.QueryRow(ctx, sql, username).Scan(&user.ID, &user.Username, &user.Password, &createdAt, &deletedAt, &user.Roles)
 	if err != nil {
 		if err.Error() == pgx.ErrNoRows.Error() {
 			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user by username %s: %v", username, err)
 	}
+	user.Created = timeFromTimestamptz(createdAt)
+	user.Deleted = timeFromTimestamptz(deletedAt)
 
 	return &user, nil
 }
+
+func (r *UserRepository) SetUserRoles(ctx context.Context, userID int64, roles []string) error {
+	// a nil slice is encoded as NULL, the column holds an empty array instead
+	if roles == nil {
+		roles = []string{}
+	}
+	sql := `UPDATE users SET roles = $1 WHERE id = $2`
+	tag, err := r.db.Exec(ctx, sql, roles, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set roles of user %d: %v", userID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
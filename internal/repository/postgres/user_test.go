@@ -3,6 +3,7 @@ package postgres_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/andreevym/gophermart/internal/repository"
 	"github.com/andreevym/gophermart/internal/repository/postgres"
@@ -33,12 +34,30 @@ func TestUserRepository(t *testing.T) {
 	require.Equal(t, user.Username, retrievedUserByUsername.Username)
 	require.Equal(t, user.Password, retrievedUserByUsername.Password)
 
+	// Test logins are case-insensitive
+	retrievedUserByUsername, err = repo.GetUserByUsername(context.Background(), "TestUser")
+	require.NoError(t, err)
+	require.Equal(t, user.Username, retrievedUserByUsername.Username)
+	err = repo.CreateUser(context.Background(), repository.User{Username: "TestUser", Password: "password"})
+	require.ErrorIs(t, err, postgres.ErrUserAlreadyExists)
+
 	// Test GetUserByID
 	retrievedUserByID, err := repo.GetUserByID(context.Background(), retrievedUserByUsername.ID)
 	require.NoError(t, err)
 	require.NotNil(t, retrievedUserByID)
 	require.Equal(t, retrievedUserByUsername, retrievedUserByID)
 
+	// Test SetUserRoles
+	err = repo.SetUserRoles(context.Background(), retrievedUserByUsername.ID, []string{repository.RoleSupport})
+	require.NoError(t, err)
+	retrievedUserByID, err = repo.GetUserByID(context.Background(), retrievedUserByUsername.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{repository.RoleSupport}, retrievedUserByID.Roles)
+	err = repo.SetUserRoles(context.Background(), retrievedUserByUsername.ID, nil)
+	require.NoError(t, err)
+	err = repo.SetUserRoles(context.Background(), -1, nil)
+	require.ErrorIs(t, err, postgres.ErrUserNotFound)
+
 	// Test UpdateUser
 	userToUpdate := repository.User{
 		ID:       retrievedUserByUsername.ID,
@@ -48,6 +67,15 @@ func TestUserRepository(t *testing.T) {
 	err = repo.UpdateUser(context.Background(), userToUpdate)
 	require.NoError(t, err)
 
+	// Test UpdateUser anonymizes the user
+	deletedAt := time.Now()
+	userToUpdate.Deleted = &deletedAt
+	err = repo.UpdateUser(context.Background(), userToUpdate)
+	require.NoError(t, err)
+	retrievedUserByID, err = repo.GetUserByID(context.Background(), retrievedUserByUsername.ID)
+	require.NoError(t, err)
+	require.NotNil(t, retrievedUserByID.Deleted)
+
 	// Test DeleteUser
 	err = repo.DeleteUser(context.Background(), retrievedUserByUsername.ID)
 	require.NoError(t, err)
@@ -57,3 +85,16 @@ func TestUserRepository(t *testing.T) {
 	require.Error(t, err)
 	require.EqualError(t, err, postgres.ErrUserNotFound.Error())
 }
+
+func TestSystemUsersAreDisabled(t *testing.T) {
+	require.NotNil(t, testDB)
+	repo := postgres.NewUserRepository(testDB)
+
+	// the system accounts were seeded with a published password, nobody can log in as them
+	for _, id := range []int64{postgres.WithdrawUserID, postgres.AccrualUserID, postgres.AdjustmentUserID} {
+		user, err := repo.GetUserByID(context.Background(), id)
+		require.NoError(t, err)
+		require.NotNil(t, user.Deleted)
+		require.Empty(t, user.Password)
+	}
+}
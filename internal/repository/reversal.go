@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/andreevym/gophermart/pkg/money"
+)
+
+// Reversal returns the points of a withdrawal to the user, for example when the store cancels the purchase,
+// it's recorded with a reversal transaction from the withdraw system account linked to the withdraw transaction.
+type Reversal struct {
+	// TransactionID is the compensating ledger entry, filled by database while insert
+	TransactionID int64 `json:"transactionId"`
+	// WithdrawalTransactionID is the reversed withdraw transaction
+	WithdrawalTransactionID int64        `json:"withdrawalTransactionId"`
+	UserID                  int64        `json:"userId"`
+	Amount                  money.Amount `json:"amount"`
+	OrderNumber             string       `json:"order_number"`
+	// ActorUserID is the operator or the store account which requested the reversal
+	ActorUserID int64  `json:"actorUserId"`
+	Reason      string `json:"reason"`
+	// Created is the combined date and time, filled by database while insert
+	Created time.Time `json:"created,omitempty"`
+}
+
+// Withdrawal is a withdraw transaction with its reversal, Reversal is nil while the withdrawal is not reversed.
+type Withdrawal struct {
+	Transaction
+	Reversal *Reversal `json:"reversal,omitempty"`
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned when the user has no active session with the id.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a login of a user on a device, it lives until logout, revocation or expiry of its refresh tokens.
+// The session id is the family id of its refresh tokens.
+type Session struct {
+	ID        string
+	UserID    int64
+	Device    string
+	UserAgent string
+	IP        string
+	Created   time.Time
+	// LastSeenAt is updated on login and on every token refresh
+	LastSeenAt time.Time
+	RevokedAt  *time.Time
+}
+
+// SessionRepository stores the sessions of users.
+//
+//go:generate mockgen -source=session.go -destination=./mock/session.go -package=mock
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session Session) error
+	// TouchSession updates the last seen time and address of the session.
+	TouchSession(ctx context.Context, sessionID string, ip string) error
+	// GetActiveSessionsByUserID returns the sessions of the user which are not revoked and have an unexpired refresh token,
+	// the most recently seen first.
+	GetActiveSessionsByUserID(ctx context.Context, userID int64) ([]Session, error)
+	// RevokeSession revokes the active session of the user and its refresh tokens,
+	// ErrSessionNotFound is returned if the user has no such active session.
+	RevokeSession(ctx context.Context, userID int64, sessionID string) error
+	// RevokeOtherSessions revokes all active sessions of the user except keepSessionID and returns their number.
+	RevokeOtherSessions(ctx context.Context, userID int64, keepSessionID string) (int64, error)
+	// DeleteStaleSessions deletes the expired refresh tokens and revoked access tokens
+	// and the sessions not seen since seenBefore which have no refresh tokens left, the number of deleted sessions is returned.
+	DeleteStaleSessions(ctx context.Context, seenBefore time.Time) (int64, error)
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for an unknown, expired or revoked refresh token.
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again,
+	// the token may be stolen, so its whole family and session are revoked.
+	ErrRefreshTokenReused = errors.New("refresh token is reused")
+)
+
+// RefreshToken is a server-side record of a refresh token, the token itself is never stored.
+type RefreshToken struct {
+	// TokenHash is the hex encoded SHA-256 of the token
+	TokenHash string
+	UserID    int64
+	// FamilyID is shared by all tokens rotated from the same login, it's the session id
+	FamilyID  string
+	ExpiresAt time.Time
+	Created   time.Time
+	// UsedAt is set when the token is rotated
+	UsedAt *time.Time
+	// RevokedAt is set when the family of the token is revoked
+	RevokedAt *time.Time
+}
+
+// TokenRepository stores refresh tokens and revoked access tokens.
+//
+//go:generate mockgen -source=token.go -destination=./mock/token.go -package=mock
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	// RotateRefreshToken marks the token with tokenHash used and stores next in the same family with one database transaction,
+	// the used token is returned. ErrRefreshTokenInvalid is returned for an unknown, expired or revoked token,
+	// ErrRefreshTokenReused for an already used one, in this case the family and its session are revoked.
+	RotateRefreshToken(ctx context.Context, tokenHash string, next RefreshToken) (*RefreshToken, error)
+
+	// RevokeAccessToken stores the access token id until the token expires.
+	RevokeAccessToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error
+	// IsAccessTokenRevoked reports whether the access token or the session it was issued for is revoked.
+	IsAccessTokenRevoked(ctx context.Context, jti string, familyID string) (bool, error)
+}
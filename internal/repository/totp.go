@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// TOTP is the second factor of a user.
+type TOTP struct {
+	UserID int64
+	// Secret is the base32 encoded secret shared with the authenticator app
+	Secret  string
+	Created time.Time
+	// EnabledAt is nil until the enrollment is confirmed with a code
+	EnabledAt *time.Time
+	// LastUsedStep is the time step of the last accepted code
+	LastUsedStep int64
+}
+
+// TOTPRepository stores TOTP secrets and recovery codes of users.
+//
+//go:generate mockgen -source=totp.go -destination=./mock/totp.go -package=mock
+type TOTPRepository interface {
+	// GetTOTP returns nil if the user has not enrolled.
+	GetTOTP(ctx context.Context, userID int64) (*TOTP, error)
+	// SaveTOTP stores a not yet enabled secret of the user with its recovery codes,
+	// a previous not confirmed enrollment is replaced.
+	SaveTOTP(ctx context.Context, userID int64, secret string, recoveryCodeHashes []string) error
+	EnableTOTP(ctx context.Context, userID int64) error
+	// DeleteTOTP removes the secret and the recovery codes of the user.
+	DeleteTOTP(ctx context.Context, userID int64) error
+	// UseTOTPStep stores the step of an accepted code, false is returned if a code of this or a later step
+	// has already been accepted, so the same code can't be replayed.
+	UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error)
+	// UseRecoveryCode marks a recovery code used, false is returned for an unknown or already used code.
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error)
+}
@@ -2,21 +2,42 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
+
+	"github.com/andreevym/gophermart/pkg/money"
 )
 
 const (
-	WithdrawOperationType = "withdraw"
-	AccrualOperationType  = "accrual"
+	WithdrawOperationType   = "withdraw"
+	AccrualOperationType    = "accrual"
+	AdjustmentOperationType = "adjustment"
+	ReversalOperationType   = "reversal"
+	// DuplicateAccrualOperationType marks a second accrual of an order credited before accruals became unique,
+	// it is taken back by a DuplicateAccrualReversalOperationType entry, both are written only by migrations
+	DuplicateAccrualOperationType         = "accrual_duplicate"
+	DuplicateAccrualReversalOperationType = "accrual_duplicate_reversal"
+)
+
+var (
+	// ErrTransactionNotFound is returned when there is no transaction with the id.
+	ErrTransactionNotFound = errors.New("transaction not found")
+	// ErrInsufficientFunds is returned when a user balance is lower than the requested amount.
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrOrderAlreadyFinalized is returned when the accrual of an order is already stored,
+	// the order has a final status and the user is already credited.
+	ErrOrderAlreadyFinalized = errors.New("order already has a final status")
+	// ErrWithdrawalAlreadyReversed is returned when the points of a withdrawal are already returned.
+	ErrWithdrawalAlreadyReversed = errors.New("withdrawal already reversed")
 )
 
 type Transaction struct {
-	TransactionID int64   `json:"transactionId"`
-	FromUserID    int64   `json:"fromUserId"`
-	ToUserID      int64   `json:"toUserId"`
-	Amount        float32 `json:"amount"`
-	OrderNumber   string  `json:"order_number"`
-	OperationType string  `json:"operationType"`
+	TransactionID int64        `json:"transactionId"`
+	FromUserID    int64        `json:"fromUserId"`
+	ToUserID      int64        `json:"toUserId"`
+	Amount        money.Amount `json:"amount"`
+	OrderNumber   string       `json:"order_number"`
+	OperationType string       `json:"operationType"`
 	// Created is the combined date and time, filled by database while insert
 	Created time.Time `json:"created,omitempty"`
 }
@@ -31,7 +52,44 @@ type TransactionRepository interface {
 
 	GetTransactionByID(ctx context.Context, transactionID int64) (*Transaction, error)
 	GetTransactionsByUserIDAndOperationType(ctx context.Context, userID int64, operationType string) ([]Transaction, error)
+	// GetTransactionsByUserID returns the ledger of the user, the oldest transactions first.
 	GetTransactionsByUserID(ctx context.Context, userID int64) ([]Transaction, error)
 
-	AccrualAmount(ctx context.Context, userID int64, orderNumber string, accrual float32, orderStatus string) error
+	// AccrualAmount credits the user with the order accrual and sets the order status,
+	// an order is credited only once, ErrOrderAlreadyFinalized is returned for an order in a final status.
+	// An already credited order sent back to processing keeps its accrual.
+	// ErrOrderLeaseLost is returned if the order is not leased to owner.
+	AccrualAmount(ctx context.Context, userID int64, orderNumber string, owner string, accrual money.Amount, orderStatus string) error
+	// Withdraw atomically checks the user balance and inserts a withdraw transaction,
+	// ErrInsufficientFunds is returned if the available balance is lower than amount.
+	Withdraw(ctx context.Context, userID int64, orderNumber string, amount money.Amount) (*Transaction, error)
+	// Adjust records the adjustment with its transaction with one database transaction, a debit is checked
+	// against the available user balance, ErrInsufficientFunds is returned if the balance is lower.
+	Adjust(ctx context.Context, adjustment Adjustment) (*Adjustment, error)
+	// GetAdjustmentsByUserID returns the adjustments of the user, the oldest first.
+	GetAdjustmentsByUserID(ctx context.Context, userID int64) ([]Adjustment, error)
+	// ReverseWithdrawal returns the points of the withdraw transaction reversal.WithdrawalTransactionID to the user,
+	// ErrWithdrawalAlreadyReversed is returned if the withdrawal is already reversed, even by a concurrent call.
+	ReverseWithdrawal(ctx context.Context, reversal Reversal) (*Reversal, error)
+	// GetWithdrawalsByUserID returns the withdrawals of the user with their reversals, the newest first.
+	GetWithdrawalsByUserID(ctx context.Context, userID int64) ([]Withdrawal, error)
+	// GetWithdrawalsByOrderNumber returns the withdrawals in payment for the order with their reversals, the oldest first.
+	GetWithdrawalsByOrderNumber(ctx context.Context, orderNumber string) ([]Withdrawal, error)
+
+	// PlaceHold reserves hold.Amount of the user balance until hold.Expires,
+	// ErrInsufficientFunds is returned if the available balance is lower.
+	PlaceHold(ctx context.Context, hold Hold) (*Hold, error)
+	// CaptureHold withdraws the held points with a withdraw transaction, ErrHoldNotFound is returned
+	// if the user has no such hold and ErrHoldNotActive if the hold is captured, released or expired.
+	CaptureHold(ctx context.Context, userID int64, holdID int64) (*Hold, error)
+	// ReleaseHold cancels the reservation, the errors are the same as of CaptureHold.
+	ReleaseHold(ctx context.Context, userID int64, holdID int64) (*Hold, error)
+	// ExpireHolds marks held holds whose expiry time has come expired and returns their number.
+	ExpireHolds(ctx context.Context) (int64, error)
+
+	// GetAccount returns the materialized balance of the user, a user without ledger history has zero balance.
+	GetAccount(ctx context.Context, userID int64) (*Account, error)
+	// ReconcileAccounts recomputes balances from the ledger and returns accounts which differ,
+	// if fix is true the accounts are overwritten with the recomputed values.
+	ReconcileAccounts(ctx context.Context, fix bool) ([]AccountDrift, error)
 }
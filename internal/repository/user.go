@@ -2,19 +2,37 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+var (
+	// ErrUserNotFound is returned when there is no user with the id or login.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserAlreadyExists is returned when the login, compared case-insensitively, is taken by another user.
+	ErrUserAlreadyExists = errors.New("user already exists")
+)
+
+const (
+	// RoleSupport lets support staff look up users, their orders and ledger
+	RoleSupport = "support"
+	// RoleAdmin lets operators do everything support staff does and change data of users
+	RoleAdmin = "admin"
+	// RoleStore is granted to the account of the store, it lets the store return points of cancelled purchases
+	RoleStore = "store"
+)
+
 // User represents a user entity in the application.
 type User struct {
-	ID       int64      `json:"id"`
-	Username string     `json:"username"`
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	// Password is the encoded password hash, see pkg/password
 	Password string     `json:"password"`
 	Created  *time.Time `json:"created_at"`
-}
-
-func (u User) IsValidPassword(password string) bool {
-	return u.Password == password
+	// Deleted is set when the user is anonymized, such a user can't log in
+	Deleted *time.Time `json:"deleted_at"`
+	// Roles are granted to operators, regular users have none
+	Roles []string `json:"roles"`
 }
 
 // UserRepository defines the interface for user repository operations.
@@ -27,4 +45,7 @@ type UserRepository interface {
 
 	GetUserByID(ctx context.Context, userID int64) (*User, error)
 	GetUserByUsername(ctx context.Context, username string) (*User, error)
+
+	// SetUserRoles replaces the roles of the user, ErrUserNotFound of the implementation is returned for an unknown user.
+	SetUserRoles(ctx context.Context, userID int64, roles []string) error
 }
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultHoldExpiryInterval is the delay between sweeps for stale holds unless another interval is set
+const DefaultHoldExpiryInterval = time.Minute
+
+// HoldExpiryScheduler marks stale holds expired in the background. Every instance may run it,
+// the sweep is a single idempotent update.
+type HoldExpiryScheduler struct {
+	transactionService *services.TransactionService
+	interval           time.Duration
+	stop               chan struct{}
+	done               chan struct{}
+}
+
+func NewHoldExpiryScheduler(transactionService *services.TransactionService, interval time.Duration) *HoldExpiryScheduler {
+	if interval <= 0 {
+		interval = DefaultHoldExpiryInterval
+	}
+	return &HoldExpiryScheduler{
+		transactionService: transactionService,
+		interval:           interval,
+		stop:               make(chan struct{}),
+		done:               make(chan struct{}),
+	}
+}
+
+func (s *HoldExpiryScheduler) Run() {
+	go s.expireByInterval()
+}
+
+func (s *HoldExpiryScheduler) expireByInterval() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		_, err := s.transactionService.ExpireHolds(ctx)
+		cancel()
+		if err != nil {
+			logger.Logger().Error("expire holds", zap.Error(err))
+		}
+	}
+}
+
+// Shutdown tells the worker to stop and waits until the sweep in progress has finished.
+func (s *HoldExpiryScheduler) Shutdown() {
+	close(s.stop)
+	<-s.done
+}
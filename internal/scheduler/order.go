@@ -1,88 +1,185 @@
 package scheduler
 
 import (
+	"context"
 	"fmt"
+	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/andreevym/gophermart/internal/accrual"
+	"github.com/andreevym/gophermart/internal/repository"
 	"github.com/andreevym/gophermart/internal/services"
 	"github.com/andreevym/gophermart/pkg/logger"
 	"go.uber.org/zap"
 )
 
+// DefaultWorkers is the number of orders processed concurrently unless another number is set
+const DefaultWorkers = 4
+
 type AccrualScheduler struct {
-	orderService     *services.OrderService
-	accrualService   *accrual.AccrualService
-	stop             chan struct{}
-	done             chan struct{}
-	pollOrdersDelay  time.Duration
-	maxOrderAttempts int
+	orderService    *services.OrderService
+	accrualService  *accrual.AccrualService
+	stop            chan struct{}
+	done            chan struct{}
+	pollOrdersDelay time.Duration
+	// workers caps the number of orders processed concurrently,
+	// the rate of requests is additionally limited by accrualService
+	workers int
+	// batchSize limits the number of orders claimed at once, the workers process them before the lease expires
+	batchSize int
+	// newOrders wakes the scheduler up before the next tick, nil if orders are only polled
+	newOrders <-chan struct{}
 }
 
 func NewAccrualScheduler(
 	accrualService *accrual.AccrualService,
 	orderService *services.OrderService,
 	pollOrdersDelay time.Duration,
-	maxOrderAttempts int,
+	workers int,
+	newOrders <-chan struct{},
 ) *AccrualScheduler {
 	var (
 		stop = make(chan struct{}) // tells the goroutine to stop
 		done = make(chan struct{}) // tells us that the goroutine exited
 	)
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
 	s := &AccrualScheduler{
-		accrualService:   accrualService,
-		orderService:     orderService,
-		stop:             stop,
-		done:             done,
-		pollOrdersDelay:  pollOrdersDelay,
-		maxOrderAttempts: maxOrderAttempts,
+		accrualService:  accrualService,
+		orderService:    orderService,
+		stop:            stop,
+		done:            done,
+		pollOrdersDelay: pollOrdersDelay,
+		workers:         workers,
+		batchSize:       orderService.ClaimBatchSize(workers),
+		newOrders:       newOrders,
 	}
 
 	return s
 }
 
 func (s *AccrualScheduler) Run() {
-	go s.processingByDelay(s.done, s.stop, s.pollOrdersDelay, s.maxOrderAttempts)
+	go s.processingByDelay(s.done, s.stop, s.pollOrdersDelay)
 }
 
-func (s *AccrualScheduler) processingByDelay(done chan struct{}, stop chan struct{}, pollOrdersDelay time.Duration, maxOrderAttempts int) {
+// processingByDelay syncs orders on every new order notification and every pollOrdersDelay,
+// the periodic sweep picks up retries which became due and orders whose notification was lost
+func (s *AccrualScheduler) processingByDelay(done chan struct{}, stop chan struct{}, pollOrdersDelay time.Duration) {
 	defer close(done)
-	go func() {
-		ticker := time.NewTicker(pollOrdersDelay)
-		defer ticker.Stop()
+	ticker := time.NewTicker(pollOrdersDelay)
+	defer ticker.Stop()
+	for {
+		var t time.Time
+		select {
+		case <-stop:
+			return
+		case t = <-ticker.C:
+		case <-s.newOrders:
+			t = time.Now()
+		}
+
+		// a full batch means more orders are due, they are claimed without waiting for the next tick
 		for {
-			select {
-			case <-stop:
-				return
-			case t := <-ticker.C:
-				if err := s.syncOrders(t, maxOrderAttempts); err != nil {
-					logger.Logger().Error("sync orders", zap.Error(err))
-				}
+			claimed, err := s.syncOrders(t)
+			if err != nil {
+				logger.Logger().Error("sync orders", zap.Error(err))
+			}
+			if claimed < s.batchSize || isStopped(stop) {
+				break
 			}
+			t = time.Now()
 		}
-	}()
+	}
+}
+
+func isStopped(stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
 }
 
-// syncOrders sync new orders statuses and accrual with accrual service
-func (s *AccrualScheduler) syncOrders(t time.Time, maxOrderAttempts int) error {
+// syncOrders sync statuses and accrual of due orders with accrual service,
+// the orders are fanned out to the workers and syncOrders returns when all of them are processed,
+// so the next tick never picks up an order which is still in progress.
+// It returns the number of claimed orders.
+func (s *AccrualScheduler) syncOrders(t time.Time) (int, error) {
 	logger.Logger().Debug("poll orders", zap.String("ticker", t.String()))
-	orders, err := s.orderService.GetOrdersByStatus(services.NewOrderStatus)
+	// the accrual system has asked to wait, orders stay due until the pause ends
+	if pausedUntil := s.accrualService.PausedUntil(); t.Before(pausedUntil) {
+		logger.Logger().Debug("accrual service requests are paused", zap.Time("pausedUntil", pausedUntil))
+		return 0, nil
+	}
+	// Shutdown cancels the requests in progress, so it never waits for a hung accrual system
+	// or for the end of a rate limit pause
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	orders, err := s.orderService.ClaimDueOrders(ctx, s.batchSize)
 	if err != nil {
-		logger.Logger().Error("get orders by status", zap.Error(err))
-		return fmt.Errorf("get orders by status %w", err)
+		logger.Logger().Error("claim due orders", zap.Error(err))
+		return 0, fmt.Errorf("claim due orders %w", err)
 	}
+
+	queue := make(chan repository.Order)
+	wg := sync.WaitGroup{}
+	for i := 0; i < s.workers && i < len(orders); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for order := range queue {
+				s.processOrder(ctx, order)
+			}
+		}()
+	}
+
+	// orders not dispatched before Shutdown are picked up by any instance when their lease expires
+dispatch:
 	for _, order := range orders {
-		err = s.orderService.OrderProcessingWithRetry(order, maxOrderAttempts)
-		if err != nil {
-			logger.Logger().Error("RetryOrderProcessing", zap.Error(err))
-			panic(err.Error())
+		select {
+		case <-s.stop:
+			break dispatch
+		case queue <- order:
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return len(orders), nil
+}
+
+// processOrder processes one order, a failure or a panic is logged and never stops other orders
+func (s *AccrualScheduler) processOrder(ctx context.Context, order repository.Order) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Logger().Error(
+				"order processing panicked",
+				zap.String("orderNumber", order.Number),
+				zap.Any("panic", r),
+				zap.ByteString("stack", debug.Stack()),
+			)
 		}
+	}()
+
+	err := s.orderService.OrderProcessingWithRetry(ctx, order)
+	if err != nil {
+		logger.Logger().Error("RetryOrderProcessing", zap.String("orderNumber", order.Number), zap.Error(err))
 	}
-	return nil
 }
 
-// Shutdown tells the worker to stop
-// and waits until it has finished.
+// Shutdown tells the worker to stop and waits until it has finished, the orders in progress are interrupted
+// and picked up again by any instance when their lease expires.
 func (s *AccrualScheduler) Shutdown() {
 	close(s.stop)
 	<-s.done
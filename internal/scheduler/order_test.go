@@ -0,0 +1,150 @@
+package scheduler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/accrual"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncOrdersWorkerPool(t *testing.T) {
+	const workers = 3
+
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			seen := atomic.LoadInt32(&maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		// the order is not registered yet
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	orders := make([]repository.Order, 0, 10)
+	for i := 0; i < cap(orders); i++ {
+		orders = append(orders, repository.Order{
+			Number:     strconv.Itoa(i),
+			UserID:     1,
+			Status:     services.NewOrderStatus,
+			UploadedAt: time.Now(),
+		})
+	}
+
+	ctrl := gomock.NewController(t)
+	orderRepository := mock.NewMockOrderRepository(ctrl)
+	accrualService := accrual.NewAccrualService(server.URL)
+	orderService := services.NewOrderService(nil, orderRepository, accrualService)
+	orderRepository.EXPECT().
+		ClaimOrders(gomock.Any(), orderService.InstanceID, gomock.Any(), orderService.ClaimBatchSize(workers), orderService.LeaseDuration).
+		Return(orders, nil)
+	orderRepository.EXPECT().
+		ScheduleOrderRetry(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil).
+		Times(len(orders))
+
+	s := NewAccrualScheduler(accrualService, orderService, time.Hour, workers, nil)
+
+	claimed, err := s.syncOrders(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, len(orders), claimed)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&inFlight), "all orders must be processed on return")
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(workers))
+	assert.Greater(t, atomic.LoadInt32(&maxInFlight), int32(1), "orders must be processed concurrently")
+}
+
+func TestSyncOrdersRecoversPanic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	orderRepository := mock.NewMockOrderRepository(ctrl)
+	orderRepository.EXPECT().ClaimOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]repository.Order{
+		{Number: "1", Status: services.NewOrderStatus},
+		{Number: "2", Status: services.NewOrderStatus},
+	}, nil)
+
+	// the nil accrual service makes every order processing panic
+	orderService := services.NewOrderService(nil, orderRepository, nil)
+	s := NewAccrualScheduler(accrual.NewAccrualService(""), orderService, time.Hour, 2, nil)
+
+	_, err := s.syncOrders(time.Now())
+	require.NoError(t, err)
+}
+
+func TestAccrualSchedulerWakesUpOnNewOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	orderRepository := mock.NewMockOrderRepository(ctrl)
+	claimed := make(chan struct{})
+	orderRepository.EXPECT().
+		ClaimOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_, _, _, _, _ interface{}) ([]repository.Order, error) {
+			close(claimed)
+			return nil, nil
+		})
+
+	newOrders := make(chan struct{}, 1)
+	orderService := services.NewOrderService(nil, orderRepository, nil)
+	s := NewAccrualScheduler(accrual.NewAccrualService(""), orderService, time.Hour, 1, newOrders)
+	s.Run()
+	defer s.Shutdown()
+
+	newOrders <- struct{}{}
+	select {
+	case <-claimed:
+	case <-time.After(time.Second):
+		t.Fatal("orders are not claimed on new order notification")
+	}
+}
+
+func TestSyncOrdersShutdownInterruptsHungRequest(t *testing.T) {
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctrl := gomock.NewController(t)
+	orderRepository := mock.NewMockOrderRepository(ctrl)
+	orderRepository.EXPECT().ClaimOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]repository.Order{
+		{Number: "1", Status: services.NewOrderStatus, UploadedAt: time.Now()},
+	}, nil)
+
+	accrualService := accrual.NewAccrualService(server.URL)
+	orderService := services.NewOrderService(nil, orderRepository, accrualService)
+	s := NewAccrualScheduler(accrualService, orderService, time.Hour, 1, nil)
+
+	synced := make(chan struct{})
+	go func() {
+		defer close(synced)
+		_, err := s.syncOrders(time.Now())
+		assert.NoError(t, err)
+	}()
+
+	<-requested
+	// the interrupted order keeps its lease, no retry is scheduled
+	close(s.stop)
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown waits for the hung accrual request")
+	}
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultSessionCleanupInterval is the delay between sweeps for stale sessions unless another interval is set
+const DefaultSessionCleanupInterval = time.Hour
+
+// SessionCleanupScheduler deletes expired refresh tokens and stale sessions in the background. Every instance may run it,
+// the sweep only deletes rows which are never used again.
+type SessionCleanupScheduler struct {
+	authService *services.AuthService
+	interval    time.Duration
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func NewSessionCleanupScheduler(authService *services.AuthService, interval time.Duration) *SessionCleanupScheduler {
+	if interval <= 0 {
+		interval = DefaultSessionCleanupInterval
+	}
+	return &SessionCleanupScheduler{
+		authService: authService,
+		interval:    interval,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (s *SessionCleanupScheduler) Run() {
+	go s.cleanupByInterval()
+}
+
+func (s *SessionCleanupScheduler) cleanupByInterval() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		_, err := s.authService.DeleteStaleSessions(ctx)
+		cancel()
+		if err != nil {
+			logger.Logger().Error("delete stale sessions", zap.Error(err))
+		}
+	}
+}
+
+// Shutdown tells the worker to stop and waits until the sweep in progress has finished.
+func (s *SessionCleanupScheduler) Shutdown() {
+	close(s.stop)
+	<-s.done
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
+	"go.uber.org/zap"
+)
+
+const (
+	maxAdjustmentReasonLength = 1000
+	maxAdjustmentTicketLength = 100
+)
+
+var (
+	ErrAdjustmentInvalid           = errors.New("invalid adjustment")
+	ErrAdjustmentInsufficientFunds = errors.New("insufficient funds for adjustment")
+)
+
+// Adjust credits (a positive amount) or debits (a negative amount) the user balance on behalf of the operator,
+// the reason and the ticket of the support request are required and kept with the ledger entry.
+// A debit can't make the balance negative, ErrAdjustmentInsufficientFunds is returned instead.
+func (s TransactionService) Adjust(
+	ctx context.Context,
+	adminUserID int64,
+	userID int64,
+	amount money.Amount,
+	reason string,
+	ticket string,
+) (*repository.Adjustment, error) {
+	reason, ticket = strings.TrimSpace(reason), strings.TrimSpace(ticket)
+	switch {
+	case amount == 0:
+		return nil, fmt.Errorf("%w: amount must not be zero", ErrAdjustmentInvalid)
+	case reason == "":
+		return nil, fmt.Errorf("%w: reason is required", ErrAdjustmentInvalid)
+	case ticket == "":
+		return nil, fmt.Errorf("%w: ticket is required", ErrAdjustmentInvalid)
+	case utf8.RuneCountInString(reason) > maxAdjustmentReasonLength:
+		return nil, fmt.Errorf("%w: reason must be at most %d characters long", ErrAdjustmentInvalid, maxAdjustmentReasonLength)
+	case utf8.RuneCountInString(ticket) > maxAdjustmentTicketLength:
+		return nil, fmt.Errorf("%w: ticket must be at most %d characters long", ErrAdjustmentInvalid, maxAdjustmentTicketLength)
+	}
+
+	adjustment, err := s.transactionRepository.Adjust(ctx, repository.Adjustment{
+		UserID:      userID,
+		Amount:      amount,
+		AdminUserID: adminUserID,
+		Reason:      reason,
+		Ticket:      ticket,
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrInsufficientFunds) {
+			return nil, ErrAdjustmentInsufficientFunds
+		}
+		return nil, fmt.Errorf("transaction storage: adjust: %w", err)
+	}
+
+	logger.Logger().Info("balance is adjusted",
+		zap.Int64("userID", userID),
+		zap.Int64("adminUserID", adminUserID),
+		zap.String("amount", amount.String()),
+		zap.String("ticket", ticket),
+		zap.Int64("transactionID", adjustment.TransactionID),
+	)
+	return adjustment, nil
+}
+
+// GetAdjustments returns the adjustments of the user balance, the oldest first
+func (s TransactionService) GetAdjustments(ctx context.Context, userID int64) ([]repository.Adjustment, error) {
+	adjustments, err := s.transactionRepository.GetAdjustmentsByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get adjustments by user id '%d': %w", userID, err)
+	}
+	return adjustments, nil
+}
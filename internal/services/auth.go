@@ -2,171 +2,491 @@ package services
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/x509"
-	"encoding/pem"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
 	"strconv"
+	"time"
 
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/keyring"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
 	"github.com/golang-jwt/jwt"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultAccessTokenTTL is the lifetime of an access token, a revoked token is rejected earlier
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// DefaultRefreshTokenTTL is the lifetime of a refresh token, every refresh issues a new one
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	// DefaultMFATokenTTL is the time the user has to enter the second factor after the password
+	DefaultMFATokenTTL = 5 * time.Minute
+
+	// mfaTokenType is the typ claim of a token issued between the password and the second factor,
+	// such a token is never accepted as an access token
+	mfaTokenType = "mfa"
 )
 
 // AuthService represents a concrete implementation of the AuthService interface.
 type AuthService struct {
-	userService  *UserService
-	jwtSecretKey string
+	userService       *UserService
+	tokenRepository   repository.TokenRepository
+	sessionRepository repository.SessionRepository
+	keyRing           *keyring.KeyRing
+	// TOTPRepository stores the TOTP secrets of users, 2FA can't be enabled if it's nil
+	TOTPRepository repository.TOTPRepository
+	// LoginThrottle locks out logins and addresses after failed attempts, attempts are not limited if it's nil
+	LoginThrottle   *LoginThrottle
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	MFATokenTTL     time.Duration
+	// TOTPIssuer is the name of the service shown by authenticator apps
+	TOTPIssuer string
+	// WithdrawTOTPThreshold is the withdrawal sum above which users with 2FA present a TOTP code
+	WithdrawTOTPThreshold money.Amount
 }
 
 var (
-	ErrAuthBadCredentials = errors.New("username or password is incorrect")
+	ErrAuthBadCredentials      = errors.New("username or password is incorrect")
+	ErrAuthTokenRevoked        = errors.New("token is revoked")
+	ErrAuthRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrAuthMFARequired         = errors.New("second factor is required")
+	ErrAuthMFATokenInvalid     = errors.New("mfa token is invalid")
 )
 
+// MFARequiredError is returned by Login for a user with 2FA enabled, it wraps ErrAuthMFARequired.
+// The login is completed by LoginMFA with MFAToken and a code.
+type MFARequiredError struct {
+	MFAToken string
+	// ExpiresIn is the lifetime of MFAToken
+	ExpiresIn time.Duration
+}
+
+func (e *MFARequiredError) Error() string {
+	return ErrAuthMFARequired.Error()
+}
+
+func (e *MFARequiredError) Unwrap() error {
+	return ErrAuthMFARequired
+}
+
+// TokenPair is issued on login and on every refresh.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	// ExpiresIn is the lifetime of AccessToken
+	ExpiresIn time.Duration
+}
+
+// TokenClaims are the claims of a valid access token.
+type TokenClaims struct {
+	UserID int64
+	// ID is the unique token id, the jti claim
+	ID string
+	// FamilyID is the family of refresh tokens the access token is issued with, the fid claim,
+	// it's the id of the session
+	FamilyID  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Roles of the user when the token was issued, the roles claim
+	Roles []string
+}
+
+// HasRole reports whether the token is issued for a user with any of the roles.
+func (c TokenClaims) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, granted := range c.Roles {
+			if granted == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // NewAuthService creates a new instance of AuthService.
-func NewAuthService(userService *UserService, jwtSecretKey string) *AuthService {
+func NewAuthService(
+	userService *UserService,
+	tokenRepository repository.TokenRepository,
+	sessionRepository repository.SessionRepository,
+	keyRing *keyring.KeyRing,
+) *AuthService {
 	return &AuthService{
-		userService:  userService,
-		jwtSecretKey: jwtSecretKey,
+		userService:           userService,
+		tokenRepository:       tokenRepository,
+		sessionRepository:     sessionRepository,
+		keyRing:               keyRing,
+		AccessTokenTTL:        DefaultAccessTokenTTL,
+		RefreshTokenTTL:       DefaultRefreshTokenTTL,
+		MFATokenTTL:           DefaultMFATokenTTL,
+		TOTPIssuer:            DefaultTOTPIssuer,
+		WithdrawTOTPThreshold: DefaultWithdrawTOTPThreshold,
 	}
 }
 
-// Login authenticates a user and returns a JWT token.
-func (a *AuthService) Login(ctx context.Context, username string, password string) (string, error) {
-	user, err := a.userService.UserRepository.GetUserByUsername(ctx, username)
+// Login authenticates a user, starts a new session on the client and returns a new pair of tokens.
+// LoginLockedError is returned while attempts to log in as the user or from the client address are rejected
+// after too many failed ones, MFARequiredError is returned if the user has 2FA enabled.
+func (a *AuthService) Login(ctx context.Context, username string, password string, client ClientInfo) (*TokenPair, error) {
+	// variants of a login in another case are the same login for the lookup and the throttle
+	username = NormalizeLogin(username)
+	err := a.LoginThrottle.Check(ctx, username, client.IP)
 	if err != nil {
-		return "", fmt.Errorf("UserRepository.GetUserByUsername: %w", err)
+		return nil, err
 	}
-	if user == nil || !user.IsValidPassword(password) {
-		return "", ErrAuthBadCredentials
+
+	user, err := a.userService.AuthenticateUser(ctx, username, password)
+	if err != nil {
+		// an unknown login is a failed attempt as well, credential stuffing mostly tries them
+		if errors.Is(err, ErrUserPasswordInvalid) || errors.Is(err, repository.ErrUserNotFound) {
+			if err = a.LoginThrottle.RecordFailure(ctx, username, client.IP); err != nil {
+				logger.Logger().Error("loginThrottle.RecordFailure", zap.Error(err))
+			}
+			return nil, ErrAuthBadCredentials
+		}
+		return nil, fmt.Errorf("userService.AuthenticateUser: %w", err)
 	}
 
-	token, err := a.GenerateToken(user.ID)
+	t, err := a.enabledTOTP(ctx, user.ID)
 	if err != nil {
-		return "", fmt.Errorf("GenerateToken: %w", err)
+		return nil, err
+	}
+	// failed attempts are kept until the second factor is passed, otherwise a known password
+	// would let an attacker guess codes without a lockout
+	if t != nil {
+		mfaToken, err := a.generateMFAToken(user.ID, username)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &MFARequiredError{MFAToken: mfaToken, ExpiresIn: a.MFATokenTTL}
+	}
+
+	if err = a.LoginThrottle.RecordSuccess(ctx, username); err != nil {
+		logger.Logger().Warn("loginThrottle.RecordSuccess", zap.Error(err))
 	}
-	return token, nil
+
+	return a.startSession(ctx, user.ID, user.Roles, client)
+}
+
+// LoginMFA completes a login of a user with 2FA, the code may be a TOTP code or a recovery code.
+// A wrong code is a failed login attempt.
+func (a *AuthService) LoginMFA(ctx context.Context, mfaToken string, code string, client ClientInfo) (*TokenPair, error) {
+	userID, username, err := a.parseMFAToken(mfaToken)
+	if err != nil {
+		logger.Logger().Debug("parse mfa token", zap.Error(err))
+		return nil, ErrAuthMFATokenInvalid
+	}
+
+	err = a.LoginThrottle.Check(ctx, username, client.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := a.enabledTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// 2FA has been disabled since the password was checked
+	if t == nil {
+		return nil, ErrAuthMFATokenInvalid
+	}
+
+	err = a.verifySecondFactor(ctx, t, code)
+	if err != nil {
+		if errors.Is(err, ErrTOTPCodeInvalid) {
+			if err := a.LoginThrottle.RecordFailure(ctx, username, client.IP); err != nil {
+				logger.Logger().Error("loginThrottle.RecordFailure", zap.Error(err))
+			}
+		}
+		return nil, err
+	}
+
+	if err = a.LoginThrottle.RecordSuccess(ctx, username); err != nil {
+		logger.Logger().Warn("loginThrottle.RecordSuccess", zap.Error(err))
+	}
+
+	user, err := a.userService.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user repository get user by id: %w", err)
+	}
+
+	return a.startSession(ctx, userID, user.Roles, client)
+}
+
+// startSession creates a session of the user on the client with its first refresh token
+func (a *AuthService) startSession(ctx context.Context, userID int64, roles []string, client ClientInfo) (*TokenPair, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("generate refresh token family: %w", err)
+	}
+	err = a.sessionRepository.CreateSession(ctx, repository.Session{
+		ID:        familyID,
+		UserID:    userID,
+		Device:    client.Device,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sessionRepository.CreateSession: %w", err)
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("generate refresh token: %w", err)
+	}
+	err = a.tokenRepository.CreateRefreshToken(ctx, repository.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		UserID:    userID,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(a.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tokenRepository.CreateRefreshToken: %w", err)
+	}
+
+	return a.tokenPair(userID, familyID, roles, refreshToken)
 }
 
 // Register registers a new user.
-func (a *AuthService) Register(ctx context.Context, username string, password string) (string, error) {
+func (a *AuthService) Register(ctx context.Context, username string, password string, client ClientInfo) (*TokenPair, error) {
 	err := a.userService.CreateUser(ctx, username, password)
 	if err != nil {
-		return "", fmt.Errorf("create user: %w", err)
+		return nil, fmt.Errorf("create user: %w", err)
 	}
 
-	return a.Login(ctx, username, password)
+	return a.Login(ctx, username, password, client)
 }
 
-// Logout invalidates a JWT token.
-func (a *AuthService) Logout(tokenString string) error {
-	// You can implement logout logic here, such as blacklisting the token
-	// or marking it as expired. For simplicity, let's assume we don't need
-	// to perform any action for logout in this example.
-	return nil
-}
+// Refresh exchanges a refresh token for a new pair of tokens, the presented refresh token can't be used again.
+// Presenting an already used refresh token revokes the session.
+func (a *AuthService) Refresh(ctx context.Context, refreshToken string, client ClientInfo) (*TokenPair, error) {
+	nextRefreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("generate refresh token: %w", err)
+	}
 
-// GenerateToken generates a JWT token for the given user ID.
-func (a *AuthService) GenerateToken(userID int64) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.MapClaims{
-		"userID": strconv.FormatInt(userID, 10),
+	usedToken, err := a.tokenRepository.RotateRefreshToken(ctx, hashToken(refreshToken), repository.RefreshToken{
+		TokenHash: hashToken(nextRefreshToken),
+		ExpiresAt: time.Now().Add(a.RefreshTokenTTL),
 	})
-
-	var jwtSecretKey *ecdsa.PrivateKey
-	if a.jwtSecretKey == "" {
-		jwtSecretKey = GenPrivateKeyMust()
-		privateKey, err := x509.MarshalECPrivateKey(jwtSecretKey)
-		if err != nil {
-			return "", fmt.Errorf("x509.MarshalECPrivateKey: %w", err)
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			logger.Logger().Warn("refresh token is reused, the session is revoked")
+			return nil, ErrAuthRefreshTokenInvalid
 		}
-		a.jwtSecretKey = string(privateKey)
-	} else {
-		var err error
-		jwtSecretKey, err = x509.ParseECPrivateKey([]byte(a.jwtSecretKey))
-		if err != nil {
-			return "", fmt.Errorf("x509.MarshalECPrivateKey: %w", err)
+		if errors.Is(err, repository.ErrRefreshTokenInvalid) {
+			return nil, ErrAuthRefreshTokenInvalid
 		}
+		return nil, fmt.Errorf("tokenRepository.RotateRefreshToken: %w", err)
 	}
-	t, err := token.SignedString(jwtSecretKey)
+
+	// the session is listed to the user with the last activity, the refresh must not fail because of it
+	err = a.sessionRepository.TouchSession(ctx, usedToken.FamilyID, client.IP)
 	if err != nil {
-		return "", fmt.Errorf("sign the token: %w", err)
+		logger.Logger().Warn("sessionRepository.TouchSession", zap.Error(err))
 	}
-	return t, nil
+
+	// roles are read on every refresh, so a granted or revoked role applies within the access token lifetime
+	user, err := a.userService.UserRepository.GetUserByID(ctx, usedToken.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("user repository get user by id: %w", err)
+	}
+
+	return a.tokenPair(usedToken.UserID, usedToken.FamilyID, user.Roles, nextRefreshToken)
 }
 
-// ValidateToken validates a JWT token and extracts the user ID.
-func (a *AuthService) ValidateToken(tokenString string) (int64, error) {
-	privateKey, err := x509.ParseECPrivateKey([]byte(a.jwtSecretKey))
+// Logout revokes the access token and the session with all its refresh tokens.
+func (a *AuthService) Logout(ctx context.Context, claims TokenClaims) error {
+	err := a.tokenRepository.RevokeAccessToken(ctx, claims.ID, claims.UserID, claims.ExpiresAt)
 	if err != nil {
-		return -1, fmt.Errorf("x509.ParseECPrivateKey: %w", err)
+		return fmt.Errorf("tokenRepository.RevokeAccessToken: %w", err)
 	}
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return &privateKey.PublicKey, nil
-	})
+
+	err = a.sessionRepository.RevokeSession(ctx, claims.UserID, claims.FamilyID)
+	if err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+		return fmt.Errorf("sessionRepository.RevokeSession: %w", err)
+	}
+
+	return nil
+}
+
+func (a *AuthService) tokenPair(userID int64, familyID string, roles []string, refreshToken string) (*TokenPair, error) {
+	accessToken, err := a.GenerateToken(userID, familyID, roles)
 	if err != nil {
-		return -1, fmt.Errorf("jwt parse: %w", err)
+		return nil, fmt.Errorf("GenerateToken: %w", err)
 	}
 
-	// Check if the token is valid
-	if !token.Valid {
-		return -1, errors.New("token is not valid")
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    a.AccessTokenTTL,
+	}, nil
+}
+
+// GenerateToken generates a JWT access token for the given user ID, refresh token family and roles of the user.
+func (a *AuthService) GenerateToken(userID int64, familyID string, roles []string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("generate token id: %w", err)
 	}
 
-	// Extract the user ID from the token claims
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"userID": strconv.FormatInt(userID, 10),
+		"fid":    familyID,
+		"jti":    jti,
+		"iat":    now.Unix(),
+		"exp":    now.Add(a.AccessTokenTTL).Unix(),
+	}
+	// tokens of regular users stay as small as before
+	if len(roles) > 0 {
+		claims["roles"] = roles
+	}
+	t, err := a.keyRing.Sign(&claims)
+	if err != nil {
+		return "", fmt.Errorf("sign the token: %w", err)
+	}
+	return t, nil
+}
+
+// generateMFAToken signs a short-lived token proving the password of the user has been checked
+func (a *AuthService) generateMFAToken(userID int64, username string) (string, error) {
+	now := time.Now()
+	t, err := a.keyRing.Sign(&jwt.MapClaims{
+		"userID": strconv.FormatInt(userID, 10),
+		"login":  username,
+		"typ":    mfaTokenType,
+		"iat":    now.Unix(),
+		"exp":    now.Add(a.MFATokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("sign the mfa token: %w", err)
+	}
+	return t, nil
+}
+
+func (a *AuthService) parseMFAToken(tokenString string) (int64, string, error) {
+	token, err := jwt.Parse(tokenString, a.keyRing.Keyfunc)
+	if err != nil {
+		return 0, "", fmt.Errorf("jwt parse: %w", err)
+	}
 	mapClaims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return -1, errors.New("invalid token claims")
+	if !ok || !token.Valid {
+		return 0, "", errors.New("invalid token claims")
+	}
+	if typ, _ := mapClaims["typ"].(string); typ != mfaTokenType {
+		return 0, "", errors.New("not an mfa token")
 	}
-	id := mapClaims["userID"].(string)
+	if _, ok := mapClaims["exp"].(float64); !ok {
+		return 0, "", errors.New("token has no exp claim")
+	}
+
+	id, _ := mapClaims["userID"].(string)
 	userID, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		return -1, fmt.Errorf("strconv.ParseInt, %s: invalid user ID in token: %w", id, err)
+		return 0, "", fmt.Errorf("strconv.ParseInt, %s: invalid user ID in token: %w", id, err)
 	}
+	username, _ := mapClaims["login"].(string)
+
+	return userID, username, nil
+}
 
-	return userID, nil
+// JWKS returns the public keys access tokens are verified with.
+func (a *AuthService) JWKS() keyring.JWKS {
+	return a.keyRing.JWKS()
 }
 
-func GenPrivateKeyMust() *ecdsa.PrivateKey {
-	key, err := TestGenKey()
+// ValidateToken validates a JWT access token, checks it is not revoked and extracts its claims.
+func (a *AuthService) ValidateToken(ctx context.Context, tokenString string) (*TokenClaims, error) {
+	token, err := jwt.Parse(tokenString, a.keyRing.Keyfunc)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("jwt parse: %w", err)
+	}
+
+	// Check if the token is valid, exp is checked by jwt.Parse
+	if !token.Valid {
+		return nil, errors.New("token is not valid")
 	}
-	return key
-}
 
-func TestGenKey() (*ecdsa.PrivateKey, error) {
-	// Генерируем новый ECDSA ключ
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	// Extract the claims from the token
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	claims, err := tokenClaims(mapClaims)
 	if err != nil {
-		fmt.Println("Ошибка при генерации ключа:", err)
 		return nil, err
 	}
 
-	return privateKey, nil
+	revoked, err := a.tokenRepository.IsAccessTokenRevoked(ctx, claims.ID, claims.FamilyID)
+	if err != nil {
+		return nil, fmt.Errorf("tokenRepository.IsAccessTokenRevoked: %w", err)
+	}
+	if revoked {
+		return nil, ErrAuthTokenRevoked
+	}
+
+	return claims, nil
 }
 
-func MarshalPrivateKey(privateKey *ecdsa.PrivateKey) error {
-	// Кодируем приватный ключ в формат PEM
-	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
+func tokenClaims(mapClaims jwt.MapClaims) (*TokenClaims, error) {
+	// access tokens have no typ claim, an mfa token only proves the password
+	if typ, _ := mapClaims["typ"].(string); typ != "" {
+		return nil, fmt.Errorf("token of type %q is not an access token", typ)
+	}
+
+	id, _ := mapClaims["userID"].(string)
+	userID, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		fmt.Println("Ошибка при кодировании приватного ключа:", err)
-		return err
+		return nil, fmt.Errorf("strconv.ParseInt, %s: invalid user ID in token: %w", id, err)
+	}
+
+	jti, _ := mapClaims["jti"].(string)
+	familyID, _ := mapClaims["fid"].(string)
+	issuedAt, _ := mapClaims["iat"].(float64)
+	expiresAt, ok := mapClaims["exp"].(float64)
+	// tokens issued without expiry are not accepted anymore
+	if jti == "" || familyID == "" || !ok {
+		return nil, errors.New("token has no jti, fid or exp claim")
 	}
 
-	privateKeyPEM := &pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: privateKeyBytes,
+	var roles []string
+	claimedRoles, _ := mapClaims["roles"].([]interface{})
+	for _, claimedRole := range claimedRoles {
+		role, ok := claimedRole.(string)
+		if !ok {
+			return nil, errors.New("token has a malformed roles claim")
+		}
+		roles = append(roles, role)
 	}
 
-	file := os.Stdout
-	err = pem.Encode(file, privateKeyPEM)
+	return &TokenClaims{
+		UserID:    userID,
+		ID:        jti,
+		FamilyID:  familyID,
+		IssuedAt:  time.Unix(int64(issuedAt), 0),
+		ExpiresAt: time.Unix(int64(expiresAt), 0),
+		Roles:     roles,
+	}, nil
+}
+
+// randomToken returns n random bytes encoded with URL safe base64
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
 	if err != nil {
-		fmt.Println("Ошибка при кодировании PEM-блока:", err)
-		return err
+		return "", err
 	}
-	fmt.Println("Приватный ключ сохранен в файл private.key")
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	return nil
+// hashToken is the form a refresh token is stored in, a leaked table doesn't reveal usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
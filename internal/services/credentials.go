@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/andreevym/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// ChangePassword replaces the password of the user and revokes all other sessions, the session of claims stays.
+// A wrong old password is a failed login attempt, so a stolen access token can't be used to guess the password.
+func (a *AuthService) ChangePassword(ctx context.Context, claims TokenClaims, oldPassword string, newPassword string) error {
+	err := a.checkPassword(ctx, claims.UserID, func() error {
+		return a.userService.ChangePassword(ctx, claims.UserID, oldPassword, newPassword)
+	})
+	if err != nil {
+		return err
+	}
+
+	revoked, err := a.sessionRepository.RevokeOtherSessions(ctx, claims.UserID, claims.FamilyID)
+	if err != nil {
+		return fmt.Errorf("sessionRepository.RevokeOtherSessions: %w", err)
+	}
+	logger.Logger().Info("password is changed", zap.Int64("userID", claims.UserID), zap.Int64("revokedSessions", revoked))
+
+	return nil
+}
+
+// DeleteAccount anonymizes the user after checking the password and the second factor if 2FA is enabled,
+// all sessions of the user are revoked. Orders, the account and the ledger of the user are kept for accounting.
+func (a *AuthService) DeleteAccount(ctx context.Context, userID int64, password string, code string) error {
+	err := a.checkPassword(ctx, userID, func() error {
+		_, err := a.userService.AuthenticateUserByID(ctx, userID, password)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	t, err := a.enabledTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		if code == "" {
+			return ErrTOTPCodeRequired
+		}
+		err = a.verifySecondFactor(ctx, t, code)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = a.userService.AnonymizeUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("userService.AnonymizeUser: %w", err)
+	}
+
+	// the account is already unusable, the rest only cleans up
+	if _, err = a.sessionRepository.RevokeOtherSessions(ctx, userID, ""); err != nil {
+		return fmt.Errorf("sessionRepository.RevokeOtherSessions: %w", err)
+	}
+	if t != nil {
+		if err = a.TOTPRepository.DeleteTOTP(ctx, userID); err != nil {
+			return fmt.Errorf("totpRepository.DeleteTOTP: %w", err)
+		}
+	}
+	logger.Logger().Info("account is deleted", zap.Int64("userID", userID))
+
+	return nil
+}
+
+// checkPassword runs check of the current password of the user with the login throttle,
+// ErrUserPasswordInvalid of check is counted as a failed login attempt
+func (a *AuthService) checkPassword(ctx context.Context, userID int64, check func() error) error {
+	user, err := a.userService.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("userRepository.GetUserByID: %w", err)
+	}
+
+	err = a.LoginThrottle.Check(ctx, NormalizeLogin(user.Username), "")
+	if err != nil {
+		return err
+	}
+
+	err = check()
+	if errors.Is(err, ErrUserPasswordInvalid) {
+		if err := a.LoginThrottle.RecordFailure(ctx, NormalizeLogin(user.Username), ""); err != nil {
+			logger.Logger().Error("loginThrottle.RecordFailure", zap.Error(err))
+		}
+	}
+	return err
+}
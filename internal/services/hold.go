@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
+	"go.uber.org/zap"
+)
+
+// DefaultHoldTTL is how long a hold reserves points unless another TTL is set
+const DefaultHoldTTL = 15 * time.Minute
+
+var (
+	ErrHoldNotFound  = errors.New("hold not found")
+	ErrHoldNotActive = errors.New("hold is already captured, released or expired")
+)
+
+// PlaceHold reserves amount of the user balance in payment for the order for HoldTTL,
+// the held points can't be withdrawn or held again until the hold is released or expired.
+// The checks are the same as of Withdraw.
+func (s TransactionService) PlaceHold(ctx context.Context, userID int64, amount money.Amount, orderNumber string) (*repository.Hold, error) {
+	if err := validateWithdraw(amount, orderNumber); err != nil {
+		return nil, err
+	}
+
+	ttl := s.HoldTTL
+	if ttl <= 0 {
+		ttl = DefaultHoldTTL
+	}
+	hold, err := s.transactionRepository.PlaceHold(ctx, repository.Hold{
+		UserID:      userID,
+		Amount:      amount,
+		OrderNumber: orderNumber,
+		Expires:     time.Now().Add(ttl),
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrInsufficientFunds) {
+			return nil, ErrWithdrawInsufficientFunds
+		}
+		return nil, fmt.Errorf("transaction storage: place hold: %w", err)
+	}
+	return hold, nil
+}
+
+// CaptureHold withdraws the held points, the withdrawal is the same as made by Withdraw
+func (s TransactionService) CaptureHold(ctx context.Context, userID int64, holdID int64) (*repository.Hold, error) {
+	hold, err := s.transactionRepository.CaptureHold(ctx, userID, holdID)
+	if err != nil {
+		return nil, holdError("capture hold", err)
+	}
+	return hold, nil
+}
+
+// ReleaseHold cancels the reservation, the held points are available again
+func (s TransactionService) ReleaseHold(ctx context.Context, userID int64, holdID int64) (*repository.Hold, error) {
+	hold, err := s.transactionRepository.ReleaseHold(ctx, userID, holdID)
+	if err != nil {
+		return nil, holdError("release hold", err)
+	}
+	return hold, nil
+}
+
+func holdError(operation string, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrHoldNotFound):
+		return ErrHoldNotFound
+	case errors.Is(err, repository.ErrHoldNotActive):
+		return ErrHoldNotActive
+	}
+	return fmt.Errorf("transaction storage: %s: %w", operation, err)
+}
+
+// ExpireHolds marks the holds which were neither captured nor released in time expired,
+// the points of a stale hold are available even before it's marked
+func (s TransactionService) ExpireHolds(ctx context.Context) (int64, error) {
+	expired, err := s.transactionRepository.ExpireHolds(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("transaction storage: expire holds: %w", err)
+	}
+	if expired > 0 {
+		logger.Logger().Info("stale holds are expired", zap.Int64("count", expired))
+	}
+	return expired, nil
+}
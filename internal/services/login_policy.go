@@ -0,0 +1,50 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUserLoginInvalid = errors.New("login does not meet the rules")
+
+const (
+	LoginMinLength = 3
+	LoginMaxLength = 64
+	// deletedLoginPrefix starts the logins of anonymized users, see UserService.AnonymizeUser
+	deletedLoginPrefix = "deleted-"
+)
+
+// NormalizeLogin returns the form logins are stored and looked up in, logins are case-insensitive.
+func NormalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// ValidateLogin checks a normalized login on registration and returns an error wrapping ErrUserLoginInvalid
+// which tells the user what is wrong with it. Logins of existing users are not checked on login.
+func ValidateLogin(login string) error {
+	if len(login) < LoginMinLength || len(login) > LoginMaxLength {
+		return fmt.Errorf("%w: login must be from %d to %d characters long", ErrUserLoginInvalid, LoginMinLength, LoginMaxLength)
+	}
+	for _, c := range login {
+		if !isLoginChar(c) {
+			return fmt.Errorf("%w: login may contain only latin letters, digits, '.', '_' and '-'", ErrUserLoginInvalid)
+		}
+	}
+	if !isLoginAlnum(rune(login[0])) {
+		return fmt.Errorf("%w: login must start with a letter or a digit", ErrUserLoginInvalid)
+	}
+	if strings.HasPrefix(login, deletedLoginPrefix) {
+		return fmt.Errorf("%w: login is reserved", ErrUserLoginInvalid)
+	}
+
+	return nil
+}
+
+func isLoginAlnum(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+func isLoginChar(c rune) bool {
+	return isLoginAlnum(c) || c == '.' || c == '_' || c == '-'
+}
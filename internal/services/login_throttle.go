@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+)
+
+// DefaultLoginFailureWindow is the time after which failed login attempts are forgotten
+const DefaultLoginFailureWindow = time.Hour
+
+var ErrAuthTooManyAttempts = errors.New("too many failed login attempts")
+
+// LoginLockedError is returned while login attempts are rejected, it wraps ErrAuthTooManyAttempts.
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrAuthTooManyAttempts, e.RetryAfter)
+}
+
+func (e *LoginLockedError) Unwrap() error {
+	return ErrAuthTooManyAttempts
+}
+
+// LoginLockoutPolicy describes how long login attempts are rejected after failed ones.
+type LoginLockoutPolicy struct {
+	// MaxFailures is the number of failed attempts in a row allowed without a lockout
+	MaxFailures int
+	// BaseDelay is the lockout after the first failed attempt over MaxFailures, it doubles with every next one
+	BaseDelay time.Duration
+	// MaxDelay caps the lockout, so a user locked out by somebody else can log in again soon
+	MaxDelay time.Duration
+}
+
+var (
+	// DefaultLoginLockoutPolicy limits attempts to log in as one user.
+	DefaultLoginLockoutPolicy = LoginLockoutPolicy{
+		MaxFailures: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    15 * time.Minute,
+	}
+	// DefaultIPLockoutPolicy limits attempts from one client address, it is looser
+	// because many users may share an address behind NAT.
+	DefaultIPLockoutPolicy = LoginLockoutPolicy{
+		MaxFailures: 50,
+		BaseDelay:   time.Second,
+		MaxDelay:    15 * time.Minute,
+	}
+)
+
+// Lockout returns how long login attempts are rejected after failures failed attempts in a row, 0 if they are not.
+func (p LoginLockoutPolicy) Lockout(failures int) time.Duration {
+	over := failures - p.MaxFailures
+	if over < 1 {
+		return 0
+	}
+
+	delay := p.MaxDelay
+	// BaseDelay << 62 overflows, the cap is reached much earlier anyway
+	if over <= 32 {
+		if d := p.BaseDelay << (over - 1); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// LoginThrottle tracks failed login attempts per login and per client address
+// and rejects attempts with progressively longer lockouts.
+type LoginThrottle struct {
+	loginFailureRepository repository.LoginFailureRepository
+	LoginPolicy            LoginLockoutPolicy
+	IPPolicy               LoginLockoutPolicy
+	// FailureWindow is the time after which failed attempts are forgotten
+	FailureWindow time.Duration
+}
+
+// NewLoginThrottle creates a new instance of LoginThrottle with the default policies.
+func NewLoginThrottle(loginFailureRepository repository.LoginFailureRepository) *LoginThrottle {
+	return &LoginThrottle{
+		loginFailureRepository: loginFailureRepository,
+		LoginPolicy:            DefaultLoginLockoutPolicy,
+		IPPolicy:               DefaultIPLockoutPolicy,
+		FailureWindow:          DefaultLoginFailureWindow,
+	}
+}
+
+// Check returns LoginLockedError if attempts to log in as login or from ip are rejected now,
+// an empty ip is not checked. A nil throttle never rejects an attempt.
+func (t *LoginThrottle) Check(ctx context.Context, login string, ip string) error {
+	if t == nil {
+		return nil
+	}
+	var retryAfter time.Duration
+	for _, key := range t.keys(login, ip) {
+		failure, err := t.loginFailureRepository.GetLoginFailure(ctx, key.scope, key.key)
+		if err != nil {
+			return fmt.Errorf("loginFailureRepository.GetLoginFailure: %w", err)
+		}
+		if failure == nil || failure.LockedUntil == nil {
+			continue
+		}
+		if d := time.Until(*failure.LockedUntil); d > retryAfter {
+			retryAfter = d
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LoginLockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt to log in as login from ip and locks them out when their policy says so.
+func (t *LoginThrottle) RecordFailure(ctx context.Context, login string, ip string) error {
+	if t == nil {
+		return nil
+	}
+	for _, key := range t.keys(login, ip) {
+		failure, err := t.loginFailureRepository.RecordLoginFailure(ctx, key.scope, key.key, t.FailureWindow)
+		if err != nil {
+			return fmt.Errorf("loginFailureRepository.RecordLoginFailure: %w", err)
+		}
+
+		lockout := key.policy.Lockout(failure.Failures)
+		if lockout == 0 {
+			continue
+		}
+		err = t.loginFailureRepository.LockLogin(ctx, key.scope, key.key, time.Now().Add(lockout))
+		if err != nil {
+			return fmt.Errorf("loginFailureRepository.LockLogin: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RecordSuccess forgets the failed attempts to log in as login. Failures of the address are kept,
+// otherwise one valid account would let an attacker reset the address counter.
+func (t *LoginThrottle) RecordSuccess(ctx context.Context, login string) error {
+	if t == nil {
+		return nil
+	}
+	_, err := t.loginFailureRepository.ResetLoginFailures(ctx, repository.LoginFailureScopeLogin, login)
+	if err != nil {
+		return fmt.Errorf("loginFailureRepository.ResetLoginFailures: %w", err)
+	}
+	return nil
+}
+
+// Unlock forgets the failed attempts and the lockout of a login or an address,
+// scope is repository.LoginFailureScopeLogin or repository.LoginFailureScopeIP.
+// It returns false if the key had no failed attempts.
+func (t *LoginThrottle) Unlock(ctx context.Context, scope string, key string) (bool, error) {
+	if scope != repository.LoginFailureScopeLogin && scope != repository.LoginFailureScopeIP {
+		return false, fmt.Errorf("unknown login failure scope %q", scope)
+	}
+	if scope == repository.LoginFailureScopeLogin {
+		key = NormalizeLogin(key)
+	}
+
+	unlocked, err := t.loginFailureRepository.ResetLoginFailures(ctx, scope, key)
+	if err != nil {
+		return false, fmt.Errorf("loginFailureRepository.ResetLoginFailures: %w", err)
+	}
+	return unlocked, nil
+}
+
+type throttleKey struct {
+	scope  string
+	key    string
+	policy LoginLockoutPolicy
+}
+
+func (t *LoginThrottle) keys(login string, ip string) []throttleKey {
+	keys := []throttleKey{{scope: repository.LoginFailureScopeLogin, key: login, policy: t.LoginPolicy}}
+	if ip != "" {
+		keys = append(keys, throttleKey{scope: repository.LoginFailureScopeIP, key: ip, policy: t.IPPolicy})
+	}
+	return keys
+}
@@ -4,8 +4,11 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/andreevym/gophermart/internal/accrual"
@@ -17,7 +20,8 @@ import (
 const (
 	// NewOrderStatus новый заказ;
 	NewOrderStatus string = "NEW"
-	// RegisteredOrderStatus заказ зарегистрирован, но начисление не рассчитано;
+	// RegisteredOrderStatus заказ зарегистрирован в системе расчёта, но начисление не рассчитано,
+	// статус системы расчёта, пользователю такой заказ показывается как PROCESSING;
 	RegisteredOrderStatus string = "REGISTERED"
 	// InvalidOrderStatus заказ не принят к расчёту, и вознаграждение не будет начислено;
 	InvalidOrderStatus string = "INVALID"
@@ -27,74 +31,205 @@ const (
 	ProcessedOrderStatus string = "PROCESSED"
 )
 
-var ErrAccrualServiceDisabled = errors.New("accrual service is disabled")
+var (
+	ErrAccrualServiceDisabled = errors.New("accrual service is disabled")
+	ErrUnknownAccrualStatus   = errors.New("unknown accrual status")
+	ErrOrderNotRegistered     = errors.New("order is not registered in accrual service")
+	ErrAccrualRateLimited     = errors.New("accrual service rate limit exceeded")
+	ErrAccrualServerError     = errors.New("accrual service server error")
+)
+
+// retryAfterError postpones the next processing attempt at least by retryAfter
+type retryAfterError struct {
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.err, e.retryAfter)
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// pendingOrderStatuses are polled in the accrual system until the order gets a final status,
+// REGISTERED is kept for orders stored before it was mapped to PROCESSING
+var pendingOrderStatuses = []string{NewOrderStatus, ProcessingOrderStatus, RegisteredOrderStatus}
+
+const (
+	// DefaultOrderPollInterval is the delay between polls of an order the accrual system is still calculating
+	DefaultOrderPollInterval = time.Second
+	// DefaultOrderRegistrationTimeout is how long an order unknown to the accrual system stays pending
+	DefaultOrderRegistrationTimeout = 24 * time.Hour
+	// orderProcessingTimeout bounds one processing attempt, including the wait for the accrual rate limiter
+	orderProcessingTimeout = 10 * time.Second
+	// DefaultOrderLeaseDuration is how long a claimed order is kept from other instances,
+	// it must be longer than one processing attempt
+	DefaultOrderLeaseDuration = time.Minute
+)
+
+// NewInstanceID returns an identifier of this process unique among the replicas of the service,
+// it is the owner of the orders claimed by the process
+func NewInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "gophermart"
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// IsFinalOrderStatus reports whether the order will never change its status
+func IsFinalOrderStatus(status string) bool {
+	return status == InvalidOrderStatus || status == ProcessedOrderStatus
+}
+
+// orderStatusFromAccrual maps a status of the accrual system to the user-facing order status
+func orderStatusFromAccrual(status string) (string, error) {
+	switch status {
+	case accrual.StatusRegistered, accrual.StatusProcessing:
+		return ProcessingOrderStatus, nil
+	case accrual.StatusInvalid:
+		return InvalidOrderStatus, nil
+	case accrual.StatusProcessed:
+		return ProcessedOrderStatus, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownAccrualStatus, status)
+	}
+}
 
 // OrderService struct represents the service for orders
 type OrderService struct {
 	TransactionService *TransactionService
 	OrderRepository    repository.OrderRepository
 	AccrualService     *accrual.AccrualService
+	RetryPolicy        OrderRetryPolicy
+	// PollInterval is the delay between polls of an order in a non-final status
+	PollInterval time.Duration
+	// RegistrationTimeout is how long after upload an order unknown to the accrual system
+	// is retried before it is marked INVALID, zero means the order is retried forever
+	RegistrationTimeout time.Duration
+	// InstanceID is the lease owner of the orders claimed by this instance
+	InstanceID string
+	// LeaseDuration is how long a claimed order is kept from other instances
+	LeaseDuration time.Duration
 }
 
 // NewOrderService creates a new instance of OrderService
 func NewOrderService(transactionService *TransactionService, orderRepository repository.OrderRepository, accrualService *accrual.AccrualService) *OrderService {
 	return &OrderService{
-		TransactionService: transactionService,
-		OrderRepository:    orderRepository,
-		AccrualService:     accrualService,
+		TransactionService:  transactionService,
+		OrderRepository:     orderRepository,
+		AccrualService:      accrualService,
+		RetryPolicy:         DefaultOrderRetryPolicy,
+		PollInterval:        DefaultOrderPollInterval,
+		RegistrationTimeout: DefaultOrderRegistrationTimeout,
+		InstanceID:          NewInstanceID(),
+		LeaseDuration:       DefaultOrderLeaseDuration,
 	}
 }
 
-func (s *OrderService) OrderProcessingWithRetry(order repository.Order, maxOrderAttempts int) error {
-	var err error
-	for i := 0; i < maxOrderAttempts; i++ {
-		err = s.OrderProcessing(order)
-		if err == nil {
-			return nil
-		}
-		logger.Logger().Error(
-			"order processing: failed to process order by number, with retry",
-			zap.String("orderNumber", order.Number),
-			zap.Int("attempt", i),
-			zap.Error(err),
-		)
-		time.Sleep(time.Millisecond * 100)
+// OrderProcessingWithRetry makes one processing attempt, a failed attempt is persisted
+// with the order and the next one is postponed according to RetryPolicy,
+// so a temporary outage of the accrual system never makes the order INVALID
+func (s *OrderService) OrderProcessingWithRetry(ctx context.Context, order repository.Order) error {
+	processingErr := s.OrderProcessing(ctx, order)
+	if processingErr == nil {
+		return nil
+	}
+	// the attempt is interrupted by shutdown, the order is picked up again when its lease expires
+	if ctx.Err() != nil {
+		return fmt.Errorf("order %s processing is interrupted: %w", order.Number, processingErr)
+	}
+	// another instance has taken the order over, the result belongs to it
+	if errors.Is(processingErr, repository.ErrOrderLeaseLost) {
+		logger.Logger().Warn("order processing: lease is lost, result is dropped", zap.String("orderNumber", order.Number))
+		return nil
+	}
+
+	attempts := order.Attempts + 1
+	delay := s.RetryPolicy.Delay(attempts)
+	// the accrual system has told when it accepts requests again, there is no sense to ask it earlier
+	var retryAfterErr *retryAfterError
+	if errors.As(processingErr, &retryAfterErr) && retryAfterErr.retryAfter > delay {
+		delay = retryAfterErr.retryAfter
 	}
+	logger.Logger().Error(
+		"order processing: failed to process order by number, retry is scheduled",
+		zap.String("orderNumber", order.Number),
+		zap.Int("attempt", attempts),
+		zap.Duration("delay", delay),
+		zap.Error(processingErr),
+	)
+
+	err := s.OrderRepository.ScheduleOrderRetry(ctx, order.Number, order.LeaseOwner, delay, processingErr.Error())
 	if err != nil {
-		err = s.CancelOrder(order)
-		if err != nil {
-			return fmt.Errorf("failed to process, order was canceled: %w", err)
-		}
+		return fmt.Errorf("failed to schedule retry of order %s: %w", order.Number, err)
 	}
 
 	return nil
 }
 
 func (s *OrderService) OrderProcessing(
+	ctx context.Context,
 	order repository.Order,
 ) error {
-	child, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	child, cancelFunc := context.WithTimeout(ctx, orderProcessingTimeout)
 	defer cancelFunc()
 
 	// return if this order is already handled
-	if order.Status == ProcessedOrderStatus ||
-		order.Status == InvalidOrderStatus {
+	if IsFinalOrderStatus(order.Status) {
 		return nil
 	}
 
-	orderAccrual, err := s.AccrualService.RequestAccrualByOrderNumber(order.Number)
+	result, err := s.AccrualService.RequestAccrualByOrderNumber(child, order.Number)
 	if err != nil {
 		logger.Logger().Error("AccrualService.RequestAccrualByOrderNumber", zap.Error(err))
 		return fmt.Errorf("failed to get order from AccrualService: %w", err)
 	}
 
+	switch result.Status {
+	case accrual.ResultOK:
+	case accrual.ResultNotRegistered:
+		return s.orderNotRegistered(child, order)
+	case accrual.ResultRateLimited:
+		return &retryAfterError{retryAfter: result.RetryAfter, err: ErrAccrualRateLimited}
+	case accrual.ResultServerError:
+		return fmt.Errorf("%w: status code %d", ErrAccrualServerError, result.StatusCode)
+	default:
+		return fmt.Errorf("unexpected accrual result %s", result.Status)
+	}
+	orderAccrual := result.Accrual
+
+	status, err := orderStatusFromAccrual(orderAccrual.Status)
+	if err != nil {
+		return fmt.Errorf("order %s: %w", order.Number, err)
+	}
+
+	// the accrual system is still calculating, poll the order again later
+	if !IsFinalOrderStatus(status) {
+		err = s.OrderRepository.ScheduleOrderPoll(child, order.Number, order.LeaseOwner, status, s.PollInterval)
+		if err != nil {
+			return fmt.Errorf("failed to schedule poll of order %s: %w", order.Number, err)
+		}
+		return nil
+	}
+
 	// update order and insert transaction
 	err = s.TransactionService.AccrualAmount(
 		child,
 		order.UserID,
 		orderAccrual.Order,
+		order.LeaseOwner,
 		orderAccrual.Accrual,
-		orderAccrual.Status,
+		status,
 	)
 	if err != nil {
 		logger.Logger().Error("transfer service: accrual amount", zap.Error(err))
@@ -104,6 +239,33 @@ func (s *OrderService) OrderProcessing(
 	return nil
 }
 
+// orderNotRegistered keeps an order unknown to the accrual system pending until RegistrationTimeout,
+// then the order is marked INVALID, no accrual is expected for it anymore.
+// The timeout of a requeued order is counted from the requeue.
+func (s *OrderService) orderNotRegistered(ctx context.Context, order repository.Order) error {
+	pendingSince := order.UploadedAt
+	if order.RequeuedAt != nil && order.RequeuedAt.After(pendingSince) {
+		pendingSince = *order.RequeuedAt
+	}
+	if s.RegistrationTimeout <= 0 || time.Since(pendingSince) < s.RegistrationTimeout {
+		return fmt.Errorf("order %s: %w", order.Number, ErrOrderNotRegistered)
+	}
+
+	logger.Logger().Warn(
+		"order is not registered in accrual service in time, mark it invalid",
+		zap.String("orderNumber", order.Number),
+		zap.Time("uploadedAt", order.UploadedAt),
+		zap.Time("pendingSince", pendingSince),
+		zap.Duration("registrationTimeout", s.RegistrationTimeout),
+	)
+	err := s.TransactionService.AccrualAmount(ctx, order.UserID, order.Number, order.LeaseOwner, 0, InvalidOrderStatus)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate unregistered order %s: %w", order.Number, err)
+	}
+
+	return nil
+}
+
 func (s OrderService) GetOrderByNumber(context context.Context, number string) (*repository.Order, error) {
 	return s.OrderRepository.GetOrderByNumber(context, number)
 }
@@ -136,6 +298,27 @@ func (s OrderService) NewOrder(ctx context.Context, orderNumber string, userID i
 	return nil
 }
 
+// ClaimBatchSize returns how many orders the workers surely process within the lease,
+// every attempt is bounded by orderProcessingTimeout, one more attempt per worker is left as a margin
+func (s *OrderService) ClaimBatchSize(workers int) int {
+	perWorker := int(s.LeaseDuration/orderProcessingTimeout) - 1
+	if perWorker < 1 {
+		perWorker = 1
+	}
+	return workers * perWorker
+}
+
+// ClaimDueOrders leases to this instance at most limit orders in a non-final status whose next attempt time has come,
+// the lease is released when the attempt result is stored, an instance crashed meanwhile loses it after LeaseDuration
+func (s *OrderService) ClaimDueOrders(ctx context.Context, limit int) ([]repository.Order, error) {
+	orders, err := s.OrderRepository.ClaimOrders(ctx, s.InstanceID, pendingOrderStatuses, limit, s.LeaseDuration)
+	if err != nil {
+		logger.Logger().Error("orderService.OrderRepository.ClaimOrders", zap.Error(err))
+		return nil, err
+	}
+	return orders, nil
+}
+
 func (s *OrderService) GetOrdersByStatus(status string) ([]repository.Order, error) {
 	ctx := context.Background()
 	orders, err := s.OrderRepository.GetOrdersByStatus(ctx, status)
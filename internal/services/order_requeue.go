@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrOrderRequeueNotAllowed is returned when the order status doesn't allow the requested action
+	ErrOrderRequeueNotAllowed = errors.New("order status doesn't allow requeue")
+	// ErrOrderRequeueInvalid is returned for a bulk requeue with a bad filter
+	ErrOrderRequeueInvalid = errors.New("invalid order requeue")
+)
+
+// requeueStatus is the status an order gets back to processing with: an INVALID order starts over as NEW,
+// a PROCESSED one is polled as PROCESSING and a pending order keeps its status
+func requeueStatus(status string) string {
+	switch status {
+	case InvalidOrderStatus:
+		return NewOrderStatus
+	case ProcessedOrderStatus:
+		return ProcessingOrderStatus
+	default:
+		return status
+	}
+}
+
+// RequeueOrder sends an INVALID or a pending order back to processing on behalf of the operator,
+// the failed attempts are forgotten and the order is due immediately. The registration timeout
+// of the order is counted from the requeue. A PROCESSED order is rechecked with RecheckOrder instead.
+func (s *OrderService) RequeueOrder(ctx context.Context, adminUserID int64, number string) (*repository.Order, error) {
+	return s.requeueOrder(ctx, adminUserID, number, repository.AdminActionOrderRequeue, func(status string) bool {
+		return status != ProcessedOrderStatus
+	})
+}
+
+// RecheckOrder queries the accrual system again for a PROCESSED order on behalf of the operator,
+// an accrual the user got for the order is never credited twice.
+func (s *OrderService) RecheckOrder(ctx context.Context, adminUserID int64, number string) (*repository.Order, error) {
+	return s.requeueOrder(ctx, adminUserID, number, repository.AdminActionOrderRecheck, func(status string) bool {
+		return status == ProcessedOrderStatus
+	})
+}
+
+func (s *OrderService) requeueOrder(
+	ctx context.Context,
+	adminUserID int64,
+	number string,
+	action string,
+	allowed func(status string) bool,
+) (*repository.Order, error) {
+	order, err := s.OrderRepository.GetOrderByNumber(ctx, number)
+	if err != nil {
+		return nil, fmt.Errorf("order repository get order by number: %w", err)
+	}
+	if !allowed(order.Status) {
+		return nil, fmt.Errorf("%w: order %s is %s", ErrOrderRequeueNotAllowed, number, order.Status)
+	}
+
+	// the status is a part of the filter, so the order isn't requeued if it has been processed meanwhile
+	numbers, err := s.OrderRepository.RequeueOrders(ctx,
+		repository.OrderFilter{Number: number, Status: order.Status},
+		requeueStatus(order.Status),
+		repository.AdminAction{AdminUserID: adminUserID, Action: action, Target: number},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("order repository requeue orders: %w", err)
+	}
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("%w: status of order %s has changed", ErrOrderRequeueNotAllowed, number)
+	}
+
+	logger.Logger().Info("order is requeued",
+		zap.String("orderNumber", number),
+		zap.String("action", action),
+		zap.String("status", order.Status),
+		zap.Int64("adminUserID", adminUserID),
+	)
+
+	order, err = s.OrderRepository.GetOrderByNumber(ctx, number)
+	if err != nil {
+		return nil, fmt.Errorf("order repository get order by number: %w", err)
+	}
+	return order, nil
+}
+
+// RequeueOrders sends all orders in status uploaded in [from, to) back to processing on behalf of the operator,
+// zero from or to doesn't limit the range. The numbers of the requeued orders are returned.
+func (s *OrderService) RequeueOrders(ctx context.Context, adminUserID int64, status string, from, to time.Time) ([]string, error) {
+	switch status {
+	case NewOrderStatus, RegisteredOrderStatus, ProcessingOrderStatus, InvalidOrderStatus, ProcessedOrderStatus:
+	case "":
+		return nil, fmt.Errorf("%w: status is required", ErrOrderRequeueInvalid)
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrOrderRequeueInvalid, status)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrOrderRequeueInvalid)
+	}
+
+	target := []string{"status=" + status}
+	if !from.IsZero() {
+		target = append(target, "from="+from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		target = append(target, "to="+to.Format(time.RFC3339))
+	}
+	numbers, err := s.OrderRepository.RequeueOrders(ctx,
+		repository.OrderFilter{Status: status, UploadedFrom: from, UploadedTo: to},
+		requeueStatus(status),
+		repository.AdminAction{AdminUserID: adminUserID, Action: repository.AdminActionOrdersRequeue, Target: strings.Join(target, " ")},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("order repository requeue orders: %w", err)
+	}
+
+	logger.Logger().Info("orders are requeued",
+		zap.String("status", status),
+		zap.Time("from", from),
+		zap.Time("to", to),
+		zap.Int("count", len(numbers)),
+		zap.Int64("adminUserID", adminUserID),
+	)
+	return numbers, nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"math/rand"
+	"time"
+)
+
+// OrderRetryPolicy describes how processing of an order is postponed after a failed attempt.
+type OrderRetryPolicy struct {
+	// BaseDelay is the delay after the first failed attempt, it doubles with every next one
+	BaseDelay time.Duration
+	// MaxDelay caps the delay, so an order is still polled regularly during a long outage
+	MaxDelay time.Duration
+	// Jitter is the fraction of the delay randomly added or subtracted,
+	// it keeps orders failed together from being retried together
+	Jitter float64
+}
+
+// DefaultOrderRetryPolicy is used by OrderService unless another policy is set.
+var DefaultOrderRetryPolicy = OrderRetryPolicy{
+	BaseDelay: time.Second,
+	MaxDelay:  5 * time.Minute,
+	Jitter:    0.2,
+}
+
+// Delay returns the delay before the next attempt after attempts failed attempts in a row.
+func (p OrderRetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.MaxDelay
+	if attempts < 1 {
+		attempts = 1
+	}
+	// BaseDelay << 62 overflows, the cap is reached much earlier anyway
+	if attempts <= 32 {
+		if d := p.BaseDelay << (attempts - 1); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+
+	if p.Jitter > 0 {
+		delta := float64(delay) * p.Jitter * (2*rand.Float64() - 1)
+		delay += time.Duration(delta)
+	}
+
+	return delay
+}
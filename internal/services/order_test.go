@@ -0,0 +1,212 @@
+package services_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/accrual"
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testOrderNumber = "12345678903"
+	testLeaseOwner  = "instance-1"
+)
+
+// newOrderService returns the service with the accrual system answering every request by handler
+func newOrderService(t *testing.T, ctrl *gomock.Controller, handler http.HandlerFunc) (*services.OrderService, *mock.MockOrderRepository, *mock.MockTransactionRepository) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	mockOrderRepository := mock.NewMockOrderRepository(ctrl)
+	mockTransactionRepository := mock.NewMockTransactionRepository(ctrl)
+	orderService := services.NewOrderService(
+		services.NewTransactionService(mockTransactionRepository),
+		mockOrderRepository,
+		accrual.NewAccrualService(server.URL),
+	)
+	return orderService, mockOrderRepository, mockTransactionRepository
+}
+
+func accrualResponse(status string, amount string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"order":%q,"status":%q,"accrual":%s}`, testOrderNumber, status, amount)
+	}
+}
+
+func TestOrderProcessingAccrualStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		accrualStatus string
+		amount        string
+		// pollStatus is the order status stored until the next poll, empty for a final status
+		pollStatus  string
+		finalStatus string
+		accrual     money.Amount
+	}{
+		{
+			name:          "registered is shown as processing",
+			accrualStatus: accrual.StatusRegistered,
+			amount:        "0",
+			pollStatus:    services.ProcessingOrderStatus,
+		},
+		{
+			name:          "processing",
+			accrualStatus: accrual.StatusProcessing,
+			amount:        "0",
+			pollStatus:    services.ProcessingOrderStatus,
+		},
+		{
+			name:          "processed",
+			accrualStatus: accrual.StatusProcessed,
+			amount:        "500.5",
+			finalStatus:   services.ProcessedOrderStatus,
+			accrual:       money.FromMinor(50050),
+		},
+		{
+			name:          "invalid",
+			accrualStatus: accrual.StatusInvalid,
+			amount:        "0",
+			finalStatus:   services.InvalidOrderStatus,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			orderService, mockOrderRepository, mockTransactionRepository := newOrderService(t, ctrl,
+				accrualResponse(test.accrualStatus, test.amount))
+			orderService.PollInterval = 3 * time.Second
+
+			if test.pollStatus != "" {
+				mockOrderRepository.EXPECT().
+					ScheduleOrderPoll(gomock.Any(), testOrderNumber, testLeaseOwner, test.pollStatus, 3*time.Second).
+					Return(nil)
+			} else {
+				mockTransactionRepository.EXPECT().
+					AccrualAmount(gomock.Any(), int64(42), testOrderNumber, testLeaseOwner, test.accrual, test.finalStatus).
+					Return(nil)
+			}
+
+			err := orderService.OrderProcessing(context.Background(), repository.Order{Number: testOrderNumber, UserID: 42, LeaseOwner: testLeaseOwner, Status: services.NewOrderStatus})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestOrderProcessingUnknownAccrualStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	orderService, _, _ := newOrderService(t, ctrl, accrualResponse("CANCELLED", "0"))
+
+	err := orderService.OrderProcessing(context.Background(), repository.Order{Number: testOrderNumber, UserID: 42, LeaseOwner: testLeaseOwner, Status: services.NewOrderStatus})
+	require.ErrorIs(t, err, services.ErrUnknownAccrualStatus)
+}
+
+func TestOrderProcessingWithRetryPollsAgain(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	orderService, mockOrderRepository, _ := newOrderService(t, ctrl, accrualResponse(accrual.StatusProcessing, "0"))
+
+	// a calculation in progress is not a failure, no retry is scheduled
+	mockOrderRepository.EXPECT().
+		ScheduleOrderPoll(gomock.Any(), testOrderNumber, testLeaseOwner, services.ProcessingOrderStatus, services.DefaultOrderPollInterval).
+		Return(nil)
+	err := orderService.OrderProcessingWithRetry(context.Background(),
+		repository.Order{Number: testOrderNumber, UserID: 42, LeaseOwner: testLeaseOwner, Status: services.ProcessingOrderStatus, Attempts: 2})
+	require.NoError(t, err)
+}
+
+func TestOrderProcessingNotRegistered(t *testing.T) {
+	requeuedAt := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name                string
+		registrationTimeout time.Duration
+		order               repository.Order
+		// invalid is true when the order is marked INVALID instead of a retry
+		invalid bool
+	}{
+		{
+			name:                "before timeout",
+			registrationTimeout: 24 * time.Hour,
+			order:               repository.Order{UploadedAt: time.Now().Add(-23 * time.Hour)},
+		},
+		{
+			name:                "after timeout",
+			registrationTimeout: 24 * time.Hour,
+			order:               repository.Order{UploadedAt: time.Now().Add(-25 * time.Hour)},
+			invalid:             true,
+		},
+		{
+			name:                "requeue restarts timeout",
+			registrationTimeout: 24 * time.Hour,
+			order:               repository.Order{UploadedAt: time.Now().Add(-48 * time.Hour), RequeuedAt: &requeuedAt},
+		},
+		{
+			name:                "zero timeout retries forever",
+			registrationTimeout: 0,
+			order:               repository.Order{UploadedAt: time.Now().Add(-365 * 24 * time.Hour)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			orderService, mockOrderRepository, mockTransactionRepository := newOrderService(t, ctrl,
+				func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				})
+			orderService.RegistrationTimeout = test.registrationTimeout
+
+			if test.invalid {
+				mockTransactionRepository.EXPECT().
+					AccrualAmount(gomock.Any(), int64(42), testOrderNumber, testLeaseOwner, money.Amount(0), services.InvalidOrderStatus).
+					Return(nil)
+			} else {
+				mockOrderRepository.EXPECT().
+					ScheduleOrderRetry(gomock.Any(), testOrderNumber, testLeaseOwner, gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ interface{}, _ string, _ string, delay time.Duration, lastError string) error {
+						require.Positive(t, delay)
+						require.Contains(t, lastError, services.ErrOrderNotRegistered.Error())
+						return nil
+					})
+			}
+
+			order := test.order
+			order.Number = testOrderNumber
+			order.UserID = 42
+			order.LeaseOwner = testLeaseOwner
+			order.Status = services.NewOrderStatus
+			err := orderService.OrderProcessingWithRetry(context.Background(), order)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestOrderProcessingWithRetryLeaseLost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	orderService, _, mockTransactionRepository := newOrderService(t, ctrl, accrualResponse(accrual.StatusProcessed, "500"))
+
+	// another instance has taken the order over, the stale result is dropped without a retry
+	mockTransactionRepository.EXPECT().
+		AccrualAmount(gomock.Any(), int64(42), testOrderNumber, testLeaseOwner, money.FromPoints(500), services.ProcessedOrderStatus).
+		Return(repository.ErrOrderLeaseLost)
+	err := orderService.OrderProcessingWithRetry(context.Background(),
+		repository.Order{Number: testOrderNumber, UserID: 42, LeaseOwner: testLeaseOwner, Status: services.ProcessingOrderStatus})
+	require.NoError(t, err)
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrUserPasswordWeak = errors.New("password does not meet the policy")
+
+// PasswordPolicy describes passwords accepted on registration and password change,
+// passwords of existing users are not checked on login.
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength bounds the cost of hashing a password, 0 means no limit
+	MaxLength     int
+	RequireLetter bool
+	RequireDigit  bool
+}
+
+// DefaultPasswordPolicy is used by UserService unless another policy is set.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:     8,
+	MaxLength:     128,
+	RequireLetter: true,
+	RequireDigit:  true,
+}
+
+// Validate returns an error wrapping ErrUserPasswordWeak which tells the user what is wrong with the password.
+// Lengths are counted in characters, not bytes.
+func (p PasswordPolicy) Validate(login string, password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w: password must be at least %d characters long", ErrUserPasswordWeak, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("%w: password must be at most %d characters long", ErrUserPasswordWeak, p.MaxLength)
+	}
+	if p.RequireLetter && strings.IndexFunc(password, unicode.IsLetter) < 0 {
+		return fmt.Errorf("%w: password must contain a letter", ErrUserPasswordWeak)
+	}
+	if p.RequireDigit && strings.IndexFunc(password, unicode.IsDigit) < 0 {
+		return fmt.Errorf("%w: password must contain a digit", ErrUserPasswordWeak)
+	}
+	if login != "" && strings.EqualFold(password, login) {
+		return fmt.Errorf("%w: password must differ from the login", ErrUserPasswordWeak)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const maxReversalReasonLength = 1000
+
+var (
+	ErrReversalInvalid           = errors.New("invalid reversal")
+	ErrWithdrawalNotFound        = errors.New("withdrawal not found")
+	ErrWithdrawalAlreadyReversed = errors.New("withdrawal already reversed")
+)
+
+// ReverseWithdrawal returns the points of the withdrawal to the user on behalf of the operator or the store,
+// the reason is required and kept with the reversal. A withdrawal is reversed at most once,
+// ErrWithdrawalAlreadyReversed is returned for the second reversal.
+func (s TransactionService) ReverseWithdrawal(
+	ctx context.Context,
+	actorUserID int64,
+	withdrawalTransactionID int64,
+	reason string,
+) (*repository.Reversal, error) {
+	reason, err := validateReversalReason(reason)
+	if err != nil {
+		return nil, err
+	}
+
+	reversal, err := s.transactionRepository.ReverseWithdrawal(ctx, repository.Reversal{
+		WithdrawalTransactionID: withdrawalTransactionID,
+		ActorUserID:             actorUserID,
+		Reason:                  reason,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrTransactionNotFound):
+			return nil, ErrWithdrawalNotFound
+		case errors.Is(err, repository.ErrWithdrawalAlreadyReversed):
+			return nil, ErrWithdrawalAlreadyReversed
+		}
+		return nil, fmt.Errorf("transaction storage: reverse withdrawal: %w", err)
+	}
+
+	logger.Logger().Info("withdrawal is reversed",
+		zap.Int64("userID", reversal.UserID),
+		zap.Int64("actorUserID", actorUserID),
+		zap.Int64("withdrawalTransactionID", withdrawalTransactionID),
+		zap.String("amount", reversal.Amount.String()),
+		zap.String("orderNumber", reversal.OrderNumber),
+	)
+	return reversal, nil
+}
+
+// ReverseOrderWithdrawals returns the points of all withdrawals in payment for the order which are not reversed yet,
+// the store cancels the whole purchase. ErrWithdrawalNotFound is returned if nothing was paid with points
+// for the order and ErrWithdrawalAlreadyReversed if all withdrawals are already reversed.
+func (s TransactionService) ReverseOrderWithdrawals(
+	ctx context.Context,
+	actorUserID int64,
+	orderNumber string,
+	reason string,
+) ([]repository.Reversal, error) {
+	reason, err := validateReversalReason(reason)
+	if err != nil {
+		return nil, err
+	}
+
+	withdrawals, err := s.transactionRepository.GetWithdrawalsByOrderNumber(ctx, orderNumber)
+	if err != nil {
+		return nil, fmt.Errorf("get withdrawals by order number %s: %w", orderNumber, err)
+	}
+	if len(withdrawals) == 0 {
+		return nil, ErrWithdrawalNotFound
+	}
+
+	reversals := make([]repository.Reversal, 0, len(withdrawals))
+	for _, withdrawal := range withdrawals {
+		if withdrawal.Reversal != nil {
+			continue
+		}
+		reversal, err := s.ReverseWithdrawal(ctx, actorUserID, withdrawal.TransactionID, reason)
+		if err != nil {
+			// a concurrent reversal has returned the points already
+			if errors.Is(err, ErrWithdrawalAlreadyReversed) {
+				continue
+			}
+			return nil, err
+		}
+		reversals = append(reversals, *reversal)
+	}
+	if len(reversals) == 0 {
+		return nil, ErrWithdrawalAlreadyReversed
+	}
+
+	return reversals, nil
+}
+
+// GetWithdrawals returns the withdrawals of the user with their reversals, the newest first
+func (s TransactionService) GetWithdrawals(ctx context.Context, userID int64) ([]repository.Withdrawal, error) {
+	withdrawals, err := s.transactionRepository.GetWithdrawalsByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get withdrawals by user id '%d': %w", userID, err)
+	}
+	return withdrawals, nil
+}
+
+func validateReversalReason(reason string) (string, error) {
+	reason = strings.TrimSpace(reason)
+	switch {
+	case reason == "":
+		return "", fmt.Errorf("%w: reason is required", ErrReversalInvalid)
+	case utf8.RuneCountInString(reason) > maxReversalReasonLength:
+		return "", fmt.Errorf("%w: reason must be at most %d characters long", ErrReversalInvalid, maxReversalReasonLength)
+	}
+	return reason, nil
+}
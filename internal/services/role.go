@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/andreevym/gophermart/internal/repository"
+)
+
+var ErrUserRoleUnknown = errors.New("unknown role")
+
+// Roles are the roles which can be granted to users.
+var Roles = []string{repository.RoleSupport, repository.RoleAdmin, repository.RoleStore}
+
+// GrantRole grants the role to the user with the login, it returns false if the user already has the role.
+// The role is put into access tokens issued after the next login or token refresh.
+func (us *UserService) GrantRole(ctx context.Context, login string, role string) (bool, error) {
+	return us.changeRole(ctx, login, role, true)
+}
+
+// RevokeRole revokes the role from the user with the login, it returns false if the user has no such role.
+// Access tokens issued before keep the role until they expire.
+func (us *UserService) RevokeRole(ctx context.Context, login string, role string) (bool, error) {
+	return us.changeRole(ctx, login, role, false)
+}
+
+func (us *UserService) changeRole(ctx context.Context, login string, role string, grant bool) (bool, error) {
+	if !isKnownRole(role) {
+		return false, fmt.Errorf("%w %q, expected one of %q", ErrUserRoleUnknown, role, Roles)
+	}
+
+	user, err := us.UserRepository.GetUserByUsername(ctx, NormalizeLogin(login))
+	if err != nil {
+		return false, fmt.Errorf("user repository get user by username: %w", err)
+	}
+
+	hasRole := false
+	roles := make([]string, 0, len(user.Roles)+1)
+	for _, granted := range user.Roles {
+		if granted == role {
+			hasRole = true
+			continue
+		}
+		roles = append(roles, granted)
+	}
+	if hasRole == grant {
+		return false, nil
+	}
+	if grant {
+		roles = append(roles, role)
+	}
+
+	err = us.UserRepository.SetUserRoles(ctx, user.ID, roles)
+	if err != nil {
+		return false, fmt.Errorf("user repository set user roles: %w", err)
+	}
+	return true, nil
+}
+
+func isKnownRole(role string) bool {
+	for _, known := range Roles {
+		if known == role {
+			return true
+		}
+	}
+	return false
+}
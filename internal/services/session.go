@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// maxUserAgentLength limits the stored user agent, the header is controlled by the client
+const maxUserAgentLength = 512
+
+// ClientInfo describes the device a user logs in from.
+type ClientInfo struct {
+	// Device is a name of the device given by the client application
+	Device    string
+	UserAgent string
+	IP        string
+}
+
+// NewClientInfo creates ClientInfo truncating values too long to store.
+func NewClientInfo(device string, userAgent string, ip string) ClientInfo {
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	if len(device) > maxUserAgentLength {
+		device = device[:maxUserAgentLength]
+	}
+	return ClientInfo{Device: device, UserAgent: userAgent, IP: ip}
+}
+
+// GetSessions returns the active sessions of the user.
+func (a *AuthService) GetSessions(ctx context.Context, userID int64) ([]repository.Session, error) {
+	sessions, err := a.sessionRepository.GetActiveSessionsByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("sessionRepository.GetActiveSessionsByUserID: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes a session of the user, tokens issued for the session are rejected afterwards.
+func (a *AuthService) RevokeSession(ctx context.Context, userID int64, sessionID string) error {
+	err := a.sessionRepository.RevokeSession(ctx, userID, sessionID)
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("sessionRepository.RevokeSession: %w", err)
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes all sessions of the user except the current one and returns their number.
+func (a *AuthService) RevokeOtherSessions(ctx context.Context, userID int64, currentSessionID string) (int64, error) {
+	revoked, err := a.sessionRepository.RevokeOtherSessions(ctx, userID, currentSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("sessionRepository.RevokeOtherSessions: %w", err)
+	}
+	return revoked, nil
+}
+
+// DeleteStaleSessions deletes the sessions not seen for longer than the refresh token lifetime,
+// all their refresh tokens have expired, so they can't be continued.
+func (a *AuthService) DeleteStaleSessions(ctx context.Context) (int64, error) {
+	deleted, err := a.sessionRepository.DeleteStaleSessions(ctx, time.Now().Add(-a.RefreshTokenTTL))
+	if err != nil {
+		return 0, fmt.Errorf("sessionRepository.DeleteStaleSessions: %w", err)
+	}
+	if deleted > 0 {
+		logger.Logger().Info("stale sessions are deleted", zap.Int64("count", deleted))
+	}
+	return deleted, nil
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
+	"github.com/andreevym/gophermart/pkg/totp"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultTOTPIssuer is the name of the service shown by authenticator apps
+	DefaultTOTPIssuer = "Gophermart"
+	// recoveryCodeCount is the number of recovery codes issued on enrollment
+	recoveryCodeCount = 10
+)
+
+// DefaultWithdrawTOTPThreshold is the withdrawal sum above which users with 2FA present a TOTP code.
+var DefaultWithdrawTOTPThreshold = money.FromPoints(1000)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("totp is already enabled")
+	ErrTOTPNotEnrolled    = errors.New("totp is not enrolled")
+	ErrTOTPCodeInvalid    = errors.New("totp code is invalid")
+	ErrTOTPCodeRequired   = errors.New("totp code is required")
+	ErrTOTPUnavailable    = errors.New("totp is not available")
+)
+
+// TOTPEnrollment is returned once on enrollment, neither the secret nor the recovery codes can be read again.
+type TOTPEnrollment struct {
+	Secret string
+	// URI is the otpauth:// URI authenticator apps scan from a QR code
+	URI           string
+	RecoveryCodes []string
+}
+
+// EnrollTOTP generates a new TOTP secret and recovery codes for the user.
+// The secret is used for login only after ConfirmTOTP, enrolling again replaces a not confirmed secret.
+func (a *AuthService) EnrollTOTP(ctx context.Context, userID int64) (*TOTPEnrollment, error) {
+	if a.TOTPRepository == nil {
+		return nil, ErrTOTPUnavailable
+	}
+	current, err := a.TOTPRepository.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("totpRepository.GetTOTP: %w", err)
+	}
+	if current != nil && current.EnabledAt != nil {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	user, err := a.userService.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("userRepository.GetUserByID: %w", err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("generate totp secret: %w", err)
+	}
+	recoveryCodes := make([]string, 0, recoveryCodeCount)
+	recoveryCodeHashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		recoveryCodes = append(recoveryCodes, code)
+		recoveryCodeHashes = append(recoveryCodeHashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	err = a.TOTPRepository.SaveTOTP(ctx, userID, secret, recoveryCodeHashes)
+	if err != nil {
+		return nil, fmt.Errorf("totpRepository.SaveTOTP: %w", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret:        secret,
+		URI:           totp.URI(a.TOTPIssuer, user.Username, secret),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// ConfirmTOTP enables 2FA of the user after the code proves the authenticator app has the secret.
+func (a *AuthService) ConfirmTOTP(ctx context.Context, userID int64, code string) error {
+	if a.TOTPRepository == nil {
+		return ErrTOTPUnavailable
+	}
+	t, err := a.TOTPRepository.GetTOTP(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("totpRepository.GetTOTP: %w", err)
+	}
+	if t == nil {
+		return ErrTOTPNotEnrolled
+	}
+	if t.EnabledAt != nil {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	err = a.useTOTPCode(ctx, t, code)
+	if err != nil {
+		return err
+	}
+
+	err = a.TOTPRepository.EnableTOTP(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("totpRepository.EnableTOTP: %w", err)
+	}
+	return nil
+}
+
+// DisableTOTP turns 2FA of the user off, the code may be a TOTP code or a recovery code.
+func (a *AuthService) DisableTOTP(ctx context.Context, userID int64, code string) error {
+	t, err := a.enabledTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return ErrTOTPNotEnrolled
+	}
+
+	err = a.verifySecondFactor(ctx, t, code)
+	if err != nil {
+		return err
+	}
+
+	err = a.TOTPRepository.DeleteTOTP(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("totpRepository.DeleteTOTP: %w", err)
+	}
+	return nil
+}
+
+// VerifyWithdrawal requires a fresh TOTP code for a withdrawal above WithdrawTOTPThreshold if the user has 2FA enabled,
+// recovery codes are not accepted. Invalid codes are counted as failed login attempts of the user,
+// so a stolen access token can't be used to guess the code.
+func (a *AuthService) VerifyWithdrawal(ctx context.Context, userID int64, sum money.Amount, code string) error {
+	if sum <= a.WithdrawTOTPThreshold {
+		return nil
+	}
+
+	t, err := a.enabledTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return nil
+	}
+	if code == "" {
+		return ErrTOTPCodeRequired
+	}
+
+	user, err := a.userService.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("userRepository.GetUserByID: %w", err)
+	}
+	err = a.LoginThrottle.Check(ctx, NormalizeLogin(user.Username), "")
+	if err != nil {
+		return err
+	}
+
+	err = a.useTOTPCode(ctx, t, code)
+	if errors.Is(err, ErrTOTPCodeInvalid) {
+		if err := a.LoginThrottle.RecordFailure(ctx, NormalizeLogin(user.Username), ""); err != nil {
+			logger.Logger().Error("loginThrottle.RecordFailure", zap.Error(err))
+		}
+	}
+	return err
+}
+
+// enabledTOTP returns nil if the user has no confirmed TOTP secret or 2FA is not available
+func (a *AuthService) enabledTOTP(ctx context.Context, userID int64) (*repository.TOTP, error) {
+	if a.TOTPRepository == nil {
+		return nil, nil
+	}
+	t, err := a.TOTPRepository.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("totpRepository.GetTOTP: %w", err)
+	}
+	if t == nil || t.EnabledAt == nil {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// verifySecondFactor accepts a TOTP code or an unused recovery code
+func (a *AuthService) verifySecondFactor(ctx context.Context, t *repository.TOTP, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return a.useTOTPCode(ctx, t, code)
+	}
+
+	used, err := a.TOTPRepository.UseRecoveryCode(ctx, t.UserID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("totpRepository.UseRecoveryCode: %w", err)
+	}
+	if !used {
+		return ErrTOTPCodeInvalid
+	}
+	logger.Logger().Info("recovery code is used", zap.Int64("userID", t.UserID))
+	return nil
+}
+
+// useTOTPCode validates the code and stores its step, so the code can't be presented again
+func (a *AuthService) useTOTPCode(ctx context.Context, t *repository.TOTP, code string) error {
+	step, err := totp.Validate(t.Secret, code, time.Now())
+	if err != nil {
+		if errors.Is(err, totp.ErrInvalidCode) {
+			return ErrTOTPCodeInvalid
+		}
+		return fmt.Errorf("totp.Validate: %w", err)
+	}
+
+	fresh, err := a.TOTPRepository.UseTOTPStep(ctx, t.UserID, step)
+	if err != nil {
+		return fmt.Errorf("totpRepository.UseTOTPStep: %w", err)
+	}
+	if !fresh {
+		return ErrTOTPCodeInvalid
+	}
+	return nil
+}
+
+// randomRecoveryCode returns a code like "k7vq2-m3xha", easy to type from a printout
+func randomRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode lets the user type a code in any case and without the dash
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
@@ -2,67 +2,100 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/ShiraazMoollatjie/goluhn"
 	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/money"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrWithdrawInsufficientFunds  = errors.New("insufficient funds for withdraw")
+	ErrWithdrawInvalidOrderNumber = errors.New("invalid withdraw order number")
+	ErrWithdrawInvalidAmount      = errors.New("withdraw amount must be positive")
 )
 
 type TransactionService struct {
 	transactionRepository repository.TransactionRepository
+	// HoldTTL is how long a hold reserves points unless it's captured or released
+	HoldTTL time.Duration
 }
 
 const (
-	WithdrawUserID = 1
-	AccrualUserID  = 2
+	WithdrawUserID   = 1
+	AccrualUserID    = 2
+	AdjustmentUserID = -1
 )
 
-func (s TransactionService) Withdraw(ctx context.Context, fromUserID int64, amount float32, orderNumber string) error {
-	transaction := repository.Transaction{
-		FromUserID:    fromUserID,
-		ToUserID:      WithdrawUserID,
-		Amount:        amount,
-		OperationType: repository.WithdrawOperationType,
-		OrderNumber:   orderNumber,
+// Withdraw debits amount from the user balance in payment for the order,
+// the balance check and the debit are done atomically by the repository
+func (s TransactionService) Withdraw(ctx context.Context, fromUserID int64, amount money.Amount, orderNumber string) error {
+	if err := validateWithdraw(amount, orderNumber); err != nil {
+		return err
 	}
-	_, err := s.transactionRepository.CreateTransaction(ctx, transaction)
+
+	_, err := s.transactionRepository.Withdraw(ctx, fromUserID, orderNumber, amount)
 	if err != nil {
-		return fmt.Errorf("transaction storage: save transaction: %w", err)
+		if errors.Is(err, repository.ErrInsufficientFunds) {
+			return ErrWithdrawInsufficientFunds
+		}
+		return fmt.Errorf("transaction storage: withdraw: %w", err)
 	}
 	return nil
 }
 
-func (s TransactionService) GetCurrentBalance(ctx context.Context, userID int64) (float32, error) {
-	transactions, err := s.transactionRepository.GetTransactionsByUserID(ctx, userID)
+func validateWithdraw(amount money.Amount, orderNumber string) error {
+	if amount <= 0 {
+		return ErrWithdrawInvalidAmount
+	}
+	if err := goluhn.Validate(orderNumber); err != nil {
+		return fmt.Errorf("%w: %s", ErrWithdrawInvalidOrderNumber, err.Error())
+	}
+	return nil
+}
+
+// GetBalance returns the account of the user with the points reserved by holds
+func (s TransactionService) GetBalance(ctx context.Context, userID int64) (*repository.Account, error) {
+	account, err := s.transactionRepository.GetAccount(ctx, userID)
 	if err != nil {
-		return 0, fmt.Errorf("get user account by user id '%d': %w", userID, err)
+		return nil, fmt.Errorf("get user account by user id '%d': %w", userID, err)
 	}
-	var balance float32
-	for _, transaction := range transactions {
-		if transaction.FromUserID == userID {
-			balance -= transaction.Amount
-		} else if transaction.ToUserID == userID {
-			balance += transaction.Amount
-		} else {
-			panic("should not be here")
-		}
+
+	return account, nil
+}
+
+// GetCurrentBalance returns the points the user can withdraw, the held points are not included
+func (s TransactionService) GetCurrentBalance(ctx context.Context, userID int64) (money.Amount, error) {
+	account, err := s.transactionRepository.GetAccount(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("get user account by user id '%d': %w", userID, err)
 	}
 
-	return balance, nil
+	return account.Available(), nil
 }
 
-func (s TransactionService) GetWithdrawBalance(ctx context.Context, userID int64) (float32, error) {
-	transactions, err := s.transactionRepository.GetTransactionsByUserIDAndOperationType(ctx, userID, repository.WithdrawOperationType)
+func (s TransactionService) GetWithdrawBalance(ctx context.Context, userID int64) (money.Amount, error) {
+	account, err := s.transactionRepository.GetAccount(ctx, userID)
 	if err != nil {
 		return 0, fmt.Errorf("get user account by user id '%d': %w", userID, err)
 	}
-	var balance float32
-	for _, transaction := range transactions {
-		if transaction.FromUserID == userID {
-			balance += transaction.Amount
-		}
+
+	return account.Withdrawn, nil
+}
+
+// ReconcileAccounts recomputes user balances from the ledger and reports the accounts which drifted,
+// with fix the accounts are overwritten with the recomputed balances
+func (s TransactionService) ReconcileAccounts(ctx context.Context, fix bool) ([]repository.AccountDrift, error) {
+	drifts, err := s.transactionRepository.ReconcileAccounts(ctx, fix)
+	if err != nil {
+		return nil, fmt.Errorf("reconcile accounts: %w", err)
 	}
 
-	return balance, nil
+	return drifts, nil
 }
 
 func (s TransactionService) GetWithdrawTransaction(ctx context.Context, userID int64) ([]repository.Transaction, error) {
@@ -73,8 +106,14 @@ func (s TransactionService) GetWithdrawTransaction(ctx context.Context, userID i
 	return transactions, nil
 }
 
-func (s TransactionService) AccrualAmount(ctx context.Context, orderUserID int64, orderNumber string, orderAccrual float32, orderStatus string) error {
-	err := s.transactionRepository.AccrualAmount(ctx, orderUserID, orderNumber, orderAccrual, orderStatus)
+// AccrualAmount credits the order accrual and finalizes the order,
+// an already finalized order is left as is, so reprocessing the order never credits the user twice
+func (s TransactionService) AccrualAmount(ctx context.Context, orderUserID int64, orderNumber string, leaseOwner string, orderAccrual money.Amount, orderStatus string) error {
+	err := s.transactionRepository.AccrualAmount(ctx, orderUserID, orderNumber, leaseOwner, orderAccrual, orderStatus)
+	if errors.Is(err, repository.ErrOrderAlreadyFinalized) {
+		logger.Logger().Info("order is already finalized, accrual is skipped", zap.String("orderNumber", orderNumber))
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to accrual amount for userID '%d' and order number %s: %w", orderUserID, orderNumber, err)
 	}
@@ -85,5 +124,15 @@ func (s TransactionService) AccrualAmount(ctx context.Context, orderUserID int64
 func NewTransactionService(transactionRepository repository.TransactionRepository) *TransactionService {
 	return &TransactionService{
 		transactionRepository: transactionRepository,
+		HoldTTL:               DefaultHoldTTL,
 	}
 }
+
+// GetTransactions returns the whole ledger of the user, the oldest transactions first
+func (s TransactionService) GetTransactions(ctx context.Context, userID int64) ([]repository.Transaction, error) {
+	transactions, err := s.transactionRepository.GetTransactionsByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get transactions by user id '%d': %w", userID, err)
+	}
+	return transactions, nil
+}
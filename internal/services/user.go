@@ -4,10 +4,16 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/pkg/logger"
+	"github.com/andreevym/gophermart/pkg/password"
+	"go.uber.org/zap"
 )
 
 var (
@@ -16,54 +22,194 @@ var (
 	ErrUserAlreadyExists   = errors.New("user already exists")
 )
 
+// dummyPasswordHash is verified when there is no usable password to check,
+// so that unknown and deleted logins take as long as a wrong password.
+const dummyPasswordHash = "$argon2id$v=19$m=65536,t=3,p=2$QRregZCo8rVasNPqm0/SCw$wpli1gk+D6f+kqGOCtqFSgmhwLwtcZx0ckaaamDjc1g"
+
 // UserService struct represents the service for users
 type UserService struct {
 	UserRepository repository.UserRepository
+	// PasswordPolicy is applied to new passwords
+	PasswordPolicy PasswordPolicy
 }
 
 // NewUserService creates a new instance of UserService
 func NewUserService(userRepository repository.UserRepository) *UserService {
-	return &UserService{UserRepository: userRepository}
+	return &UserService{UserRepository: userRepository, PasswordPolicy: DefaultPasswordPolicy}
 }
 
 // AuthenticateUser authenticates a user.
-func (us *UserService) AuthenticateUser(ctx context.Context, username string, password string) (*repository.User, error) {
+// Legacy plaintext passwords and hashes with outdated parameters are re-hashed
+// after a successful verification.
+func (us *UserService) AuthenticateUser(ctx context.Context, username string, plainPassword string) (*repository.User, error) {
 	// Retrieve the user by username
 	user, err := us.UserRepository.GetUserByUsername(ctx, username)
 	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			_, _, _ = password.Verify(plainPassword, dummyPasswordHash)
+		}
 		return nil, fmt.Errorf("user repository get user by username %w", err)
 	}
+	// an anonymized user has no usable password, the check doesn't rely on it
+	if user.Deleted != nil {
+		_, _, _ = password.Verify(plainPassword, dummyPasswordHash)
+		return nil, ErrUserPasswordInvalid
+	}
 
 	// Verify the password
-	if user.Password != password { // For simplicity, you should compare hashed passwords here.
+	match, needsRehash, err := password.Verify(plainPassword, user.Password)
+	if err != nil {
+		return nil, fmt.Errorf("verify password for user %d: %w", user.ID, err)
+	}
+	if !match {
 		return nil, ErrUserPasswordInvalid
 	}
 
+	if needsRehash {
+		// the login must not fail because of upgrade, the next login will retry it
+		if err = us.rehashPassword(ctx, user, plainPassword); err != nil {
+			logger.Logger().Warn("rehash password", zap.Int64("userID", user.ID), zap.Error(err))
+		}
+	}
+
 	return user, nil
 }
 
-func (us *UserService) CreateUser(ctx context.Context, username string, password string) error {
-	if len(password) == 0 {
+// AuthenticateUserByID checks the password of an already authenticated user before a sensitive change.
+func (us *UserService) AuthenticateUserByID(ctx context.Context, userID int64, plainPassword string) (*repository.User, error) {
+	user, err := us.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user repository get user by id: %w", err)
+	}
+
+	return us.AuthenticateUser(ctx, user.Username, plainPassword)
+}
+
+func (us *UserService) rehashPassword(ctx context.Context, user *repository.User, plainPassword string) error {
+	hash, err := password.Hash(plainPassword)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	updatedUser := *user
+	updatedUser.Password = hash
+	err = us.UserRepository.UpdateUser(ctx, updatedUser)
+	if err != nil {
+		return fmt.Errorf("user repository update user: %w", err)
+	}
+
+	user.Password = hash
+	return nil
+}
+
+// CreateUser registers a user with the normalized login, ErrUserAlreadyExists is returned
+// if the login is taken, even by a concurrent registration.
+func (us *UserService) CreateUser(ctx context.Context, username string, plainPassword string) error {
+	username = NormalizeLogin(username)
+	if err := ValidateLogin(username); err != nil {
+		return err
+	}
+	if len(plainPassword) == 0 {
 		return ErrUserPasswordEmpty
 	}
+	if err := us.PasswordPolicy.Validate(username, plainPassword); err != nil {
+		return err
+	}
 
-	// Check if the user already exists
+	// Check if the user already exists, it saves hashing the password,
+	// a concurrent registration is caught by the unique index
 	_, err := us.UserRepository.GetUserByUsername(ctx, username)
 	if err == nil {
 		return ErrUserAlreadyExists
 	}
 
+	hash, err := password.Hash(plainPassword)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
 	// Create a new user
 	newUser := repository.User{
 		Username: username,
-		Password: password, // For simplicity, you should hash the password before storing it.
+		Password: hash,
 	}
 
 	// Save the user to the repository
 	err = us.UserRepository.CreateUser(ctx, newUser)
 	if err != nil {
+		if errors.Is(err, repository.ErrUserAlreadyExists) {
+			return ErrUserAlreadyExists
+		}
 		return fmt.Errorf("user repository create user: %w", err)
 	}
 
 	return nil
 }
+
+// ChangePassword replaces the password of the user after checking the old one,
+// ErrUserPasswordInvalid is returned if the old password doesn't match.
+func (us *UserService) ChangePassword(ctx context.Context, userID int64, oldPassword string, newPassword string) error {
+	user, err := us.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("user repository get user by id: %w", err)
+	}
+
+	match, _, err := password.Verify(oldPassword, user.Password)
+	if err != nil {
+		return fmt.Errorf("verify password for user %d: %w", user.ID, err)
+	}
+	if !match {
+		return ErrUserPasswordInvalid
+	}
+
+	if len(newPassword) == 0 {
+		return ErrUserPasswordEmpty
+	}
+	if err = us.PasswordPolicy.Validate(user.Username, newPassword); err != nil {
+		return err
+	}
+	if newPassword == oldPassword {
+		return fmt.Errorf("%w: new password must differ from the old one", ErrUserPasswordWeak)
+	}
+
+	return us.rehashPassword(ctx, user, newPassword)
+}
+
+// AnonymizeUser replaces the login and the password of the user, so the account can't be used
+// and doesn't identify the person anymore. The row itself stays, orders and the ledger keep referencing it.
+func (us *UserService) AnonymizeUser(ctx context.Context, userID int64) error {
+	user, err := us.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("user repository get user by id: %w", err)
+	}
+
+	// a random password nobody knows, an empty one would match an empty legacy plaintext password
+	random := make([]byte, 20)
+	if _, err = rand.Read(random); err != nil {
+		return fmt.Errorf("generate anonymous credentials: %w", err)
+	}
+	hash, err := password.Hash(hex.EncodeToString(random[4:]))
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	now := time.Now()
+	anonymized := *user
+	anonymized.Username = fmt.Sprintf(deletedLoginPrefix+"%d-%s", user.ID, hex.EncodeToString(random[:4]))
+	anonymized.Password = hash
+	anonymized.Deleted = &now
+	err = us.UserRepository.UpdateUser(ctx, anonymized)
+	if err != nil {
+		return fmt.Errorf("user repository update user: %w", err)
+	}
+
+	// a deleted operator keeps no access
+	if len(user.Roles) > 0 {
+		err = us.UserRepository.SetUserRoles(ctx, userID, nil)
+		if err != nil {
+			return fmt.Errorf("user repository set user roles: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andreevym/gophermart/internal/repository"
+	"github.com/andreevym/gophermart/internal/repository/mock"
+	"github.com/andreevym/gophermart/internal/services"
+	"github.com/andreevym/gophermart/pkg/password"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticateUserTakesPasswordVerificationTime(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	hash, err := password.Hash("password")
+	require.NoError(t, err)
+	deleted := time.Now()
+
+	mockUserRepository := mock.NewMockUserRepository(ctrl)
+	mockUserRepository.EXPECT().GetUserByUsername(gomock.Any(), "user").
+		Return(&repository.User{ID: 1, Username: "user", Password: hash}, nil)
+	mockUserRepository.EXPECT().GetUserByUsername(gomock.Any(), "unknown").
+		Return(nil, repository.ErrUserNotFound)
+	mockUserRepository.EXPECT().GetUserByUsername(gomock.Any(), "deleted").
+		Return(&repository.User{ID: 2, Username: "deleted", Deleted: &deleted}, nil)
+	userService := services.NewUserService(mockUserRepository)
+
+	start := time.Now()
+	_, err = userService.AuthenticateUser(context.Background(), "user", "wrong")
+	require.ErrorIs(t, err, services.ErrUserPasswordInvalid)
+	wrongPassword := time.Since(start)
+
+	// the missing and anonymized logins must not be told apart by a faster answer
+	start = time.Now()
+	_, err = userService.AuthenticateUser(context.Background(), "unknown", "wrong")
+	require.ErrorIs(t, err, repository.ErrUserNotFound)
+	require.Greater(t, time.Since(start), wrongPassword/2)
+
+	start = time.Now()
+	_, err = userService.AuthenticateUser(context.Background(), "deleted", "wrong")
+	require.ErrorIs(t, err, services.ErrUserPasswordInvalid)
+	require.Greater(t, time.Since(start), wrongPassword/2)
+}
@@ -1,3 +1,4 @@
+-- +migrate Up
 CREATE SEQUENCE IF NOT EXISTS users_id_seq;
 
 CREATE TABLE IF NOT EXISTS users
@@ -8,10 +9,13 @@ CREATE TABLE IF NOT EXISTS users
     created_at TIMESTAMP WITH TIME ZONE          DEFAULT CURRENT_TIMESTAMP
 );
 
+-- system users, databases created before versioned migrations already have them
 INSERT INTO users (username, password)
-VALUES ('WithdrawUserID', 'qwe123');
+SELECT 'WithdrawUserID', 'qwe123'
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE username = 'WithdrawUserID');
 INSERT INTO users (username, password)
-VALUES ('AccrualUserID', 'qwe123');
+SELECT 'AccrualUserID', 'qwe123'
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE username = 'AccrualUserID');
 
 CREATE TABLE IF NOT EXISTS orders
 (
@@ -38,3 +42,10 @@ CREATE TABLE IF NOT EXISTS transactions
     FOREIGN KEY (from_user_id) REFERENCES users (id),
     FOREIGN KEY (to_user_id) REFERENCES users (id)
 );
+
+-- +migrate Down
+DROP TABLE IF EXISTS transactions;
+DROP SEQUENCE IF EXISTS transactions_id_seq;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS users;
+DROP SEQUENCE IF EXISTS users_id_seq;
@@ -0,0 +1,15 @@
+-- +migrate Up
+-- loyalty points are money, keep them as exact decimals instead of real,
+-- values are rounded to the minor unit, real keeps less than 7 significant digits anyway
+ALTER TABLE orders
+    ALTER COLUMN accrual TYPE NUMERIC(20, 2) USING round(accrual::numeric, 2);
+
+ALTER TABLE transactions
+    ALTER COLUMN amount TYPE NUMERIC(20, 2) USING round(amount::numeric, 2);
+
+-- +migrate Down
+ALTER TABLE orders
+    ALTER COLUMN accrual TYPE real USING accrual::real;
+
+ALTER TABLE transactions
+    ALTER COLUMN amount TYPE real USING amount::real;
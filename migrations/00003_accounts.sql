@@ -0,0 +1,23 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS accounts
+(
+    user_id    BIGINT PRIMARY KEY,
+    current    NUMERIC(20, 2)           NOT NULL DEFAULT 0,
+    withdrawn  NUMERIC(20, 2)           NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- backfill balances of the existing ledger, accounts are maintained by the application afterwards
+INSERT INTO accounts (user_id, current, withdrawn)
+SELECT ledger.user_id, SUM(ledger.current), SUM(ledger.withdrawn)
+FROM (SELECT to_user_id AS user_id, amount AS current, 0 AS withdrawn
+      FROM transactions
+      UNION ALL
+      SELECT from_user_id, -amount, CASE WHEN operation_type = 'withdraw' THEN amount ELSE 0 END
+      FROM transactions) ledger
+GROUP BY ledger.user_id
+ON CONFLICT (user_id) DO NOTHING;
+
+-- +migrate Down
+DROP TABLE IF EXISTS accounts;
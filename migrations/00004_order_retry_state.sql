@@ -0,0 +1,15 @@
+-- +migrate Up
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS attempts        INTEGER                  NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS last_error      TEXT;
+
+CREATE INDEX IF NOT EXISTS orders_status_next_attempt_at_idx ON orders (status, next_attempt_at);
+
+-- +migrate Down
+DROP INDEX IF EXISTS orders_status_next_attempt_at_idx;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS attempts,
+    DROP COLUMN IF EXISTS next_attempt_at,
+    DROP COLUMN IF EXISTS last_error;
@@ -0,0 +1,8 @@
+-- +migrate Up
+-- REGISTERED is a status of the accrual system, users see such orders as PROCESSING
+UPDATE orders
+SET status          = 'PROCESSING',
+    next_attempt_at = CURRENT_TIMESTAMP
+WHERE status = 'REGISTERED';
+
+-- +migrate Down
@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS lease_owner      TEXT,
+    ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP WITH TIME ZONE;
+
+-- +migrate Down
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS lease_owner,
+    DROP COLUMN IF EXISTS lease_expires_at;
@@ -0,0 +1,18 @@
+-- +migrate Up
+-- an order is credited once. The ledger is append-only, so the duplicated accruals are kept and only marked
+-- as duplicates, they are taken back by compensating entries in 00020_duplicate_accrual_reversals.sql
+UPDATE transactions duplicate
+SET operation_type = 'accrual_duplicate'
+FROM transactions kept
+WHERE duplicate.operation_type = 'accrual'
+  AND kept.operation_type = 'accrual'
+  AND duplicate.order_number = kept.order_number
+  AND duplicate.transaction_id > kept.transaction_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_order_number_accrual_uniq
+    ON transactions (order_number, operation_type) WHERE operation_type = 'accrual';
+
+-- +migrate Down
+DROP INDEX IF EXISTS transactions_order_number_accrual_uniq;
+
+UPDATE transactions SET operation_type = 'accrual' WHERE operation_type = 'accrual_duplicate';
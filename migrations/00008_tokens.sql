@@ -0,0 +1,27 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    token_hash TEXT PRIMARY KEY,
+    user_id    BIGINT                   NOT NULL,
+    family_id  TEXT                     NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at    TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- access tokens revoked before their expiry, a row is useless after expires_at
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    jti        TEXT PRIMARY KEY,
+    user_id    BIGINT                   NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
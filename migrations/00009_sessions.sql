@@ -0,0 +1,19 @@
+-- +migrate Up
+-- a session is created on login, its id is the family id of the refresh tokens and the fid claim of access tokens
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id           TEXT PRIMARY KEY,
+    user_id      BIGINT                   NOT NULL,
+    device       TEXT                     NOT NULL DEFAULT '',
+    user_agent   TEXT                     NOT NULL DEFAULT '',
+    ip           TEXT                     NOT NULL DEFAULT '',
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at   TIMESTAMP WITH TIME ZONE,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- +migrate Down
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,15 @@
+-- +migrate Up
+-- failed login attempts counted per login and per client address, a row is reset after a successful login
+-- or forgotten when no attempt has failed for the failure window
+CREATE TABLE IF NOT EXISTS login_failures
+(
+    scope           TEXT                     NOT NULL,
+    key             TEXT                     NOT NULL,
+    failures        INT                      NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until    TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (scope, key)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS login_failures;
@@ -0,0 +1,26 @@
+-- +migrate Up
+-- a secret is stored on enrollment and used for login only after the user confirms it with a code
+CREATE TABLE IF NOT EXISTS user_totp
+(
+    user_id        BIGINT PRIMARY KEY,
+    secret         TEXT                     NOT NULL,
+    created_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    enabled_at     TIMESTAMP WITH TIME ZONE,
+    -- the step of the last accepted code, a code is never accepted twice
+    last_used_step BIGINT                   NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- one-time codes for a lost authenticator, stored hashed
+CREATE TABLE IF NOT EXISTS totp_recovery_codes
+(
+    user_id   BIGINT NOT NULL,
+    code_hash TEXT   NOT NULL,
+    used_at   TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS totp_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
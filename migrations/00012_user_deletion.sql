@@ -0,0 +1,6 @@
+-- +migrate Up
+-- a deleted user is anonymized instead of removed, orders and the ledger keep referencing the row
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
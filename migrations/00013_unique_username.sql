@@ -0,0 +1,11 @@
+-- +migrate Up
+-- logins differing only in case were registered by concurrent or careless sign-ups, the oldest account keeps the login,
+-- the others are renamed and their owners log in with the new login
+UPDATE users u
+SET username = u.username || '-duplicate-' || u.id
+WHERE EXISTS (SELECT 1 FROM users o WHERE lower(o.username) = lower(u.username) AND o.id < u.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_uniq ON users (lower(username));
+
+-- +migrate Down
+DROP INDEX IF EXISTS users_username_lower_uniq;
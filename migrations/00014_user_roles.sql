@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS roles TEXT[] NOT NULL DEFAULT '{}';
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN IF EXISTS roles;
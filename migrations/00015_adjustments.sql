@@ -0,0 +1,25 @@
+-- +migrate Up
+-- the system account manual adjustments are credited from and debited to, it has an id outside of the users sequence,
+-- so it is the same in every database, and it is marked deleted, so nobody can log in as it
+INSERT INTO users (id, username, password, deleted_at)
+VALUES (-1, 'AdjustmentUserID', '', CURRENT_TIMESTAMP)
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS adjustments
+(
+    transaction_id BIGINT PRIMARY KEY,
+    user_id        BIGINT                   NOT NULL,
+    admin_user_id  BIGINT                   NOT NULL,
+    reason         TEXT                     NOT NULL,
+    ticket         TEXT                     NOT NULL,
+    created_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (transaction_id) REFERENCES transactions (transaction_id),
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (admin_user_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS adjustments_user_id_idx ON adjustments (user_id);
+
+-- +migrate Down
+DROP TABLE IF EXISTS adjustments;
+-- the adjustment transactions reference the system account, they are kept with it
@@ -0,0 +1,23 @@
+-- +migrate Up
+-- the registration timeout of a requeued order is counted from the requeue instead of the upload
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS requeued_at TIMESTAMP WITH TIME ZONE;
+
+CREATE SEQUENCE IF NOT EXISTS admin_audit_log_id_seq;
+
+CREATE TABLE IF NOT EXISTS admin_audit_log
+(
+    id            BIGINT PRIMARY KEY                DEFAULT nextval('admin_audit_log_id_seq'),
+    admin_user_id BIGINT                   NOT NULL,
+    action        TEXT                     NOT NULL,
+    target        TEXT                     NOT NULL,
+    details       TEXT                     NOT NULL DEFAULT '',
+    created_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (admin_user_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_created_at_idx ON admin_audit_log (created_at);
+
+-- +migrate Down
+DROP TABLE IF EXISTS admin_audit_log;
+DROP SEQUENCE IF EXISTS admin_audit_log_id_seq;
+ALTER TABLE orders DROP COLUMN IF EXISTS requeued_at;
@@ -0,0 +1,18 @@
+-- +migrate Up
+-- a reversal returns the points of a withdrawal, it's a reversal transaction from the withdraw system account
+-- to the user linked to the withdraw transaction, a withdrawal is reversed at most once
+CREATE TABLE IF NOT EXISTS withdrawal_reversals
+(
+    transaction_id            BIGINT PRIMARY KEY,
+    withdrawal_transaction_id BIGINT                   NOT NULL UNIQUE,
+    actor_user_id             BIGINT                   NOT NULL,
+    reason                    TEXT                     NOT NULL,
+    created_at                TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (transaction_id) REFERENCES transactions (transaction_id),
+    FOREIGN KEY (withdrawal_transaction_id) REFERENCES transactions (transaction_id),
+    FOREIGN KEY (actor_user_id) REFERENCES users (id)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS withdrawal_reversals;
+-- the reversal transactions are kept, the withdrawn sums of the accounts are fixed by the reconcile command
@@ -0,0 +1,26 @@
+-- +migrate Up
+-- a hold reserves points of a user until it's captured as a withdrawal, released or expired,
+-- the held points are a part of the account current balance which can't be withdrawn
+CREATE SEQUENCE IF NOT EXISTS holds_id_seq;
+
+CREATE TABLE IF NOT EXISTS holds
+(
+    id             BIGINT PRIMARY KEY                DEFAULT nextval('holds_id_seq'),
+    user_id        BIGINT                   NOT NULL,
+    amount         NUMERIC(20, 2)           NOT NULL,
+    order_number   TEXT                     NOT NULL,
+    status         TEXT                     NOT NULL,
+    transaction_id BIGINT,
+    created_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at     TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (transaction_id) REFERENCES transactions (transaction_id)
+);
+
+CREATE INDEX IF NOT EXISTS holds_held_user_id_idx ON holds (user_id) WHERE status = 'HELD';
+CREATE INDEX IF NOT EXISTS holds_held_expires_at_idx ON holds (expires_at) WHERE status = 'HELD';
+
+-- +migrate Down
+DROP TABLE IF EXISTS holds;
+DROP SEQUENCE IF EXISTS holds_id_seq;
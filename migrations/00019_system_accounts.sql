@@ -0,0 +1,15 @@
+-- +migrate Up
+-- balances of the system accounts are computed from the ledger, the rows are not maintained anymore
+DELETE FROM accounts WHERE user_id IN (1, 2, -1);
+
+-- +migrate Down
+INSERT INTO accounts (user_id, current, withdrawn)
+SELECT ledger.user_id, SUM(ledger.current), 0
+FROM (SELECT to_user_id AS user_id, amount AS current
+      FROM transactions
+      UNION ALL
+      SELECT from_user_id, -amount
+      FROM transactions) ledger
+WHERE ledger.user_id IN (1, 2, -1)
+GROUP BY ledger.user_id
+ON CONFLICT (user_id) DO NOTHING;
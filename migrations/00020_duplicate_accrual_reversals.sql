@@ -0,0 +1,44 @@
+-- +migrate Up
+-- the duplicated accruals marked by 00007_unique_accrual_per_order.sql are taken back from the users
+-- by compensating entries to the accrual account, balances of the system accounts are not stored
+INSERT INTO transactions (from_user_id, to_user_id, amount, order_number, operation_type)
+SELECT duplicate.to_user_id, duplicate.from_user_id, duplicate.amount, duplicate.order_number, 'accrual_duplicate_reversal'
+FROM transactions duplicate
+WHERE duplicate.operation_type = 'accrual_duplicate'
+ORDER BY duplicate.transaction_id;
+
+UPDATE accounts
+SET current    = accounts.current - deltas.amount,
+    updated_at = CURRENT_TIMESTAMP
+FROM (SELECT from_user_id AS user_id, SUM(amount) AS amount
+      FROM transactions
+      WHERE operation_type = 'accrual_duplicate_reversal'
+      GROUP BY from_user_id) deltas
+WHERE accounts.user_id = deltas.user_id;
+
+-- a user who has already spent the double credit is left with a negative balance, the migration isn't stopped,
+-- the balance is reported to the operator and settled by an adjustment
+DO
+$$
+    DECLARE
+        overdrawn RECORD;
+    BEGIN
+        FOR overdrawn IN SELECT user_id, current FROM accounts WHERE current < 0 ORDER BY user_id
+            LOOP
+                RAISE NOTICE 'taking back duplicated accruals made the balance of user % negative: %',
+                    overdrawn.user_id, overdrawn.current;
+            END LOOP;
+    END
+$$;
+
+-- +migrate Down
+UPDATE accounts
+SET current    = accounts.current + deltas.amount,
+    updated_at = CURRENT_TIMESTAMP
+FROM (SELECT from_user_id AS user_id, SUM(amount) AS amount
+      FROM transactions
+      WHERE operation_type = 'accrual_duplicate_reversal'
+      GROUP BY from_user_id) deltas
+WHERE accounts.user_id = deltas.user_id;
+
+DELETE FROM transactions WHERE operation_type = 'accrual_duplicate_reversal';
@@ -0,0 +1,11 @@
+-- +migrate Up
+-- the withdraw and accrual system accounts were seeded with a published plaintext password,
+-- they are disabled like the adjustment system account, so nobody can log in as them
+UPDATE users
+SET deleted_at = CURRENT_TIMESTAMP,
+    password   = ''
+WHERE id IN (1, 2)
+  AND deleted_at IS NULL;
+
+-- +migrate Down
+-- the system accounts stay disabled, their seeded password must never be restored
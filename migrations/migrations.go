@@ -0,0 +1,18 @@
+// Package migrations embeds the database schema migrations into the binary.
+//
+// Every file is named <version>_<name>.sql and contains an up section and an
+// optional down section:
+//
+//	-- +migrate Up
+//	CREATE TABLE ...;
+//
+//	-- +migrate Down
+//	DROP TABLE ...;
+package migrations
+
+import "embed"
+
+// FS contains all migration files.
+//
+//go:embed *.sql
+var FS embed.FS
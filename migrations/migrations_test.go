@@ -0,0 +1,15 @@
+package migrations_test
+
+import (
+	"testing"
+
+	"github.com/andreevym/gophermart/internal/repository/postgres"
+	"github.com/andreevym/gophermart/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationsParse(t *testing.T) {
+	migrator, err := postgres.NewMigrator(nil, migrations.FS)
+	require.NoError(t, err)
+	require.NotNil(t, migrator)
+}
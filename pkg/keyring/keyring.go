@@ -0,0 +1,265 @@
+// Package keyring keeps the ECDSA P-256 keys tokens are signed (ES256) and verified with.
+//
+// The ring has one signing key and any number of verification keys, every key is identified by its
+// RFC 7638 thumbprint which is put in the kid header of a signed token. During a rotation the new key
+// becomes the signing one and the previous key stays in the ring until the tokens signed with it expire.
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrNoKey          = errors.New("no key found")
+	ErrUnsupportedKey = errors.New("only ECDSA P-256 keys are supported")
+	ErrUnknownKeyID   = errors.New("unknown key id")
+)
+
+// Key is a public key of the ring with its key id.
+type Key struct {
+	ID        string
+	PublicKey *ecdsa.PublicKey
+}
+
+// KeyRing signs tokens with the signing key and verifies them with any key of the ring.
+type KeyRing struct {
+	signingKey   *ecdsa.PrivateKey
+	signingKeyID string
+	keys         map[string]*ecdsa.PublicKey
+	// order keeps the keys in the order they were added, the signing key is the first
+	order []string
+}
+
+// New creates a ring signing with signingKey, verificationKeys are accepted only for verification.
+func New(signingKey *ecdsa.PrivateKey, verificationKeys ...*ecdsa.PublicKey) (*KeyRing, error) {
+	if signingKey == nil {
+		return nil, ErrNoKey
+	}
+
+	r := &KeyRing{keys: make(map[string]*ecdsa.PublicKey)}
+	signingKeyID, err := r.add(&signingKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	r.signingKey = signingKey
+	r.signingKeyID = signingKeyID
+
+	for _, key := range verificationKeys {
+		_, err = r.add(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Generate creates a ring with a new random key, tokens signed with it can't be verified after restart.
+func Generate() (*KeyRing, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	return New(key)
+}
+
+// LoadFiles creates a ring signing with the private key of signingKeyFile,
+// verificationKeyFiles may contain either private or public keys.
+func LoadFiles(signingKeyFile string, verificationKeyFiles ...string) (*KeyRing, error) {
+	signingKeyPEM, err := os.ReadFile(signingKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+	signingKey, err := ParsePrivateKey(signingKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key %s: %w", signingKeyFile, err)
+	}
+
+	verificationKeys := make([]*ecdsa.PublicKey, 0, len(verificationKeyFiles))
+	for _, file := range verificationKeyFiles {
+		keyPEM, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read verification key: %w", err)
+		}
+		key, err := ParsePublicKey(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("parse verification key %s: %w", file, err)
+		}
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return New(signingKey, verificationKeys...)
+}
+
+// ParsePrivateKey parses a PEM encoded SEC 1 ("EC PRIVATE KEY") or PKCS #8 ("PRIVATE KEY") key,
+// raw SEC 1 DER is accepted as well for keys configured before PEM support.
+func ParsePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return checkCurve(key)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoKey, err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return checkCurve(key)
+}
+
+// ParsePublicKey parses a PEM encoded PKIX ("PUBLIC KEY") key or takes the public part of a private key.
+func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block != nil && block.Type == "PUBLIC KEY" {
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoKey, err)
+		}
+		key, ok := parsed.(*ecdsa.PublicKey)
+		if !ok || key.Curve != elliptic.P256() {
+			return nil, ErrUnsupportedKey
+		}
+		return key, nil
+	}
+
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return &key.PublicKey, nil
+}
+
+func checkCurve(key *ecdsa.PrivateKey) (*ecdsa.PrivateKey, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, ErrUnsupportedKey
+	}
+	return key, nil
+}
+
+func (r *KeyRing) add(key *ecdsa.PublicKey) (string, error) {
+	if key == nil || key.Curve != elliptic.P256() {
+		return "", ErrUnsupportedKey
+	}
+	id := Thumbprint(key)
+	if _, ok := r.keys[id]; !ok {
+		r.keys[id] = key
+		r.order = append(r.order, id)
+	}
+	return id, nil
+}
+
+// SigningKeyID returns the key id of the signing key.
+func (r *KeyRing) SigningKeyID() string {
+	return r.signingKeyID
+}
+
+// Sign signs the claims with ES256 and the signing key, the key id is put in the kid header.
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = r.signingKeyID
+	return token.SignedString(r.signingKey)
+}
+
+// Keyfunc returns the key a token is verified with, it's meant for jwt.Parse.
+// A token without kid is verified with the signing key, such tokens were issued before key ids were introduced.
+func (r *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return &r.signingKey.PublicKey, nil
+	}
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, kid)
+	}
+	return key, nil
+}
+
+// Keys returns all keys of the ring, the signing key is the first.
+func (r *KeyRing) Keys() []Key {
+	keys := make([]Key, 0, len(r.order))
+	for _, id := range r.order {
+		keys = append(keys, Key{ID: id, PublicKey: r.keys[id]})
+	}
+	return keys
+}
+
+// JWK is a public key in the JSON Web Key format, RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the ring, other services verify tokens with them.
+func (r *KeyRing) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(r.order))}
+	for _, key := range r.Keys() {
+		x, y := coordinates(key.PublicKey)
+		jwks.Keys = append(jwks.Keys, JWK{
+			KeyType:   "EC",
+			Curve:     "P-256",
+			X:         x,
+			Y:         y,
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodES256.Alg(),
+		})
+	}
+	return jwks
+}
+
+// Thumbprint returns the RFC 7638 JWK thumbprint of the key.
+func Thumbprint(key *ecdsa.PublicKey) string {
+	x, y := coordinates(key)
+	// the members are in lexicographic order and without whitespace, as RFC 7638 requires
+	canonical, _ := json.Marshal(struct {
+		Curve   string `json:"crv"`
+		KeyType string `json:"kty"`
+		X       string `json:"x"`
+		Y       string `json:"y"`
+	}{Curve: "P-256", KeyType: "EC", X: x, Y: y})
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// coordinates returns the base64url encoded coordinates padded to the curve size
+func coordinates(key *ecdsa.PublicKey) (string, string) {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	x := make([]byte, size)
+	y := make([]byte, size)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return base64.RawURLEncoding.EncodeToString(x), base64.RawURLEncoding.EncodeToString(y)
+}
@@ -0,0 +1,110 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	file := filepath.Join(t.TempDir(), "key.pem")
+	err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	require.NoError(t, err)
+	return file
+}
+
+func TestLoadFiles(t *testing.T) {
+	signingKey := generateKey(t)
+	sec1, err := x509.MarshalECPrivateKey(signingKey)
+	require.NoError(t, err)
+
+	pkcs8Key := generateKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(pkcs8Key)
+	require.NoError(t, err)
+
+	publicKey := generateKey(t)
+	pkix, err := x509.MarshalPKIXPublicKey(&publicKey.PublicKey)
+	require.NoError(t, err)
+
+	keyRing, err := LoadFiles(
+		writePEM(t, "EC PRIVATE KEY", sec1),
+		writePEM(t, "PRIVATE KEY", pkcs8),
+		writePEM(t, "PUBLIC KEY", pkix),
+	)
+	require.NoError(t, err)
+
+	keys := keyRing.Keys()
+	require.Len(t, keys, 3)
+	assert.Equal(t, Thumbprint(&signingKey.PublicKey), keyRing.SigningKeyID())
+	assert.Equal(t, keyRing.SigningKeyID(), keys[0].ID)
+	assert.Equal(t, Thumbprint(&pkcs8Key.PublicKey), keys[1].ID)
+	assert.Equal(t, Thumbprint(&publicKey.PublicKey), keys[2].ID)
+}
+
+func TestParsePrivateKeyRawDER(t *testing.T) {
+	key := generateKey(t)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	parsed, err := ParsePrivateKey(der)
+	require.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+
+	_, err = ParsePrivateKey([]byte("not a key"))
+	require.ErrorIs(t, err, ErrNoKey)
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalECPrivateKey(p384Key)
+	require.NoError(t, err)
+	_, err = ParsePrivateKey(der)
+	require.ErrorIs(t, err, ErrUnsupportedKey)
+}
+
+func TestRotation(t *testing.T) {
+	oldKey := generateKey(t)
+	newKey := generateKey(t)
+
+	oldRing, err := New(oldKey)
+	require.NoError(t, err)
+	oldToken, err := oldRing.Sign(jwt.MapClaims{"sub": "1"})
+	require.NoError(t, err)
+
+	// the new key signs, the old one only verifies
+	rotatedRing, err := New(newKey, &oldKey.PublicKey)
+	require.NoError(t, err)
+	newToken, err := rotatedRing.Sign(jwt.MapClaims{"sub": "1"})
+	require.NoError(t, err)
+
+	token, err := jwt.Parse(oldToken, rotatedRing.Keyfunc)
+	require.NoError(t, err)
+	assert.Equal(t, oldRing.SigningKeyID(), token.Header["kid"])
+
+	token, err = jwt.Parse(newToken, rotatedRing.Keyfunc)
+	require.NoError(t, err)
+	assert.Equal(t, rotatedRing.SigningKeyID(), token.Header["kid"])
+
+	// the old ring doesn't know the new key
+	_, err = jwt.Parse(newToken, oldRing.Keyfunc)
+	require.ErrorContains(t, err, ErrUnknownKeyID.Error())
+
+	jwks := rotatedRing.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, rotatedRing.SigningKeyID(), jwks.Keys[0].KeyID)
+	assert.Equal(t, oldRing.SigningKeyID(), jwks.Keys[1].KeyID)
+}
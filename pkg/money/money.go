@@ -0,0 +1,186 @@
+// Package money implements an exact fixed-point amount of loyalty points.
+//
+// 1 point = 1 ruble, amounts are kept as an integer number of minor units
+// (1/100 of a point), so sums never drift the way float values do.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Scale is the number of fraction digits kept by Amount.
+	Scale = 2
+	// minorUnits is the number of minor units in one point.
+	minorUnits = 100
+)
+
+var (
+	ErrInvalidAmount = errors.New("invalid amount")
+	ErrPrecision     = errors.New("amount has more than 2 fraction digits")
+	ErrOverflow      = errors.New("amount overflows")
+)
+
+// Amount is a number of loyalty points in minor units.
+type Amount int64
+
+// FromMinor creates an Amount from minor units.
+func FromMinor(minor int64) Amount {
+	return Amount(minor)
+}
+
+// FromPoints creates an Amount from whole points.
+func FromPoints(points int64) Amount {
+	return Amount(points * minorUnits)
+}
+
+// Minor returns the amount in minor units.
+func (a Amount) Minor() int64 {
+	return int64(a)
+}
+
+// Parse parses a decimal string like "500", "500.5" or "-42.05".
+// Exponent notation is accepted as long as the value is representable exactly.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrInvalidAmount
+	}
+
+	mantissa, exp, err := splitExponent(s)
+	if err != nil {
+		return 0, err
+	}
+
+	negative := false
+	switch mantissa[0] {
+	case '-':
+		negative = true
+		mantissa = mantissa[1:]
+	case '+':
+		mantissa = mantissa[1:]
+	}
+
+	intPart, fracPart, _ := strings.Cut(mantissa, ".")
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	digits := intPart + fracPart
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+		}
+	}
+
+	// the value is digits * 10^(exp - len(fracPart)), shift it to minor units
+	shift := exp - len(fracPart) + Scale
+	if shift < 0 {
+		cut := len(digits) + shift
+		if cut < 0 {
+			cut = 0
+		}
+		if strings.Trim(digits[cut:], "0") != "" {
+			return 0, fmt.Errorf("%w: %q", ErrPrecision, s)
+		}
+		digits = digits[:cut]
+	} else {
+		digits += strings.Repeat("0", shift)
+	}
+
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		return 0, nil
+	}
+	if len(digits) > 18 {
+		return 0, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if negative {
+		minor = -minor
+	}
+
+	return Amount(minor), nil
+}
+
+func splitExponent(s string) (string, int, error) {
+	i := strings.IndexAny(s, "eE")
+	if i < 0 {
+		return s, 0, nil
+	}
+
+	exp, err := strconv.Atoi(s[i+1:])
+	if err != nil || exp > 32 || exp < -32 || i == 0 {
+		return "", 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	return s[:i], exp, nil
+}
+
+// String formats the amount as a decimal number without trailing zeros, like "500.5".
+func (a Amount) String() string {
+	minor := int64(a)
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		if minor == math.MinInt64 {
+			return "-92233720368547758.08"
+		}
+		minor = -minor
+	}
+
+	points := minor / minorUnits
+	frac := minor % minorUnits
+	if frac == 0 {
+		return fmt.Sprintf("%s%d", sign, points)
+	}
+
+	fracStr := strings.TrimRight(fmt.Sprintf("%02d", frac), "0")
+	return fmt.Sprintf("%s%d.%s", sign, points, fracStr)
+}
+
+// MarshalJSON encodes the amount as a JSON number.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number or a numeric string without going through float64.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*a = parsed
+	return nil
+}
+
+// MarshalText encodes the amount as a decimal string, it lets an Amount be a flag or an environment variable.
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes a decimal string.
+func (a *Amount) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	*a = parsed
+	return nil
+}
@@ -0,0 +1,86 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr error
+	}{
+		{in: "0", want: 0},
+		{in: "500", want: 50000},
+		{in: "500.5", want: 50050},
+		{in: "500.50", want: 50050},
+		{in: "500.500", want: 50050},
+		{in: "0.01", want: 1},
+		{in: ".5", want: 50},
+		{in: "-42.05", want: -4205},
+		{in: "7.2998e2", want: 72998},
+		{in: "1e-2", want: 1},
+		{in: "0.001", wantErr: ErrPrecision},
+		{in: "abc", wantErr: ErrInvalidAmount},
+		{in: "1.2.3", wantErr: ErrInvalidAmount},
+		{in: "", wantErr: ErrInvalidAmount},
+		{in: "-", wantErr: ErrInvalidAmount},
+		{in: "1000000000000000000", wantErr: ErrOverflow},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			got, err := Parse(test.in)
+			if test.wantErr != nil {
+				require.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	require.Equal(t, "0", Amount(0).String())
+	require.Equal(t, "500.5", Amount(50050).String())
+	require.Equal(t, "42", FromPoints(42).String())
+	require.Equal(t, "0.01", FromMinor(1).String())
+	require.Equal(t, "-0.1", Amount(-10).String())
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		Current   Amount `json:"current"`
+		Withdrawn Amount `json:"withdrawn"`
+	}
+	err := json.Unmarshal([]byte(`{"current": 500.5, "withdrawn": "42"}`), &v)
+	require.NoError(t, err)
+	require.Equal(t, Amount(50050), v.Current)
+	require.Equal(t, FromPoints(42), v.Withdrawn)
+
+	bytes, err := json.Marshal(v)
+	require.NoError(t, err)
+	require.Equal(t, `{"current":500.5,"withdrawn":42}`, string(bytes))
+
+	// sums of many small amounts stay exact
+	var sum Amount
+	for i := 0; i < 1000; i++ {
+		sum += Amount(10)
+	}
+	require.Equal(t, FromPoints(100), sum)
+}
+
+func TestText(t *testing.T) {
+	var a Amount
+	require.NoError(t, a.UnmarshalText([]byte("1000.5")))
+	require.Equal(t, Amount(100050), a)
+
+	text, err := a.MarshalText()
+	require.NoError(t, err)
+	require.Equal(t, "1000.5", string(text))
+
+	require.ErrorIs(t, a.UnmarshalText([]byte("ten")), ErrInvalidAmount)
+}
@@ -0,0 +1,136 @@
+// Package password hashes and verifies user passwords with argon2id.
+//
+// Hashes are stored in the PHC string format, so the parameters used to produce
+// a hash travel together with it:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+//
+// Values without the argon2id prefix are treated as legacy plaintext passwords,
+// which are still accepted by Verify but always reported as needing a rehash.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+var ErrInvalidHash = errors.New("invalid password hash format")
+
+// Params describes argon2id cost parameters.
+type Params struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follows the OWASP recommendation for argon2id.
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hash encodes password with DefaultParams.
+func Hash(password string) (string, error) {
+	return HashWithParams(password, DefaultParams)
+}
+
+// HashWithParams encodes password with the given argon2id parameters.
+func HashWithParams(password string, p Params) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		p.Memory,
+		p.Iterations,
+		p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify reports whether password matches the encoded value.
+// needsRehash is true when the encoded value is a legacy plaintext password
+// or was produced with weaker parameters than DefaultParams.
+func Verify(password string, encoded string) (match bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(encoded, argon2idPrefix) {
+		match = subtle.ConstantTimeCompare([]byte(password), []byte(encoded)) == 1
+		return match, true, nil
+	}
+
+	p, salt, key, err := decode(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false, nil
+	}
+
+	return true, isWeaker(p, DefaultParams), nil
+}
+
+func decode(encoded string) (Params, []byte, []byte, error) {
+	var p Params
+
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", "<salt>", "<key>"
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, fmt.Errorf("%w: version: %v", ErrInvalidHash, err)
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("%w: unsupported argon2 version %d", ErrInvalidHash, version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, fmt.Errorf("%w: parameters: %v", ErrInvalidHash, err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("%w: salt: %v", ErrInvalidHash, err)
+	}
+	p.SaltLength = uint32(len(salt))
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("%w: key: %v", ErrInvalidHash, err)
+	}
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
+
+func isWeaker(p Params, target Params) bool {
+	return p.Memory < target.Memory ||
+		p.Iterations < target.Iterations ||
+		p.Parallelism < target.Parallelism ||
+		p.SaltLength < target.SaltLength ||
+		p.KeyLength < target.KeyLength
+}
@@ -0,0 +1,50 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	encoded, err := Hash("qwe123")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=65536,t=3,p=2$"))
+
+	match, needsRehash, err := Verify("qwe123", encoded)
+	require.NoError(t, err)
+	require.True(t, match)
+	require.False(t, needsRehash)
+
+	match, _, err = Verify("qwe1234", encoded)
+	require.NoError(t, err)
+	require.False(t, match)
+}
+
+func TestVerifyLegacyPlaintext(t *testing.T) {
+	match, needsRehash, err := Verify("qwe123", "qwe123")
+	require.NoError(t, err)
+	require.True(t, match)
+	require.True(t, needsRehash)
+
+	match, _, err = Verify("qwe1234", "qwe123")
+	require.NoError(t, err)
+	require.False(t, match)
+}
+
+func TestVerifyWeakerParams(t *testing.T) {
+	weak := Params{Memory: 16 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	encoded, err := HashWithParams("qwe123", weak)
+	require.NoError(t, err)
+
+	match, needsRehash, err := Verify("qwe123", encoded)
+	require.NoError(t, err)
+	require.True(t, match)
+	require.True(t, needsRehash)
+}
+
+func TestVerifyInvalidHash(t *testing.T) {
+	_, _, err := Verify("qwe123", "$argon2id$v=19$broken")
+	require.ErrorIs(t, err, ErrInvalidHash)
+}
@@ -0,0 +1,125 @@
+// Package totp implements time-based one-time passwords (RFC 6238) compatible
+// with authenticator apps: HMAC-SHA1, 6 digits, 30 second steps.
+//
+// Secrets are exchanged as unpadded base32 strings inside an otpauth:// URI,
+// which authenticator apps scan from a QR code.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the length of a code.
+	Digits = 6
+	// Period is the time step a code is valid for.
+	Period = 30 * time.Second
+	// Skew is the number of steps before and after the current one a code is still accepted in,
+	// it tolerates clock drift of the device and the time the user takes to type the code.
+	Skew = 1
+
+	// secretLength is the length of a generated secret in bytes, RFC 4226 recommends 160 bits
+	secretLength = 20
+)
+
+var (
+	ErrInvalidSecret = errors.New("invalid totp secret")
+	ErrInvalidCode   = errors.New("invalid totp code")
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the number of the time step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code of the secret for the time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000), nil
+}
+
+// Validate checks the code against the steps around t and returns the step the code belongs to,
+// the caller stores the step to reject a replay of the same code. ErrInvalidCode is returned
+// if the code matches none of the steps.
+func Validate(secret string, code string, t time.Time) (int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, ErrInvalidCode
+	}
+
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+
+	return 0, ErrInvalidCode
+}
+
+// URI returns the otpauth:// URI of the secret, account is usually the login of the user.
+func URI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
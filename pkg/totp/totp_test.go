@@ -0,0 +1,72 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA1 seed of the RFC 6238 test vectors
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, the last 6 of the 8 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfc6238Secret, Step(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		require.Equal(t, tt.want, code, "unix time %d", tt.unix)
+	}
+
+	_, err := Code("not base32!", 1)
+	require.ErrorIs(t, err, ErrInvalidSecret)
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+	code, err := Code(secret, Step(now))
+	require.NoError(t, err)
+
+	step, err := Validate(secret, code, now)
+	require.NoError(t, err)
+	require.Equal(t, Step(now), step)
+
+	// the previous step is accepted because of the skew, older ones are not
+	step, err = Validate(secret, code, now.Add(Period))
+	require.NoError(t, err)
+	require.Equal(t, Step(now), step)
+	_, err = Validate(secret, code, now.Add(3*Period))
+	require.ErrorIs(t, err, ErrInvalidCode)
+
+	_, err = Validate(secret, "12345", now)
+	require.ErrorIs(t, err, ErrInvalidCode)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Gophermart", "user", "JBSWY3DPEHPK3PXP")
+
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/Gophermart:user", u.Path)
+	require.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	require.Equal(t, "Gophermart", u.Query().Get("issuer"))
+	require.Equal(t, "6", u.Query().Get("digits"))
+}